@@ -0,0 +1,73 @@
+package main
+
+import "time"
+
+// EntryTimes is when an entry was inserted and when it was last read or
+// written.
+type EntryTimes struct {
+	CreatedAt  time.Time `json:"created_at"`
+	AccessedAt time.Time `json:"accessed_at"`
+}
+
+// Ages describes the age spread of a cache's entries. Newest is the most
+// recently used entry and Oldest the least recently used one, both nil when
+// the cache is empty. AvgTTLRemaining is the mean time left, to the second,
+// over the Expiring entries that have a TTL; expired entries not yet removed
+// count as having none left.
+type Ages struct {
+	Newest          *EntryTimes
+	Oldest          *EntryTimes
+	AvgTTLRemaining time.Duration
+	Expiring        int
+}
+
+func (ent *entry[V]) times() *EntryTimes {
+	return &EntryTimes{
+		CreatedAt:  ent.created,
+		AccessedAt: time.Unix(0, ent.accessed.Load()),
+	}
+}
+
+// Ages reports the age spread of the cache's entries. The average TTL comes
+// from a running sum kept on every write, so this doesn't scan the cache.
+func (c *LRUCache[V]) Ages() Ages {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var a Ages
+	if c.head != nil {
+		a.Newest = c.head.times()
+		a.Oldest = c.tail.times()
+	}
+	a.Expiring = c.expiring
+	if c.expiring > 0 {
+		remaining := c.expirySum/int64(c.expiring) - time.Now().Unix()
+		if remaining > 0 {
+			a.AvgTTLRemaining = time.Duration(remaining) * time.Second
+		}
+	}
+	return a
+}
+
+// Ages combines the shards' ages: the newest and oldest entries by last
+// access across all shards, and the average TTL weighted by each shard's
+// number of expiring entries.
+func (s *ShardedLRUCache[V]) Ages() Ages {
+	var a Ages
+	var total time.Duration
+	for _, shard := range s.shards {
+		sa := shard.Ages()
+		if sa.Newest != nil && (a.Newest == nil || sa.Newest.AccessedAt.After(a.Newest.AccessedAt)) {
+			a.Newest = sa.Newest
+		}
+		if sa.Oldest != nil && (a.Oldest == nil || sa.Oldest.AccessedAt.Before(a.Oldest.AccessedAt)) {
+			a.Oldest = sa.Oldest
+		}
+		total += sa.AvgTTLRemaining * time.Duration(sa.Expiring)
+		a.Expiring += sa.Expiring
+	}
+	if a.Expiring > 0 {
+		a.AvgTTLRemaining = total / time.Duration(a.Expiring)
+	}
+	return a
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrNotAppendable is returned by Append when the key holds a value other
+// than a blob, a string or a byte slice, such as any JSON value, or when the
+// cache's value type can hold none of them. The value is left as it is.
+var ErrNotAppendable = errors.New("value is not a string or bytes")
+
+// Append adds data to the end of the blob, string or byte slice stored under
+// key and returns its new length in bytes. An absent or expired key is
+// created holding data, as a blob of defaultContentType if the cache's
+// value type can hold one, with c.DefaultTTL. Appending to an existing key
+// keeps its expiration, as Increment does, so that a log being written to
+// still runs out; set or touch it to extend its lifetime. A blob keeps its
+// content type. A result larger than MaxValueBytes fails with
+// ErrValueTooLarge and appends nothing, and a key that KeyRules reject fails
+// with an error wrapping ErrInvalidKey.
+func (c *LRUCache[V]) Append(key string, data []byte) (int, error) {
+	if err := c.KeyRules.Check(key); err != nil {
+		return 0, err
+	}
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if ok && c.expired(ent, time.Now()) {
+		c.expireLocked(ent)
+		ok = false
+	}
+	if err := c.checkRoom(key); err != nil {
+		return 0, err
+	}
+
+	var next V
+	var length int
+	if !ok {
+		created, isV := newAppendable[V](data)
+		if !isV {
+			return 0, ErrNotAppendable
+		}
+		next, length = created, len(data)
+	} else {
+		// The slices are copied rather than grown in place, since the old
+		// value may be shared, by a reader or by another key. Each write
+		// rehashes and journals the whole value anyway.
+		var appended interface{}
+		switch v := any(ent.value).(type) {
+		case blob:
+			v.Data = append(v.Data[:len(v.Data):len(v.Data)], data...)
+			appended, length = v, len(v.Data)
+		case []byte:
+			v = append(v[:len(v):len(v)], data...)
+			appended, length = v, len(v)
+		case string:
+			v += string(data)
+			appended, length = v, len(v)
+		default:
+			return 0, ErrNotAppendable
+		}
+		var isV bool
+		if next, isV = appended.(V); !isV {
+			return 0, ErrNotAppendable
+		}
+	}
+	if c.MaxValueBytes > 0 && int64(c.sizeOf(next)) > c.MaxValueBytes {
+		return 0, ErrValueTooLarge
+	}
+
+	slog.Debug("cache", "op", "append", "key", key, "bytes", len(data), "length", length)
+	if ok {
+		c.replaceLocked(ent, next)
+	} else {
+		c.setLocked(key, next, c.jitter(c.DefaultTTL))
+	}
+	return length, nil
+}
+
+// newAppendable returns the value Append creates for data: a blob, a byte
+// slice or a string, whichever V can hold first.
+func newAppendable[V any](data []byte) (V, bool) {
+	data = append([]byte(nil), data...)
+	if v, ok := any(blob{ContentType: defaultContentType, Data: data}).(V); ok {
+		return v, true
+	}
+	if v, ok := any(data).(V); ok {
+		return v, true
+	}
+	v, ok := any(string(data)).(V)
+	return v, ok
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"strings"
+)
+
+// defaultContentType is the type given to a stored value whose PUT didn't
+// name one.
+const defaultContentType = "application/octet-stream"
+
+// blob is a non-JSON value stored by PUT: the request body, byte for byte,
+// along with its Content-Type, which GET sends back.
+type blob struct {
+	ContentType string
+	Data        []byte
+}
+
+// MarshalJSON renders a blob inside JSON responses, such as mget results
+// and meta envelopes, as an object holding its type and base64 data.
+func (b blob) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ContentType string `json:"content_type"`
+		Data        []byte `json:"data"`
+	}{b.ContentType, b.Data})
+}
+
+// defaultContentTypeOf names the media type of a stored value, without
+// parameters such as charset: a blob's own, sealedContentType for a sealed
+// value, whose type only its key can reveal, and application/json for
+// anything else, compressed or not.
+func defaultContentTypeOf(value any) string {
+	switch v := value.(type) {
+	case blob:
+		if mediaType, _, err := mime.ParseMediaType(v.ContentType); err == nil {
+			return mediaType
+		}
+		return strings.ToLower(v.ContentType)
+	case sealedValue:
+		return sealedContentType
+	}
+	return "application/json"
+}
+
+// isJSONContentType reports whether a Content-Type header names JSON:
+// application/json or any +json type.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// persistValue prepares a stored value for a snapshot or the WAL, returning
+// the value to encode and, for a blob, its content type. Blobs are encoded
+// as their data in base64 and told apart from JSON values by the content
+// type recorded next to them. Sealed values are encoded the same way, with
+// sealedContentType.
+func persistValue[V any](value V) (jsonValue[V], string) {
+	switch v := any(value).(type) {
+	case blob:
+		if data, ok := any(v.Data).(V); ok {
+			return jsonValue[V]{data}, v.ContentType
+		}
+	case sealedValue:
+		if data, ok := any([]byte(v)).(V); ok {
+			return jsonValue[V]{data}, sealedContentType
+		}
+	}
+	return jsonValue[V]{value}, ""
+}
+
+// restoreValue reverses persistValue.
+func restoreValue[V any](value jsonValue[V], contentType string) V {
+	if contentType == "" {
+		return value.V
+	}
+	raw, ok := any(value.V).(json.RawMessage)
+	if !ok {
+		return value.V
+	}
+	var data []byte
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return value.V
+	}
+	if contentType == sealedContentType {
+		if restored, ok := any(sealedValue(data)).(V); ok {
+			return restored
+		}
+		return value.V
+	}
+	if restored, ok := any(blob{ContentType: contentType, Data: data}).(V); ok {
+		return restored
+	}
+	return value.V
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of calling the origin while its
+// circuit breaker is open.
+var errCircuitOpen = errors.New("origin circuit breaker is open")
+
+// breakerState is where a circuitBreaker is in its cycle.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// circuitBreaker stops calls to a failing backend. It starts closed,
+// letting every call through, and opens after threshold consecutive
+// failures. While open, calls fail at once with errCircuitOpen. Once
+// coolDown has passed it is half-open: a single call is let through as a
+// probe, and while it is outstanding the rest still fail at once. The
+// probe succeeding closes the breaker; it failing opens it for another
+// coolDown.
+type circuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	trips    uint64
+}
+
+func newCircuitBreaker(threshold int, coolDown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, coolDown: coolDown}
+}
+
+// allow reports whether a call may go ahead. A call that is allowed must be
+// followed by done with its outcome.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.coolDown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		slog.Info("cache", "op", "breaker", "state", b.state)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// done records the outcome of a call that allow let through.
+func (b *circuitBreaker) done(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	probe := b.state == breakerHalfOpen
+	b.probing = false
+	if ok {
+		b.failures = 0
+		if probe {
+			b.state = breakerClosed
+			slog.Info("cache", "op", "breaker", "state", b.state)
+		}
+		return
+	}
+	b.failures++
+	if probe || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.trips++
+		slog.Warn("cache", "op", "breaker", "state", b.state, "failures", b.failures, "cool_down", b.coolDown)
+	}
+}
+
+// retryAfter returns how long until an open breaker lets a probe through,
+// or 0 if it isn't open.
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerOpen {
+		return 0
+	}
+	return max(b.coolDown-time.Since(b.openedAt), 0)
+}
+
+// breakerMetrics is a point-in-time copy of a circuitBreaker's state.
+type breakerMetrics struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Trips               uint64 `json:"trips"`
+}
+
+// metrics returns the breaker's state. The breaker is reported open only
+// while it still rejects calls; once coolDown has passed it is half-open,
+// though it only changes state on the next call.
+func (b *circuitBreaker) metrics() (breakerMetrics, breakerState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == breakerOpen && time.Since(b.openedAt) >= b.coolDown {
+		state = breakerHalfOpen
+	}
+	return breakerMetrics{State: state.String(), ConsecutiveFailures: b.failures, Trips: b.trips}, state
+}
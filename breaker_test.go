@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// coolOff moves b's opening back by its coolDown, as if that had passed.
+func coolOff(b *circuitBreaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.openedAt = b.openedAt.Add(-b.coolDown)
+}
+
+func checkBreaker(t *testing.T, b *circuitBreaker, want breakerState) {
+	t.Helper()
+	if m, state := b.metrics(); state != want || m.State != want.String() {
+		t.Fatalf("breaker is %v (%s), want %v", state, m.State, want)
+	}
+}
+
+// failCalls lets n calls through b and fails them.
+func failCalls(t *testing.T, b *circuitBreaker, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if !b.allow() {
+			t.Fatalf("call %d was refused", i)
+		}
+		b.done(false)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(3, time.Hour)
+
+	t.Run("closed to open", func(t *testing.T) {
+		failCalls(t, b, 2)
+		// A success resets the count, so only consecutive failures trip it.
+		b.allow()
+		b.done(true)
+		failCalls(t, b, 2)
+		checkBreaker(t, b, breakerClosed)
+		failCalls(t, b, 1)
+		checkBreaker(t, b, breakerOpen)
+		if b.allow() {
+			t.Error("open breaker let a call through")
+		}
+		if d := b.retryAfter(); d <= 0 || d > time.Hour {
+			t.Errorf("retryAfter = %v, want up to the hour's cool-down", d)
+		}
+	})
+
+	t.Run("half-open to open", func(t *testing.T) {
+		coolOff(b)
+		checkBreaker(t, b, breakerHalfOpen)
+		if !b.allow() {
+			t.Fatal("probe was refused")
+		}
+		if b.allow() {
+			t.Error("second call let through while the probe is outstanding")
+		}
+		b.done(false)
+		checkBreaker(t, b, breakerOpen)
+		if b.allow() {
+			t.Error("breaker reopened by a failed probe let a call through")
+		}
+	})
+
+	t.Run("half-open to closed", func(t *testing.T) {
+		coolOff(b)
+		if !b.allow() {
+			t.Fatal("probe was refused")
+		}
+		b.done(true)
+		checkBreaker(t, b, breakerClosed)
+		if d := b.retryAfter(); d != 0 {
+			t.Errorf("closed breaker retryAfter = %v", d)
+		}
+		for i := 0; i < 5; i++ {
+			if !b.allow() {
+				t.Fatal("closed breaker refused a call")
+			}
+			b.done(true)
+		}
+	})
+
+	if m, _ := b.metrics(); m.Trips != 2 || m.ConsecutiveFailures != 0 {
+		t.Errorf("metrics %+v, want 2 trips and no failures", m)
+	}
+}
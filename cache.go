@@ -0,0 +1,1073 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type entry[V any] struct {
+	key        string
+	value      V
+	expiration time.Time
+	// staleUntil is when the entry is finally removed: expiration plus the
+	// cache's StaleWindow, or the zero time if it never expires. Between the
+	// two, Get serves the entry as stale.
+	staleUntil time.Time
+	// refreshing records that a stale read has already been asked to
+	// refresh the entry; see Item.Refresh.
+	refreshing bool
+	// created is when the key was inserted. accessed, in Unix nanoseconds,
+	// is when it was last read or written; it is atomic because the
+	// read-locked fast path of Get updates it.
+	created  time.Time
+	accessed atomic.Int64
+	// accesses counts the Get hits on the entry; peeks don't count.
+	accesses atomic.Uint64
+	// version is taken from the cache-wide write counter on every insert or
+	// update, so it changes on each write and is never reused for a key, even
+	// across a delete and re-insert.
+	version uint64
+	// etag is a hash of the value, recomputed on every write; see valueETag.
+	etag string
+	// bytes is the value's size as measured by the cache's Sizer, tracked
+	// only when MaxBytes is set.
+	bytes int64
+	// contentType is the value's type as given by the cache's ContentTypeOf;
+	// see ContentTypes.
+	contentType string
+	// pinned keeps the entry from being evicted for capacity; see Pin.
+	pinned bool
+	// freq, lastUse and heapIndex place the entry in the LFU heap; they are
+	// unused under the LRU policy.
+	freq      uint64
+	lastUse   uint64
+	heapIndex int
+	// expiryIndex places the entry in the expiry heap, or is -1 if it isn't
+	// in it because it never expires.
+	expiryIndex int
+	next        *entry[V]
+	prev        *entry[V]
+}
+
+// expired reports whether the entry's TTL has elapsed at now. A zero
+// expiration means the entry never expires.
+func (ent *entry[V]) expired(now time.Time) bool {
+	return !ent.expiration.IsZero() && !ent.expiration.After(now)
+}
+
+// dead reports whether the entry is past its stale window at now, and so due
+// for removal rather than being served stale.
+func (ent *entry[V]) dead(now time.Time) bool {
+	return !ent.staleUntil.IsZero() && !ent.staleUntil.After(now)
+}
+
+// idle reports whether ent has gone longer than IdleTimeout without a read
+// or write at now.
+func (c *LRUCache[V]) idle(ent *entry[V], now time.Time) bool {
+	return c.IdleTimeout > 0 && now.Sub(time.Unix(0, ent.accessed.Load())) > c.IdleTimeout
+}
+
+// expired is ent.expired that also counts an idle entry as expired.
+func (c *LRUCache[V]) expired(ent *entry[V], now time.Time) bool {
+	return ent.expired(now) || c.idle(ent, now)
+}
+
+// dead is ent.dead that also counts an idle entry as dead.
+func (c *LRUCache[V]) dead(ent *entry[V], now time.Time) bool {
+	return ent.dead(now) || c.idle(ent, now)
+}
+
+// expiresAt returns the expiration for an entry written now with the given
+// TTL; a TTL of zero or less gives the zero time, which never expires.
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// jitter applies TTLJitter to ttl. A TTL of zero, which never expires, is
+// left alone, and a jittered TTL is never shorter than a nanosecond.
+func (c *LRUCache[V]) jitter(ttl time.Duration) time.Duration {
+	if c.TTLJitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	random := rand.Float64
+	if c.Rand != nil {
+		random = c.Rand
+	}
+	jittered := time.Duration(float64(ttl) * (1 + c.TTLJitter*(2*random()-1)))
+	if jittered < 1 {
+		jittered = 1
+	}
+	return jittered
+}
+
+// LRUCache is a fixed-capacity, TTL-aware cache of values of type V that
+// evicts the least recently used entry when full, or the least frequently
+// used one when created with NewLFUCache. It is safe for concurrent use.
+type LRUCache[V any] struct {
+	// DefaultTTL is the expiration given to entries that operations such as
+	// Increment create without an explicit TTL. Zero, the default, means such
+	// entries never expire. Set it before the cache is shared between
+	// goroutines.
+	DefaultTTL time.Duration
+
+	// StaleWindow, if positive, keeps entries for that long past their
+	// expiration. During the window GetItem and GetItemCtx still return the
+	// value, flagged as stale; every other operation treats the entry as
+	// expired. The entry is finally removed once the window ends, by the
+	// next lookup that finds it or by the sweeper, unless it is written
+	// again first or evicted for capacity like any other entry. It applies
+	// to entries written after it is set.
+	StaleWindow time.Duration
+	// IdleTimeout, if positive, expires entries that go that long without
+	// being read or written, independently of their TTL: an entry is gone
+	// once either has run out. Peeks don't count as reads. Idle entries get
+	// no stale window, and are reported to OnEvict as EvictReasonIdle.
+	IdleTimeout time.Duration
+
+	// SweepOnly leaves the removal of expired and idle entries to the
+	// sweeper: reads report them as misses without removing them, so a read
+	// that misses one never takes the write lock. Writes to such a key still
+	// replace it. Without a running sweeper, see StartSweeper, expired
+	// entries then stay until evicted for capacity. Set it before the cache
+	// is shared between goroutines.
+	SweepOnly bool
+
+	// TTLJitter, if positive, randomizes the TTL of every write within
+	// ±TTLJitter of the one requested (0.1 for ±10%), so that keys loaded
+	// together don't all expire at once. It applies to explicit TTLs and
+	// DefaultTTL alike; SetCtx skips it for a context from WithExactTTL.
+	// Entries restored from a snapshot or the WAL keep the expiration they
+	// were saved with.
+	TTLJitter float64
+
+	// UndoWindow, if positive, makes Delete, DeleteMany and DeletePrefix
+	// soft: a deleted entry disappears as usual but is kept for that long
+	// so that Undelete can restore it, after which the sweeper, or a later
+	// delete needing the room, drops it. At most capacity deleted entries
+	// are kept; beyond that deletes are hard. Clear is always hard, and
+	// deleted entries are not kept across a restart.
+	UndoWindow time.Duration
+	// Rand returns the random numbers in [0, 1) behind TTLJitter; nil
+	// means math/rand.Float64. Replace it to make jitter deterministic.
+	Rand func() float64
+
+	// OnEvict, if set, is called with the key, value and reason whenever an
+	// entry is evicted to make room (EvictReasonCapacity) or removed because
+	// its TTL elapsed (EvictReasonExpired). Explicit deletes don't trigger
+	// it. The call happens after the entry has been unlinked from the cache
+	// and the lock released, on the goroutine whose operation caused the
+	// removal, so the callback may safely use the cache. Removals made by one
+	// operation are reported in the order they happened; there is no
+	// ordering between callbacks triggered by different goroutines. Set it
+	// before the cache is shared between goroutines.
+	OnEvict func(key string, value V, reason string)
+	// Spill, if set, is called with the key, value and expiration of every
+	// entry evicted to make room, and the time of the eviction, at the same
+	// point as OnEvict, so that the entry can be kept in a slower tier; see
+	// tieredCache. Set it before the cache is shared between goroutines.
+	Spill func(key string, value V, expiration, evicted time.Time)
+
+	// MaxBytes, if positive, caps the approximate total size of the stored
+	// values as measured by Sizer, in addition to the entry-count capacity.
+	// Sizes are only tracked while it is set. Set it before the cache is
+	// shared between goroutines.
+	MaxBytes int64
+	// Sizer measures a value for MaxBytes. It runs under the cache lock, so
+	// it should be cheap. When nil, defaultSizer is used.
+	Sizer func(value V) int
+	// ContentTypeOf names a value's media type for ContentTypes. It runs
+	// under the cache lock, so it should be cheap. When nil,
+	// defaultContentTypeOf is used.
+	ContentTypeOf func(value V) string
+	// MaxValueBytes, if positive, is the largest value, as measured by Sizer,
+	// that Set and SetCtx accept. For the default sizer that is the
+	// JSON-encoded size. Set it before the cache is shared between goroutines.
+	MaxValueBytes int64
+	// MaxListLength, if positive, is the most elements LPush and RPush let
+	// a list grow to.
+	MaxListLength int
+	// KeyRules are checked by every write that can create a key; a write
+	// under a key they reject stores nothing. Set it before the cache is
+	// shared between goroutines.
+	KeyRules KeyRules
+
+	// HighWatermark and LowWatermark, as fractions of the capacity, turn on
+	// batch eviction: once the cache holds more than HighWatermark times its
+	// capacity, it evicts down to LowWatermark times its capacity in one
+	// pass instead of one entry per insert at steady state. The cache then
+	// stays between the two, trading some of its capacity for fewer
+	// evictions. A LowWatermark of 0, the default, disables batch eviction;
+	// a HighWatermark of 0 means 1, so that batches start once the cache is
+	// over capacity.
+	HighWatermark float64
+	LowWatermark  float64
+	// ReclaimExpiredFirst makes every capacity eviction remove an entry
+	// whose TTL has run out, if there is one, rather than the policy's live
+	// victim. Finding one takes a look at the top of the expiry heap, not a
+	// scan: it adds O(log n) to each eviction, and nothing while no entry
+	// has expired. An entry that is only idle isn't in the heap and is not
+	// reclaimed this way, though under LRU it is near the tail anyway. The
+	// reclaimed entry is reported to OnEvict as EvictReasonExpired and is
+	// not spilled. Set it before the cache is shared between goroutines.
+	ReclaimExpiredFirst bool
+	// PinnedOverflow says what a write of a new key does when the cache is
+	// full of pinned entries; see Pin. The default is PinnedReject.
+	PinnedOverflow PinnedOverflow
+
+	policy   Policy
+	capacity int
+	size     int
+	bytes    int64
+	cache    map[string]*entry[V]
+	head     *entry[V]
+	tail     *entry[V]
+	mutex    sync.RWMutex
+	counters cacheCounters
+	// lastVersion is the version assigned to the most recent write.
+	lastVersion uint64
+	// lfu orders entries by use count under PolicyLFU; useClock stamps each
+	// use so that ties are broken by recency.
+	lfu      lfuHeap[V]
+	useClock uint64
+	// wal, if attached, journals every write; nil otherwise. walSeq is the
+	// sequence number of the last record journaled, and journaled queues
+	// the records until the write lock is released; see journal.
+	wal       *WAL[V]
+	walSeq    uint64
+	journaled []walEntry[V]
+	// evicted queues removals for OnEvict until the write lock is released.
+	evicted []evictedEntry[V]
+	// subscribers receive an Event for every change; see Subscribe.
+	subscribers map[chan Event]struct{}
+
+	// contentTypes counts the entries by their contentType; see
+	// ContentTypes.
+	contentTypes map[string]int
+	// pinned counts the pinned entries.
+	pinned int
+
+	// tombstones holds the entries deleted within UndoWindow, by key.
+	tombstones map[string]tombstone[V]
+	// negatives holds when each negatively cached key's record runs out;
+	// see SetNegative.
+	negatives map[string]time.Time
+
+	// expiries holds the entries that expire, soonest due first; see
+	// sweep. expirySum is the sum of their expirations in Unix seconds, and
+	// expiring their number; see Ages.
+	expiries  expiryHeap[V]
+	expirySum int64
+	expiring  int
+
+	// inflight holds the GetOrSet and GetOrLoad calls currently running, by
+	// key.
+	inflight map[string]*inflightCall[V]
+
+	// stop is non-nil while background tasks (the sweeper, periodic
+	// snapshots) are running; closing it asks them to exit. background is
+	// released once they all have. running records which tasks are active.
+	stop       chan struct{}
+	background sync.WaitGroup
+	running    map[string]bool
+}
+
+func NewLRUCache[V any](capacity int) *LRUCache[V] {
+	return newCache[V](capacity, PolicyLRU)
+}
+
+func newCache[V any](capacity int, policy Policy) *LRUCache[V] {
+	return &LRUCache[V]{
+		policy:   policy,
+		capacity: capacity,
+		cache:    make(map[string]*entry[V]),
+	}
+}
+
+// Item is a copy of an entry's value and metadata, taken under the lock.
+type Item[V any] struct {
+	Value V
+	// Version identifies the write that produced Value; see CompareAndSwap.
+	Version uint64
+	// ETag is a strong entity tag derived from Value's content.
+	ETag string
+	// Expiration is when the entry expires; the zero time means never.
+	Expiration time.Time
+	// Stale is set when Value has expired but is still within the cache's
+	// StaleWindow. Refresh is set on the first such read only, telling that
+	// caller to fetch a fresh value and store it; later stale reads are
+	// served the old value without being asked to refresh.
+	Stale   bool
+	Refresh bool
+	// Accesses is how many reads have hit the entry; CreatedAt is when the
+	// key was inserted.
+	Accesses  uint64
+	CreatedAt time.Time
+	// Negative is set on a miss for a key recorded by SetNegative as known
+	// not to exist.
+	Negative bool
+}
+
+func (ent *entry[V]) item() Item[V] {
+	return Item[V]{
+		Value:      ent.value,
+		Version:    ent.version,
+		ETag:       ent.etag,
+		Expiration: ent.expiration,
+		Accesses:   ent.accesses.Load(),
+		CreatedAt:  ent.created,
+	}
+}
+
+// Get returns the value stored under key and marks it as most recently used.
+func (c *LRUCache[V]) Get(key string) (V, bool) {
+	item, ok := c.GetItem(key)
+	return item.Value, ok
+}
+
+// GetItem is Get that also returns the entry's metadata.
+func (c *LRUCache[V]) GetItem(key string) (Item[V], bool) {
+	item, ok, _ := c.GetItemCtx(context.Background(), key)
+	return item, ok
+}
+
+// GetItemCtx is GetItem that gives up with ErrCanceled if ctx is done while
+// waiting for either lock.
+//
+// Lookups start under the read lock so that misses and hits on the entry
+// already at the head of the list can proceed concurrently. Only when the LRU
+// order has to change, or an expired entry has to be removed, is the write
+// lock taken, after which the lookup is repeated since the entry may have
+// changed in between. With SweepOnly, an expired entry is a miss under the
+// read lock.
+func (c *LRUCache[V]) GetItemCtx(ctx context.Context, key string) (Item[V], bool, error) {
+	if err := c.rlockCtx(ctx); err != nil {
+		return Item[V]{}, false, err
+	}
+	now := time.Now()
+	ent, ok := c.cache[key]
+	if !ok || (c.SweepOnly && c.dead(ent, now)) {
+		negative := c.negative(key, now)
+		c.mutex.RUnlock()
+		slog.Debug("cache", "op", "get", "key", key, "hit", false, "expired", ok, "negative", negative)
+		c.counters.misses.Add(1)
+		return Item[V]{Negative: negative}, false, nil
+	}
+	if c.policy == PolicyLRU && ent == c.head && !c.expired(ent, now) {
+		ent.accessed.Store(now.UnixNano())
+		ent.accesses.Add(1)
+		item := ent.item()
+		c.mutex.RUnlock()
+		slog.Debug("cache", "op", "get", "key", key, "hit", true)
+		c.counters.hits.Add(1)
+		return item, true, nil
+	}
+	c.mutex.RUnlock()
+
+	if err := c.lockCtx(ctx); err != nil {
+		return Item[V]{}, false, err
+	}
+	defer c.unlock()
+
+	ent, stale := c.lookupLocked(key, true)
+	if ent == nil {
+		return Item[V]{Negative: c.negative(key, time.Now())}, false, nil
+	}
+	item := ent.item()
+	if stale {
+		item.Stale = true
+		item.Refresh = !ent.refreshing
+		ent.refreshing = true
+	}
+	return item, true, nil
+}
+
+// Peek returns the value stored under key without marking it as recently
+// used.
+func (c *LRUCache[V]) Peek(key string) (V, bool) {
+	item, ok := c.PeekItem(key)
+	return item.Value, ok
+}
+
+// PeekItem is Peek that also returns the entry's metadata. Expired entries
+// are reported as misses but, unlike Get, are left in place for a later Get
+// or the sweeper to remove, which keeps peeking entirely under the read lock.
+// Peeks are not counted in the hit/miss metrics.
+func (c *LRUCache[V]) PeekItem(key string) (Item[V], bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	now := time.Now()
+	ent, ok := c.cache[key]
+	if !ok || c.expired(ent, now) {
+		return Item[V]{Negative: c.negative(key, now)}, false
+	}
+	return ent.item(), true
+}
+
+// Contains reports whether key holds a live entry, without reordering it.
+// Like Peek, it leaves expired entries in place and isn't counted in metrics.
+func (c *LRUCache[V]) Contains(key string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	ent, ok := c.cache[key]
+	return ok && !c.expired(ent, time.Now())
+}
+
+// CompareAndSwap replaces the value under key only if the key is live and
+// its current version equals expectedVersion. It reports whether the swap
+// happened; an absent or expired key never matches.
+func (c *LRUCache[V]) CompareAndSwap(key string, expectedVersion uint64, newValue V, ttl time.Duration) bool {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if !ok || c.expired(ent, time.Now()) || ent.version != expectedVersion {
+		slog.Debug("cache", "op", "cas", "key", key, "swapped", false)
+		return false
+	}
+	c.setLocked(key, newValue, c.jitter(ttl))
+	return true
+}
+
+// Revert undoes the write that gave key the version written, putting back
+// prev, the item read just before that write, with its expiration and
+// version, as if the write had never happened. It reports whether it did; a
+// key that has been written again since, or has expired, is left alone, as
+// is one whose prev has expired in the meantime, which is deleted instead.
+// Reverting counts as neither an insert nor an update.
+func (c *LRUCache[V]) Revert(key string, written uint64, prev Item[V]) bool {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if !ok || c.expired(ent, time.Now()) || ent.version != written {
+		slog.Debug("cache", "op", "revert", "key", key, "reverted", false)
+		return false
+	}
+	if prev.Expiration.IsZero() || prev.Expiration.After(time.Now()) {
+		slog.Debug("cache", "op", "revert", "key", key, "reverted", true)
+		ent.value = prev.Value
+		c.setExpirationAt(ent, prev.Expiration)
+		ent.version = prev.Version
+		ent.etag = valueETag(prev.Value)
+		c.resizeLocked(ent)
+		c.journalSet(ent)
+		c.publish(EventUpdate, key)
+		return true
+	}
+	slog.Debug("cache", "op", "revert", "key", key, "reverted", true, "expired", true)
+	c.deleteLocked(ent)
+	return true
+}
+
+// CompareAndDelete removes key only if it is live and its current version
+// equals expectedVersion. It reports whether the key was deleted and, if
+// not, whether that is because it was absent or expired rather than a
+// version mismatch.
+func (c *LRUCache[V]) CompareAndDelete(key string, expectedVersion uint64) (deleted, found bool) {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if !ok || c.expired(ent, time.Now()) {
+		slog.Debug("cache", "op", "cad", "key", key, "found", false)
+		return false, false
+	}
+	if ent.version != expectedVersion {
+		slog.Debug("cache", "op", "cad", "key", key, "deleted", false)
+		return false, true
+	}
+	slog.Debug("cache", "op", "cad", "key", key, "deleted", true)
+	c.deleteLocked(ent)
+	return true, true
+}
+
+// GetMany looks up every key under a single write lock, applying the same
+// expiration and LRU rules as Get, and returns the values that were found.
+// Missing and expired keys are absent from the result.
+func (c *LRUCache[V]) GetMany(keys []string) map[string]V {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	found := make(map[string]V, len(keys))
+	for _, key := range keys {
+		if ent := c.getLocked(key); ent != nil {
+			found[key] = ent.value
+		}
+	}
+	return found
+}
+
+// getLocked is the write-locked lookup path of Get. It returns the live entry
+// for key, or nil on a miss. The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) getLocked(key string) *entry[V] {
+	ent, _ := c.lookupLocked(key, false)
+	return ent
+}
+
+// lookupLocked is getLocked that, with allowStale, also returns an expired
+// entry still within its stale window, reporting it as stale. Expired
+// entries past their window are removed, unless SweepOnly is set; those
+// within it are left for a later stale read. The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) lookupLocked(key string, allowStale bool) (*entry[V], bool) {
+	ent, ok := c.cache[key]
+	if !ok {
+		slog.Debug("cache", "op", "get", "key", key, "hit", false)
+		c.counters.misses.Add(1)
+		return nil, false
+	}
+	now := time.Now()
+	if !c.expired(ent, now) {
+		slog.Debug("cache", "op", "get", "key", key, "hit", true)
+		c.counters.hits.Add(1)
+		ent.accesses.Add(1)
+		c.promote(ent)
+		return ent, false
+	}
+	if c.dead(ent, now) {
+		slog.Debug("cache", "op", "get", "key", key, "hit", false, "expired", true)
+		c.counters.misses.Add(1)
+		if !c.SweepOnly {
+			c.expireLocked(ent)
+		}
+		return nil, false
+	}
+	if !allowStale {
+		slog.Debug("cache", "op", "get", "key", key, "hit", false, "stale", true)
+		c.counters.misses.Add(1)
+		return nil, false
+	}
+	slog.Debug("cache", "op", "get", "key", key, "hit", true, "stale", true)
+	c.counters.hits.Add(1)
+	ent.accesses.Add(1)
+	c.promote(ent)
+	return ent, true
+}
+
+// setExpiration gives ent the expiration for ttl from now, with its stale
+// window, and clears any pending refresh.
+func (c *LRUCache[V]) setExpiration(ent *entry[V], ttl time.Duration) {
+	c.setExpirationAt(ent, expiresAt(ttl))
+}
+
+// setExpirationAt is setExpiration for an expiration given as a time; the
+// zero time never expires.
+func (c *LRUCache[V]) setExpirationAt(ent *entry[V], expiration time.Time) {
+	c.untrackExpiration(ent)
+	ent.expiration = expiration
+	ent.staleUntil = ent.expiration
+	if !ent.expiration.IsZero() && c.StaleWindow > 0 {
+		ent.staleUntil = ent.expiration.Add(c.StaleWindow)
+	}
+	ent.refreshing = false
+	c.trackExpiration(ent)
+}
+
+// Set stores value under key for expiration; zero means the entry never
+// expires. A value larger than MaxValueBytes, a key that KeyRules reject, or
+// a new key that pinned entries leave no room for, is not stored; use SetCtx
+// to learn about it.
+func (c *LRUCache[V]) Set(key string, value V, expiration time.Duration) {
+	if err := c.checkWrite(key, value); err != nil {
+		slog.Warn("cache", "op", "set", "key", key, "err", err)
+		return
+	}
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if err := c.checkRoom(key); err != nil {
+		slog.Warn("cache", "op", "set", "key", key, "err", err)
+		return
+	}
+	c.setLocked(key, value, c.jitter(expiration))
+}
+
+// SetNX stores value under key only if the key is absent or expired, and
+// reports whether it did. The check and the write happen under one lock, so
+// exactly one of several concurrent callers succeeds. A key that KeyRules
+// reject, or one that pinned entries leave no room for, is never stored.
+func (c *LRUCache[V]) SetNX(key string, value V, expiration time.Duration) bool {
+	if err := c.KeyRules.Check(key); err != nil {
+		slog.Warn("cache", "op", "setnx", "key", key, "err", err)
+		return false
+	}
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if ent, ok := c.cache[key]; ok {
+		if !c.expired(ent, time.Now()) {
+			slog.Debug("cache", "op", "setnx", "key", key, "set", false)
+			return false
+		}
+		c.expireLocked(ent)
+	}
+	if err := c.checkRoom(key); err != nil {
+		slog.Debug("cache", "op", "setnx", "key", key, "set", false, "err", err)
+		return false
+	}
+	c.setLocked(key, value, c.jitter(expiration))
+	return true
+}
+
+// BulkEntry is a single write in a SetMany batch.
+type BulkEntry[V any] struct {
+	Key        string
+	Value      V
+	Expiration time.Duration
+}
+
+// SetMany stores every entry under a single lock acquisition, in order, and
+// reports for each whether it inserted a new key (true) or updated an
+// existing one (false). Entries whose key KeyRules reject, or that pinned
+// entries leave no room for, are skipped: their error is set in errs, which
+// is nil if every entry was stored.
+func (c *LRUCache[V]) SetMany(entries []BulkEntry[V]) (inserted []bool, errs []error) {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	inserted = make([]bool, len(entries))
+	for i, e := range entries {
+		err := c.KeyRules.Check(e.Key)
+		if err == nil {
+			err = c.checkRoom(e.Key)
+		}
+		if err != nil {
+			slog.Warn("cache", "op", "set", "key", e.Key, "err", err)
+			if errs == nil {
+				errs = make([]error, len(entries))
+			}
+			errs[i] = err
+			continue
+		}
+		inserted[i] = c.setLocked(e.Key, e.Value, c.jitter(e.Expiration))
+	}
+	return inserted, errs
+}
+
+// setLocked stores value under key and reports whether the key was newly
+// inserted. The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) setLocked(key string, value V, expiration time.Duration) bool {
+	delete(c.negatives, key)
+	c.lastVersion++
+	if ent, ok := c.cache[key]; ok {
+		// Update existing entry
+		slog.Debug("cache", "op", "update", "key", key)
+		c.counters.updates.Add(1)
+		ent.value = value
+		c.setExpiration(ent, expiration)
+		ent.version = c.lastVersion
+		ent.etag = valueETag(value)
+		c.promote(ent)
+		c.resizeLocked(ent)
+		c.journalSet(ent)
+		c.publish(EventUpdate, key)
+		c.evictIfNeeded()
+		return false
+	}
+
+	// Add new entry
+	slog.Debug("cache", "op", "insert", "key", key)
+	c.counters.inserts.Add(1)
+	newEntry := &entry[V]{
+		key:         key,
+		value:       value,
+		version:     c.lastVersion,
+		etag:        valueETag(value),
+		created:     time.Now(),
+		expiryIndex: -1,
+	}
+	newEntry.accessed.Store(newEntry.created.UnixNano())
+	c.setExpiration(newEntry, expiration)
+	c.cache[key] = newEntry
+	c.addToFront(newEntry)
+	c.lfuAdd(newEntry)
+	c.size++
+	c.resizeLocked(newEntry)
+	c.journalSet(newEntry)
+	c.publish(EventInsert, key)
+
+	c.evictIfNeeded()
+	return true
+}
+
+func (c *LRUCache[V]) Delete(key string) {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if ent, ok := c.cache[key]; ok {
+		slog.Debug("cache", "op", "delete", "key", key, "found", true)
+		c.deleteLocked(ent)
+	} else {
+		slog.Debug("cache", "op", "delete", "key", key, "found", false)
+	}
+}
+
+// DeleteMany removes every listed key under a single lock acquisition and
+// returns how many were present. Missing keys are skipped.
+func (c *LRUCache[V]) DeleteMany(keys []string) int {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	deleted := 0
+	for _, key := range keys {
+		ent, ok := c.cache[key]
+		if !ok {
+			continue
+		}
+		c.deleteLocked(ent)
+		deleted++
+	}
+	slog.Debug("cache", "op", "mdelete", "keys", len(keys), "deleted", deleted)
+	return deleted
+}
+
+// DeletePrefix removes every entry whose key starts with prefix and returns
+// how many were removed. Keys aren't ordered in the map, so this is a scan of
+// the whole cache, O(n) in its size however few keys match. The scan only
+// collects the matching keys, under the read lock, so reads carry on while it
+// runs; the keys are then deleted sweepBatchSize at a time under the write
+// lock, released in between so that a large delete doesn't stall other
+// requests. A matching key written after the scan may survive it, and one
+// written again before its batch is deleted anyway.
+func (c *LRUCache[V]) DeletePrefix(prefix string) int {
+	start := time.Now()
+
+	c.mutex.RLock()
+	var keys []string
+	for key := range c.cache {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	c.mutex.RUnlock()
+
+	removed := 0
+	for len(keys) > 0 {
+		batch := keys[:min(sweepBatchSize, len(keys))]
+		keys = keys[len(batch):]
+		c.mutex.Lock()
+		for _, key := range batch {
+			if ent, ok := c.cache[key]; ok {
+				c.deleteLocked(ent)
+				removed++
+			}
+		}
+		c.unlock()
+		runtime.Gosched()
+	}
+
+	slog.Debug("cache", "op", "delete_prefix", "prefix", prefix, "removed", removed, "duration", time.Since(start))
+	return removed
+}
+
+// Clear removes every entry and returns how many were removed. Counters are
+// left untouched.
+func (c *LRUCache[V]) Clear() int {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	removed := c.size
+	c.cache = make(map[string]*entry[V])
+	c.head = nil
+	c.tail = nil
+	c.lfu = nil
+	c.size = 0
+	c.bytes = 0
+	c.contentTypes = nil
+	c.pinned = 0
+	c.expirySum, c.expiring = 0, 0
+	c.expiries = nil
+	c.tombstones = nil
+	c.negatives = nil
+	c.journal(walRecord[V]{Op: walOpClear})
+	c.publish(EventClear, "")
+
+	slog.Info("cache", "op", "clear", "removed", removed)
+	return removed
+}
+
+// Keys returns the keys of all live entries, most recently used first.
+// Expired entries that haven't been removed yet are skipped.
+func (c *LRUCache[V]) Keys() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	now := time.Now()
+	keys := make([]string, 0, c.size)
+	for ent := c.head; ent != nil; ent = ent.next {
+		if !c.expired(ent, now) {
+			keys = append(keys, ent.key)
+		}
+	}
+	return keys
+}
+
+// Len returns the number of entries currently held. Expired entries are
+// removed lazily, so the count includes entries that have expired but have
+// not yet been touched by a Get since.
+func (c *LRUCache[V]) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.size
+}
+
+// Capacity returns the maximum number of entries the cache holds before
+// evicting.
+func (c *LRUCache[V]) Capacity() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.capacity
+}
+
+// Resize sets the capacity to newCapacity, which must be positive, and
+// returns how many entries it evicted to fit. When shrinking, victims are
+// chosen by the eviction policy as for any other capacity eviction, and
+// reported to OnEvict and Spill as such. The new capacity applies to writes
+// from the moment Resize is called; the evictions run in batches of
+// sweepBatchSize with the lock released in between, so that shrinking a
+// large cache doesn't stall other operations. Pinned entries are kept, so
+// shrinking below their number leaves the cache over its new capacity.
+func (c *LRUCache[V]) Resize(newCapacity int) int {
+	if newCapacity < 1 {
+		newCapacity = 1
+	}
+	c.mutex.Lock()
+	old := c.capacity
+	c.capacity = newCapacity
+	evicted := 0
+	for {
+		stuck := false
+		for batch := 0; batch < sweepBatchSize && c.size > c.capacity; batch++ {
+			if stuck = !c.evictOldest(); stuck {
+				break
+			}
+			evicted++
+		}
+		if stuck || c.size <= c.capacity {
+			break
+		}
+		c.unlock()
+		runtime.Gosched()
+		c.mutex.Lock()
+	}
+	c.unlock()
+
+	slog.Info("cache", "op", "resize", "from", old, "to", newCapacity, "evicted", evicted)
+	return evicted
+}
+
+func (c *LRUCache[V]) removeEntry(ent *entry[V]) {
+	delete(c.cache, ent.key)
+	c.untrackExpiration(ent)
+	c.removeNode(ent)
+	c.lfuRemove(ent)
+	if ent.pinned {
+		c.pinned--
+	}
+	c.size--
+	c.bytes -= ent.bytes
+	c.countContentType(ent.contentType, -1)
+}
+
+func (c *LRUCache[V]) removeNode(ent *entry[V]) {
+	if ent.prev != nil {
+		ent.prev.next = ent.next
+	} else {
+		c.head = ent.next
+	}
+	if ent.next != nil {
+		ent.next.prev = ent.prev
+	} else {
+		c.tail = ent.prev
+	}
+}
+
+func (c *LRUCache[V]) moveToFront(ent *entry[V]) {
+	if ent == c.head {
+		return
+	}
+	c.removeNode(ent)
+	c.addToFront(ent)
+}
+
+func (c *LRUCache[V]) addToFront(ent *entry[V]) {
+	ent.next = c.head
+	ent.prev = nil
+	if c.head != nil {
+		c.head.prev = ent
+	}
+	c.head = ent
+	if c.tail == nil {
+		c.tail = ent
+	}
+}
+
+// evictIfNeeded evicts until the cache is within both its entry capacity and
+// its byte budget, possibly removing several entries. The entry used most
+// recently is never the victim, so a single value larger than MaxBytes is
+// kept on its own. It stops early, over budget, if only pinned entries are
+// left to evict.
+func (c *LRUCache[V]) evictIfNeeded() {
+	if c.LowWatermark > 0 {
+		high := c.HighWatermark
+		if high <= 0 {
+			high = 1
+		}
+		if c.size > int(high*float64(c.capacity)) {
+			c.evictToLowWatermark()
+		}
+	}
+	for c.size > c.capacity || (c.MaxBytes > 0 && c.bytes > c.MaxBytes && c.size > 1) {
+		if !c.evictOldest() {
+			break
+		}
+	}
+}
+
+// resizeLocked re-measures and re-types an entry's value after it was
+// stored or changed.
+func (c *LRUCache[V]) resizeLocked(ent *entry[V]) {
+	contentType := c.contentTypeOf(ent.value)
+	if contentType != ent.contentType {
+		c.countContentType(ent.contentType, -1)
+		c.countContentType(contentType, 1)
+		ent.contentType = contentType
+	}
+	if c.MaxBytes <= 0 {
+		return
+	}
+	n := c.sizeOf(ent.value)
+	c.bytes += int64(n) - ent.bytes
+	ent.bytes = int64(n)
+}
+
+// sizeOf measures value with the cache's Sizer.
+func (c *LRUCache[V]) sizeOf(value V) int {
+	if c.Sizer != nil {
+		return c.Sizer(value)
+	}
+	return defaultSizer(value)
+}
+
+// ErrValueTooLarge is returned when a value exceeds MaxValueBytes.
+var ErrValueTooLarge = errors.New("value too large")
+
+// checkWrite returns the error for storing value under key, if any: one
+// from KeyRules or ErrValueTooLarge.
+func (c *LRUCache[V]) checkWrite(key string, value V) error {
+	if err := c.KeyRules.Check(key); err != nil {
+		return err
+	}
+	return c.checkValueSize(value)
+}
+
+// checkValueSize returns ErrValueTooLarge if value exceeds MaxValueBytes. It
+// is called before the lock is taken, since sizing may be expensive.
+func (c *LRUCache[V]) checkValueSize(value V) error {
+	if c.MaxValueBytes > 0 && int64(c.sizeOf(value)) > c.MaxValueBytes {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// Bytes returns the approximate total size of the stored values, or 0 when
+// MaxBytes is not set.
+func (c *LRUCache[V]) Bytes() int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.bytes
+}
+
+// ContentTypes returns how many entries hold each media type of value, as
+// named by ContentTypeOf.
+func (c *LRUCache[V]) ContentTypes() map[string]int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	counts := make(map[string]int, len(c.contentTypes))
+	for contentType, n := range c.contentTypes {
+		counts[contentType] = n
+	}
+	return counts
+}
+
+// contentTypeOf types value with the cache's ContentTypeOf.
+func (c *LRUCache[V]) contentTypeOf(value V) string {
+	if c.ContentTypeOf != nil {
+		return c.ContentTypeOf(value)
+	}
+	return defaultContentTypeOf(value)
+}
+
+// countContentType adds delta to the count of entries of contentType.
+func (c *LRUCache[V]) countContentType(contentType string, delta int) {
+	if contentType == "" {
+		return
+	}
+	if c.contentTypes == nil {
+		c.contentTypes = make(map[string]int)
+	}
+	if c.contentTypes[contentType] += delta; c.contentTypes[contentType] <= 0 {
+		delete(c.contentTypes, contentType)
+	}
+}
+
+// evictToLowWatermark evicts victims until the cache holds no more than
+// LowWatermark times its capacity, always keeping the entry used most
+// recently.
+func (c *LRUCache[V]) evictToLowWatermark() {
+	low := int(c.LowWatermark * float64(c.capacity))
+	evicted := 0
+	for c.size > low && c.size > 1 && c.evictOldest() {
+		evicted++
+	}
+	slog.Debug("cache", "op", "evict_batch", "evicted", evicted, "size", c.size)
+}
+
+// evictOldest evicts the policy's victim: the tail of the list under LRU, or
+// the least frequently used entry under LFU, skipping pinned entries. With
+// ReclaimExpiredFirst, an expired entry goes instead if there is one. It
+// reports whether it removed anything.
+func (c *LRUCache[V]) evictOldest() bool {
+	if c.ReclaimExpiredFirst && c.reclaimExpired() {
+		return true
+	}
+	ent := c.victim()
+	if ent == nil {
+		return false
+	}
+	slog.Debug("cache", "op", "evict", "key", ent.key, "reason", EvictReasonCapacity)
+	c.counters.evictions.Add(1)
+	c.removeEntry(ent)
+	c.queueEviction(ent, EvictReasonCapacity)
+	c.publish(EventEvict, ent.key)
+	return true
+}
+
+// reclaimExpired removes the entry due to expire first if its TTL has run
+// out, reporting whether there was one. The heap is ordered by the end of
+// the stale window, which is the same order unless StaleWindow has changed.
+// The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) reclaimExpired() bool {
+	if len(c.expiries) == 0 || !c.expiries[0].expired(time.Now()) {
+		return false
+	}
+	ent := c.expiries[0]
+	slog.Debug("cache", "op", "evict", "key", ent.key, "reason", EvictReasonExpired)
+	c.expireLocked(ent)
+	return true
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestDeletePrefixUnderGets deletes a prefix while other goroutines keep
+// promoting entries, which must neither slow the delete down to a crawl nor
+// leave matching keys behind.
+func TestDeletePrefixUnderGets(t *testing.T) {
+	const keys = 20000
+	c := NewLRUCache[int](2 * keys)
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "t" + strconv.Itoa(i%2) + ":" + strconv.Itoa(i)
+		c.Set(names[i], i, 0)
+	}
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			for i := r; ; i += 7 {
+				select {
+				case <-stop:
+					return
+				default:
+					c.Get(names[i%keys])
+				}
+			}
+		}(r)
+	}
+
+	done := make(chan int, 1)
+	go func() { done <- c.DeletePrefix("t0:") }()
+	var removed int
+	select {
+	case removed = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("DeletePrefix did not finish under concurrent Gets")
+	}
+	close(stop)
+	readers.Wait()
+
+	if removed != keys/2 {
+		t.Errorf("removed %d keys, want %d", removed, keys/2)
+	}
+	for _, key := range c.Keys() {
+		if strings.HasPrefix(key, "t0:") {
+			t.Fatalf("key %q survived DeletePrefix", key)
+		}
+	}
+	if got := c.Len(); got != keys/2 {
+		t.Errorf("Len() = %d, want %d", got, keys/2)
+	}
+}
+
+// checkList walks c's recency list from head to tail and back and fails t
+// unless the links agree both ways and the list holds exactly c.size
+// entries, all of them in the map.
+func checkList[V any](t *testing.T, c *LRUCache[V]) {
+	t.Helper()
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var forward []string
+	var prev *entry[V]
+	for ent := c.head; ent != nil; ent = ent.next {
+		if ent.prev != prev {
+			t.Fatalf("entry %q: prev link is wrong", ent.key)
+		}
+		if c.cache[ent.key] != ent {
+			t.Fatalf("entry %q is in the list but not the map", ent.key)
+		}
+		forward = append(forward, ent.key)
+		prev = ent
+	}
+	if c.tail != prev {
+		t.Fatalf("tail is not the last entry reached from head")
+	}
+	n := 0
+	for ent := c.tail; ent != nil; ent = ent.prev {
+		if want := forward[len(forward)-1-n]; ent.key != want {
+			t.Fatalf("walking back, entry %d is %q, want %q", n, ent.key, want)
+		}
+		n++
+	}
+	if len(forward) != c.size || n != c.size {
+		t.Fatalf("list holds %d entries forward and %d back, size is %d", len(forward), n, c.size)
+	}
+}
+
+func TestMoveToFrontHeadKeepsList(t *testing.T) {
+	for _, policy := range []Policy{PolicyLRU, PolicyLFU} {
+		c := newCache[int](4, policy)
+		for i, key := range []string{"a", "b", "c", "d"} {
+			c.Set(key, i, 0)
+		}
+		for i := 0; i < 10; i++ {
+			c.Get("d")
+			c.mutex.Lock()
+			c.moveToFront(c.head)
+			c.unlock()
+		}
+		checkList(t, c)
+		if got := c.Keys(); strings.Join(got, ",") != "d,c,b,a" {
+			t.Errorf("policy %d: after head hits, order is %v", policy, got)
+		}
+
+		c.Get("a")
+		c.Get("a")
+		checkList(t, c)
+		if got := c.Keys(); strings.Join(got, ",") != "a,d,c,b" {
+			t.Errorf("policy %d: after promoting the tail, order is %v", policy, got)
+		}
+	}
+}
+
+// BenchmarkGetHotKey reads one key over and over. Under LRU the hits are
+// served under the read lock; under LFU each one takes the write lock and
+// moves the entry to the front of the list, which for the head is a no-op.
+func BenchmarkGetHotKey(b *testing.B) {
+	for _, name := range []string{"lru", "lfu"} {
+		policy, _ := ParsePolicy(name)
+		b.Run(name, func(b *testing.B) {
+			c := newCache[int](1024, policy)
+			for i := 0; i < 1024; i++ {
+				c.Set("key"+strconv.Itoa(i), i, 0)
+			}
+			c.Set("hot", 0, 0)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Get("hot")
+			}
+		})
+	}
+	b.Run("move-to-front", func(b *testing.B) {
+		c := NewLRUCache[int](16)
+		for i := 0; i < 16; i++ {
+			c.Set("key"+strconv.Itoa(i), i, 0)
+		}
+		c.mutex.Lock()
+		defer c.unlock()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			c.moveToFront(c.head)
+		}
+	})
+}
+
+// BenchmarkGetParallel measures concurrent Get throughput on the paths that
+// share the read lock, hits on the head entry and misses, against hits that
+// reorder the list and so take the write lock, which is how every Get ran
+// when the cache used a plain Mutex.
+func BenchmarkGetParallel(b *testing.B) {
+	const keys = 1024
+	c := NewLRUCache[int](keys)
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "key" + strconv.Itoa(i)
+		c.Set(names[i], i, 0)
+	}
+	hot := names[keys-1]
+
+	b.Run("read-lock/head-hit", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				c.Get(hot)
+			}
+		})
+	})
+	b.Run("read-lock/miss", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				c.Get("absent")
+			}
+		})
+	})
+	b.Run("write-lock/promote", func(b *testing.B) {
+		var next atomic.Uint64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				c.Get(names[next.Add(1)%keys])
+			}
+		})
+	})
+}
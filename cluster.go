@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clusterVirtualNodes is how many points each node gets on the hash ring.
+// More points spread keys more evenly between nodes.
+const clusterVirtualNodes = 160
+
+// ClusterNodeError is returned by ClusterClient when the node a key maps to
+// can't be reached or answers with an error.
+type ClusterNodeError struct {
+	Node string
+	Err  error
+}
+
+func (e *ClusterNodeError) Error() string { return "node " + e.Node + ": " + e.Err.Error() }
+
+func (e *ClusterNodeError) Unwrap() error { return e.Err }
+
+// ClusterClient spreads keys over several cache servers by consistent
+// hashing and talks to each over the HTTP API. Adding or removing a node
+// only moves the keys on its part of the ring, about 1/n of them.
+//
+// Every key has exactly one node. A request for a key whose node is down
+// fails with a ClusterNodeError rather than going to another node, which
+// would only hold a copy that disappears once the node is back. Keys
+// containing a slash can't be addressed through the API, on one node or
+// several.
+type ClusterClient struct {
+	// Token, if set, is sent as a bearer token, as -auth-token requires.
+	Token string
+
+	client *http.Client
+	nodes  []string
+	ring   []ringPoint
+}
+
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+// NewClusterClient returns a client for the servers at nodes, base URLs
+// such as http://10.0.0.1:8080. A nil client means http.DefaultClient. The
+// order of nodes doesn't matter, but every client of one cluster must be
+// given the same set.
+func NewClusterClient(nodes []string, client *http.Client) (*ClusterClient, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("cluster needs at least one node")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := &ClusterClient{client: client, ring: make([]ringPoint, 0, len(nodes)*clusterVirtualNodes)}
+	seen := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		node = strings.TrimRight(node, "/")
+		u, err := url.Parse(node)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid node %q: must be an http or https URL", node)
+		}
+		if seen[node] {
+			return nil, fmt.Errorf("duplicate node %q", node)
+		}
+		seen[node] = true
+		for i := 0; i < clusterVirtualNodes; i++ {
+			c.ring = append(c.ring, ringPoint{hash: ringHash(node + "#" + strconv.Itoa(i)), node: len(c.nodes)})
+		}
+		c.nodes = append(c.nodes, node)
+	}
+	sort.Slice(c.ring, func(i, j int) bool { return c.ring[i].hash < c.ring[j].hash })
+	return c, nil
+}
+
+// ringHash is FNV-1a followed by MurmurHash3's finalizer. FNV alone leaves
+// strings that differ only in their last bytes, such as the points of one
+// node, close together on the ring.
+func ringHash(s string) uint64 {
+	x := fnv64a(s)
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Node returns the node that key maps to: the owner of the first point on
+// the ring at or after the key's hash.
+func (c *ClusterClient) Node(key string) string {
+	h := ringHash(key)
+	i := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= h })
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.nodes[c.ring[i].node]
+}
+
+// Get returns the body the key's node serves for it, reporting false if the
+// key is absent.
+func (c *ClusterClient) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if err := c.check(key, resp); err != nil {
+		return nil, false, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, &ClusterNodeError{Node: c.Node(key), Err: err}
+	}
+	return data, true, nil
+}
+
+// Set stores value under key on its node for ttl; zero means the entry
+// never expires.
+func (c *ClusterClient) Set(ctx context.Context, key string, value json.RawMessage, ttl time.Duration) error {
+	header := http.Header{"Content-Type": {"application/json"}, "X-Cache-TTL": {ttl.String()}}
+	resp, err := c.do(ctx, http.MethodPut, key, header, value)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return c.check(key, resp)
+}
+
+// Delete removes key from its node. Deleting an absent key is not an error.
+func (c *ClusterClient) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return c.check(key, resp)
+}
+
+func (c *ClusterClient) do(ctx context.Context, method, key string, header http.Header, body []byte) (*http.Response, error) {
+	node := c.Node(key)
+	req, err := http.NewRequestWithContext(ctx, method, node+"/cache/"+url.PathEscape(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, &ClusterNodeError{Node: node, Err: err}
+	}
+	return resp, nil
+}
+
+// check turns an unsuccessful response into an error carrying the message
+// from the API's error body, if it has one.
+func (c *ClusterClient) check(key string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	var body errorResponse
+	err := fmt.Errorf("%s %s", resp.Request.Method, resp.Status)
+	if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body) == nil && body.Error != "" {
+		err = fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Status, body.Error)
+	}
+	return &ClusterNodeError{Node: c.Node(key), Err: err}
+}
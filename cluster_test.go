@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestNode starts a cache server serving the key routes ClusterClient
+// uses, backed by its own cache.
+func newTestNode() (*httptest.Server, *LRUCache[interface{}]) {
+	cache := NewLRUCache[interface{}](1000)
+	r := mux.NewRouter()
+	r.HandleFunc("/cache/{key}", cacheGetHandler(cache, 0, nil)).Methods("GET")
+	r.HandleFunc("/cache/{key}", cacheSetHandler(cache, 0, 0, nil, nil)).Methods("PUT")
+	r.HandleFunc("/cache/{key}", cacheDeleteHandler(cache)).Methods("DELETE")
+	return httptest.NewServer(r), cache
+}
+
+func TestClusterClient(t *testing.T) {
+	servers := make([]*httptest.Server, 3)
+	caches := make(map[string]*LRUCache[interface{}], len(servers))
+	urls := make([]string, len(servers))
+	for i := range servers {
+		server, cache := newTestNode()
+		defer server.Close()
+		servers[i], urls[i] = server, server.URL
+		caches[server.URL] = cache
+	}
+	client, err := NewClusterClient(urls, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keys := make([]string, 300)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		if err := client.Set(ctx, keys[i], json.RawMessage(strconv.Itoa(i)), time.Minute); err != nil {
+			t.Fatalf("Set(%q): %v", keys[i], err)
+		}
+	}
+
+	// Each key lives on exactly the node it maps to, and every node gets
+	// a share.
+	perNode := make(map[string]int)
+	for i, key := range keys {
+		node := client.Node(key)
+		perNode[node]++
+		for url, cache := range caches {
+			if got := cache.Contains(key); got != (url == node) {
+				t.Errorf("key %q on node %s: Contains = %v, maps to %s", key, url, got, node)
+			}
+		}
+		data, ok, err := client.Get(ctx, key)
+		if err != nil || !ok || string(data) != strconv.Itoa(i) {
+			t.Errorf("Get(%q) = %q, %v, %v; want %d", key, data, ok, err, i)
+		}
+	}
+	for _, url := range urls {
+		if perNode[url] == 0 {
+			t.Errorf("node %s owns none of %d keys", url, len(keys))
+		}
+	}
+
+	if err := client.Delete(ctx, keys[0]); err != nil {
+		t.Fatalf("Delete(%q): %v", keys[0], err)
+	}
+	if _, ok, err := client.Get(ctx, keys[0]); ok || err != nil {
+		t.Errorf("Get(%q) after Delete = %v, %v; want a miss", keys[0], ok, err)
+	}
+
+	// Take one node down: its keys fail with a ClusterNodeError naming it,
+	// without being rerouted, and the others' keys still work.
+	down := servers[1].URL
+	servers[1].Close()
+	for _, key := range keys[1:] {
+		_, _, getErr := client.Get(ctx, key)
+		setErr := client.Set(ctx, key, json.RawMessage(`0`), time.Minute)
+		if client.Node(key) != down {
+			if getErr != nil || setErr != nil {
+				t.Errorf("key %q on a live node: Get err %v, Set err %v", key, getErr, setErr)
+			}
+			continue
+		}
+		for _, err := range []error{getErr, setErr} {
+			var nodeErr *ClusterNodeError
+			if !errors.As(err, &nodeErr) || nodeErr.Node != down {
+				t.Errorf("key %q on the down node: err %v, want a ClusterNodeError for %s", key, err, down)
+			}
+		}
+		for url, cache := range caches {
+			if url != down && cache.Contains(key) {
+				t.Errorf("key %q of the down node was rerouted to %s", key, url)
+			}
+		}
+	}
+}
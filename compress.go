@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// gzipWriters recycles gzip writers, which are expensive to allocate.
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// gzipValue is a value stored gzip-compressed by compressedCache: the gzip
+// of its JSON encoding. It marshals back to the original JSON, so snapshots
+// and the WAL record the value itself rather than its compressed bytes.
+type gzipValue []byte
+
+func (g gzipValue) MarshalJSON() ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(g))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
+
+// compressedCache wraps a Cache so that values whose JSON encoding is at
+// least minBytes long are stored gzip-compressed and transparently
+// decompressed when read. Smaller values, and values that don't shrink, are
+// stored as they are, as are blobs. Values read back from a compressed entry are the
+// json.RawMessage of their JSON encoding; a json.RawMessage is compressed as
+// is, so it reads back byte for byte.
+type compressedCache struct {
+	Cache[interface{}]
+	minBytes int
+}
+
+func newCompressedCache(cache Cache[interface{}], minBytes int) *compressedCache {
+	return &compressedCache{Cache: cache, minBytes: minBytes}
+}
+
+// compress returns the value to store for value.
+func (c *compressedCache) compress(value interface{}) interface{} {
+	if _, ok := value.(blob); ok {
+		// Blobs keep their content type and are stored as sent.
+		return value
+	}
+	raw, ok := value.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(value); err != nil {
+			return value
+		}
+	}
+	if len(raw) < c.minBytes {
+		return value
+	}
+	var buf bytes.Buffer
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(&buf)
+	zw.Write(raw)
+	if err := zw.Close(); err != nil || buf.Len() >= len(raw) {
+		return value
+	}
+	return gzipValue(buf.Bytes())
+}
+
+// decompress returns the original value for a stored one.
+func decompress(value interface{}) (interface{}, error) {
+	g, ok := value.(gzipValue)
+	if !ok {
+		return value, nil
+	}
+	raw, err := g.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(raw), nil
+}
+
+// decompressItem decompresses item in place. A value that fails to
+// decompress is logged and reported as a miss.
+func decompressItem(key string, item Item[interface{}], ok bool) (Item[interface{}], bool) {
+	if !ok {
+		return item, false
+	}
+	v, err := decompress(item.Value)
+	if err != nil {
+		slog.Error("cache", "op", "decompress", "key", key, "err", err)
+		return Item[interface{}]{}, false
+	}
+	item.Value = v
+	return item, true
+}
+
+// decodeStored implements storedDecoder.
+func (c *compressedCache) decodeStored(key string, value interface{}) (interface{}, error) {
+	if d, ok := c.Cache.(storedDecoder); ok {
+		var err error
+		if value, err = d.decodeStored(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return decompress(value)
+}
+
+func (c *compressedCache) Get(key string) (interface{}, bool) {
+	item, ok := c.GetItem(key)
+	return item.Value, ok
+}
+
+func (c *compressedCache) GetItem(key string) (Item[interface{}], bool) {
+	item, ok := c.Cache.GetItem(key)
+	return decompressItem(key, item, ok)
+}
+
+func (c *compressedCache) GetCtx(ctx context.Context, key string) (interface{}, bool, error) {
+	item, ok, err := c.GetItemCtx(ctx, key)
+	return item.Value, ok, err
+}
+
+func (c *compressedCache) GetItemCtx(ctx context.Context, key string) (Item[interface{}], bool, error) {
+	item, ok, err := c.Cache.GetItemCtx(ctx, key)
+	if err != nil {
+		return Item[interface{}]{}, false, err
+	}
+	item, ok = decompressItem(key, item, ok)
+	return item, ok, nil
+}
+
+func (c *compressedCache) GetMany(keys []string) map[string]interface{} {
+	found := c.Cache.GetMany(keys)
+	for key, value := range found {
+		item, ok := decompressItem(key, Item[interface{}]{Value: value}, true)
+		if !ok {
+			delete(found, key)
+			continue
+		}
+		found[key] = item.Value
+	}
+	return found
+}
+
+func (c *compressedCache) Peek(key string) (interface{}, bool) {
+	item, ok := c.PeekItem(key)
+	return item.Value, ok
+}
+
+func (c *compressedCache) PeekItem(key string) (Item[interface{}], bool) {
+	item, ok := c.Cache.PeekItem(key)
+	return decompressItem(key, item, ok)
+}
+
+func (c *compressedCache) Random() (string, interface{}, bool) {
+	key, value, ok := c.Cache.Random()
+	item, ok := decompressItem(key, Item[interface{}]{Value: value}, ok)
+	return key, item.Value, ok
+}
+
+func (c *compressedCache) GetOrLoad(key string, loader func() (interface{}, time.Duration, error)) (interface{}, error) {
+	value, err := c.Cache.GetOrLoad(key, func() (interface{}, time.Duration, error) {
+		value, ttl, err := loader()
+		if err != nil {
+			return nil, 0, err
+		}
+		return c.compress(value), ttl, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decompress(value)
+}
+
+func (c *compressedCache) Set(key string, value interface{}, expiration time.Duration) {
+	c.Cache.Set(key, c.compress(value), expiration)
+}
+
+func (c *compressedCache) SetCtx(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return c.Cache.SetCtx(ctx, key, c.compress(value), expiration)
+}
+
+func (c *compressedCache) Revert(key string, written uint64, prev Item[interface{}]) bool {
+	prev.Value = c.compress(prev.Value)
+	return c.Cache.Revert(key, written, prev)
+}
+
+func (c *compressedCache) CompareAndSwap(key string, expectedVersion uint64, newValue interface{}, ttl time.Duration) bool {
+	return c.Cache.CompareAndSwap(key, expectedVersion, c.compress(newValue), ttl)
+}
+
+func (c *compressedCache) SetNX(key string, value interface{}, expiration time.Duration) bool {
+	return c.Cache.SetNX(key, c.compress(value), expiration)
+}
+
+func (c *compressedCache) Transaction(ops []Op[interface{}]) error {
+	compressed := make([]Op[interface{}], len(ops))
+	for i, op := range ops {
+		if op.Kind == OpSet {
+			op.Value = c.compress(op.Value)
+		}
+		compressed[i] = op
+	}
+	return c.Cache.Transaction(compressed)
+}
+
+func (c *compressedCache) SetMany(entries []BulkEntry[interface{}]) ([]bool, []error) {
+	compressed := make([]BulkEntry[interface{}], len(entries))
+	for i, e := range entries {
+		e.Value = c.compress(e.Value)
+		compressed[i] = e
+	}
+	return c.Cache.SetMany(compressed)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// benchmarkPayload returns a JSON document of about 8 KiB shaped like a
+// typical API response: many records with repeated field names and values.
+func benchmarkPayload() json.RawMessage {
+	var sb strings.Builder
+	sb.WriteString(`{"items":[`)
+	for i := 0; i < 80; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `{"id":%d,"name":"item-%d","status":"active","tags":["alpha","beta"],"price":%d.99}`, i, i, i%50)
+	}
+	sb.WriteString(`]}`)
+	return json.RawMessage(sb.String())
+}
+
+// BenchmarkCompression stores and reads back the same payload with and
+// without compressedCache. stored-B/value is the size the cache accounts
+// for each value, which is what compression saves; ns/op is what it costs.
+func BenchmarkCompression(b *testing.B) {
+	payload := benchmarkPayload()
+	const keys = 256
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "key" + strconv.Itoa(i)
+	}
+	stores := []struct {
+		name string
+		wrap func(Cache[interface{}]) Cache[interface{}]
+	}{
+		{"plain", func(c Cache[interface{}]) Cache[interface{}] { return c }},
+		{"gzip", func(c Cache[interface{}]) Cache[interface{}] { return newCompressedCache(c, 1024) }},
+	}
+	for _, st := range stores {
+		newStore := func() (*LRUCache[interface{}], Cache[interface{}]) {
+			cache := NewLRUCache[interface{}](keys)
+			// Sizes are only tracked under a byte budget.
+			cache.MaxBytes = 1 << 40
+			return cache, st.wrap(cache)
+		}
+		b.Run(st.name+"/set", func(b *testing.B) {
+			cache, store := newStore()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				store.Set(names[i%keys], payload, 0)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(cache.Bytes())/float64(cache.Len()), "stored-B/value")
+		})
+		b.Run(st.name+"/get", func(b *testing.B) {
+			_, store := newStore()
+			for _, name := range names {
+				store.Set(name, payload, 0)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, ok := store.Get(names[i%keys]); !ok {
+					b.Fatal("miss")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// loadConfigFile sets the flags of fs from the config file at path, a JSON
+// object or a YAML mapping whose keys are flag names without the dash, such
+// as "max-value-bytes". Values are strings, numbers or booleans, in the
+// same form as on the command line ("30s" for a duration), or lists of them
+// for the comma-separated flags. A flag given on the command line keeps its
+// value. A key that names no flag is an error, reported with every other
+// unknown key before anything is set.
+//
+// Only a subset of YAML is understood: one flat mapping of plain, single-
+// or double-quoted scalars, with lists written either as [a, b] or as
+// "- a" lines under the key, and # comments. Anything else, such as a
+// nested mapping or a multi-line string, is an error.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		values, err = parseJSONConfig(data)
+	case ".yaml", ".yml":
+		values, err = parseYAMLConfig(data)
+	default:
+		return errors.New("config file name must end in .json, .yaml or .yml")
+	}
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(values))
+	var unknown []string
+	for name := range values {
+		if fs.Lookup(name) == nil || name == "config" {
+			unknown = append(unknown, name)
+		}
+		names = append(names, name)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", "))
+	}
+	sort.Strings(names)
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, name := range names {
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// parseJSONConfig flattens a JSON config object into flag values.
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := decodeJSON(data, &raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for name, v := range raw {
+		value, err := configValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// configValue renders a decoded JSON value as a flag value.
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, ok := item.([]interface{}); ok {
+				return "", errors.New("lists can't be nested")
+			}
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case nil:
+		return "", errors.New("null is not a value; leave the setting out instead")
+	}
+	return "", errors.New("must be a string, number, boolean or list")
+}
+
+// parseYAMLConfig parses the YAML subset described at loadConfigFile into
+// flag values.
+func parseYAMLConfig(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	var listKey string
+	var list []string
+	flush := func() {
+		if listKey != "" {
+			values[listKey] = strings.Join(list, ",")
+			listKey, list = "", nil
+		}
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		n := i + 1
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+		if indented := line[0] == ' ' || line[0] == '\t'; indented || strings.HasPrefix(line, "- ") || line == "-" {
+			item := strings.TrimSpace(line)
+			if listKey == "" || (item != "-" && !strings.HasPrefix(item, "- ")) {
+				return nil, fmt.Errorf("line %d: nested values are not supported", n)
+			}
+			value, err := yamlScalar(strings.TrimSpace(strings.TrimPrefix(item, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			list = append(list, value)
+			continue
+		}
+		flush()
+
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected \"name: value\"", n)
+		}
+		name = strings.TrimSpace(name)
+		if _, dup := values[name]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, name)
+		}
+		rest = strings.TrimSpace(rest)
+		var value string
+		var err error
+		switch {
+		case rest == "":
+			// A list follows on the next lines, or the value is empty.
+			listKey = name
+			values[name] = ""
+			continue
+		case strings.HasPrefix(rest, "["):
+			value, err = yamlFlowList(rest)
+		default:
+			value, err = yamlScalar(rest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		values[name] = value
+	}
+	flush()
+	return values, nil
+}
+
+// stripYAMLComment removes a # comment, which starts the line or follows a
+// space, from line, leaving any # inside quotes alone.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlScalar returns the value of a plain or quoted YAML scalar.
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s == "~" || s == "null" || s == "Null" || s == "NULL":
+		return "", errors.New("null is not a value; leave the setting out instead")
+	case strings.HasPrefix(s, "{") || strings.HasPrefix(s, "|") || strings.HasPrefix(s, ">") ||
+		strings.HasPrefix(s, "&") || strings.HasPrefix(s, "*") || strings.HasPrefix(s, "!"):
+		return "", fmt.Errorf("unsupported YAML value %s", s)
+	}
+	return s, nil
+}
+
+// yamlFlowList returns the items of a [a, b] list joined with commas.
+func yamlFlowList(s string) (string, error) {
+	if !strings.HasSuffix(s, "]") {
+		return "", errors.New("unterminated list")
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	if inner == "" {
+		return "", nil
+	}
+	var items []string
+	for _, item := range splitYAMLFlow(inner) {
+		value, err := yamlScalar(strings.TrimSpace(item))
+		if err != nil {
+			return "", err
+		}
+		items = append(items, value)
+	}
+	return strings.Join(items, ","), nil
+}
+
+// splitYAMLFlow splits the inside of a [a, b] list at the commas outside
+// quotes.
+func splitYAMLFlow(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
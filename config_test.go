@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYAMLConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want map[string]string
+		err  string
+	}{
+		{
+			name: "scalars",
+			yaml: "---\ncapacity: 100\nttl: 30s\nsweep: true\n",
+			want: map[string]string{"capacity": "100", "ttl": "30s", "sweep": "true"},
+		},
+		{
+			name: "quotes",
+			yaml: "a: \"x: y\"\nb: 'it''s'\nc: \"tab\\there\"\nd: ''\n",
+			want: map[string]string{"a": "x: y", "b": "it's", "c": "tab\there", "d": ""},
+		},
+		{
+			name: "comments",
+			yaml: "# leading\na: 1 # trailing\nb: \"# kept\" # dropped\nc: x#y\n\t# indented\n",
+			want: map[string]string{"a": "1", "b": "# kept", "c": "x#y"},
+		},
+		{
+			name: "flow list",
+			yaml: "peers: [a:1, 'b:2', \"c,d\"]\nnone: []\n",
+			want: map[string]string{"peers": "a:1,b:2,c,d", "none": ""},
+		},
+		{
+			name: "block list",
+			yaml: "peers:\n  - a:1\n  # between\n  - 'b:2'\n- c:3\nttl: 1m\n",
+			want: map[string]string{"peers": "a:1,b:2,c:3", "ttl": "1m"},
+		},
+		{
+			name: "empty value",
+			yaml: "prefix:\nttl: 1m\n",
+			want: map[string]string{"prefix": "", "ttl": "1m"},
+		},
+		{"nested mapping", "a:\n  b: 1\n", nil, "line 2: nested values are not supported"},
+		{"indented without list", "a: 1\n  - x\n", nil, "line 2: nested values are not supported"},
+		{"item without key", "- x\n", nil, "line 1: nested values are not supported"},
+		{"no colon", "a 1\n", nil, `line 1: expected "name: value"`},
+		{"duplicate", "a: 1\na: 2\n", nil, "line 2: a is set twice"},
+		{"unterminated single quote", "a: 'x\n", nil, "line 1: unterminated string"},
+		{"unterminated double quote", "a: \"x\n", nil, "line 1: invalid syntax"},
+		{"unterminated list", "a: [x, y\n", nil, "line 1: unterminated list"},
+		{"null", "a: ~\n", nil, "line 1: null is not a value"},
+		{"inline mapping", "a: {b: 1}\n", nil, "line 1: unsupported YAML value"},
+		{"block string", "a: |\n", nil, "line 1: unsupported YAML value"},
+		{"anchor", "a: &x 1\n", nil, "line 1: unsupported YAML value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAMLConfig([]byte(tt.yaml))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newConfigFlags returns a flag set like main's, parsed from args.
+func newConfigFlags(t *testing.T, args ...string) (*flag.FlagSet, *int, *time.Duration, *string) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	capacity := fs.Int("capacity", 10, "")
+	ttl := fs.Duration("ttl", 0, "")
+	peers := fs.String("peers", "", "")
+	fs.String("config", "", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs, capacity, ttl, peers
+}
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "capacity: 50\nttl: 30s\npeers:\n  - a:1\n  - b:2\n",
+		"config.json": `{"capacity": 50, "ttl": "30s", "peers": ["a:1", "b:2"]}`,
+	}
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			fs, capacity, ttl, peers := newConfigFlags(t, "-ttl", "1m")
+			if err := loadConfigFile(fs, writeConfig(t, name, data)); err != nil {
+				t.Fatal(err)
+			}
+			if *capacity != 50 || *peers != "a:1,b:2" {
+				t.Errorf("capacity %d, peers %q; want 50, a:1,b:2", *capacity, *peers)
+			}
+			if *ttl != time.Minute {
+				t.Errorf("ttl %v, want the command line's 1m", *ttl)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileRejects(t *testing.T) {
+	tests := []struct {
+		name, file, data, err string
+	}{
+		{"unknown keys", "c.yaml", "capacity: 50\nzeta: 1\nalpha: 2\n", "unknown settings: alpha, zeta"},
+		{"config itself", "c.json", `{"config": "other.json"}`, "unknown settings: config"},
+		{"bad value", "c.yaml", "capacity: lots\n", "capacity: "},
+		{"nested JSON list", "c.json", `{"peers": [["a"]]}`, "peers: lists can't be nested"},
+		{"JSON null", "c.json", `{"peers": null}`, "peers: null is not a value"},
+		{"extension", "c.toml", "capacity = 50\n", "must end in .json, .yaml or .yml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, capacity, _, _ := newConfigFlags(t)
+			err := loadConfigFile(fs, writeConfig(t, tt.file, tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+			if tt.name == "unknown keys" && *capacity != 10 {
+				t.Errorf("capacity set to %d despite the unknown keys", *capacity)
+			}
+		})
+	}
+}
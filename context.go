@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCanceled is returned by the Ctx variants of cache operations when the
+// context is done before the cache lock could be acquired. The error also
+// wraps the context's own error, so errors.Is matches context.Canceled or
+// context.DeadlineExceeded as well.
+var ErrCanceled = errors.New("cache operation canceled")
+
+// acquire takes a lock with lock, giving up when ctx is done first. An
+// uncontended lock is taken with tryLock without spawning anything; a
+// contended one is waited for in a goroutine, which releases it again with
+// release if the caller has already given up by the time it is granted.
+func acquire(ctx context.Context, tryLock func() bool, lock, release func()) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
+	}
+	if tryLock() {
+		return nil
+	}
+	if ctx.Done() == nil {
+		lock()
+		return nil
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		lock()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-acquired
+			release()
+		}()
+		return fmt.Errorf("%w: %w", ErrCanceled, ctx.Err())
+	}
+}
+
+// lockCtx acquires c.mutex for writing, or returns ErrCanceled if ctx is done
+// first. On success the caller must release it with c.unlock.
+func (c *LRUCache[V]) lockCtx(ctx context.Context) error {
+	return acquire(ctx, c.mutex.TryLock, c.mutex.Lock, c.unlock)
+}
+
+// rlockCtx acquires c.mutex for reading, or returns ErrCanceled if ctx is
+// done first.
+func (c *LRUCache[V]) rlockCtx(ctx context.Context) error {
+	return acquire(ctx, c.mutex.TryRLock, c.mutex.RLock, c.mutex.RUnlock)
+}
+
+// GetCtx is Get that gives up with ErrCanceled if ctx is done while waiting
+// for the lock.
+func (c *LRUCache[V]) GetCtx(ctx context.Context, key string) (V, bool, error) {
+	item, ok, err := c.GetItemCtx(ctx, key)
+	return item.Value, ok, err
+}
+
+// SetCtx is Set that gives up with ErrCanceled if ctx is done while waiting
+// for the lock. Once the lock is held the write always completes. A value
+// larger than MaxValueBytes is rejected with ErrValueTooLarge, a key that
+// KeyRules reject with an error wrapping ErrInvalidKey, and a new key that
+// pinned entries leave no room for with ErrAllPinned. See WithExactTTL for
+// opting out of TTLJitter and WithPin for pinning the entry.
+func (c *LRUCache[V]) SetCtx(ctx context.Context, key string, value V, expiration time.Duration) error {
+	if err := c.checkWrite(key, value); err != nil {
+		return err
+	}
+	if err := c.lockCtx(ctx); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if err := c.checkRoom(key); err != nil {
+		return err
+	}
+	if !exactTTL(ctx) {
+		expiration = c.jitter(expiration)
+	}
+	c.setLocked(key, value, expiration)
+	if ent, ok := c.cache[key]; ok && pinRequested(ctx) {
+		c.pinLocked(ent)
+	}
+	return nil
+}
+
+type exactTTLKey struct{}
+
+// WithExactTTL returns a context that makes SetCtx store the TTL it is given
+// as is, without LRUCache.TTLJitter.
+func WithExactTTL(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exactTTLKey{}, true)
+}
+
+func exactTTL(ctx context.Context) bool {
+	exact, _ := ctx.Value(exactTTLKey{}).(bool)
+	return exact
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrEncrypted is returned by the operations that work on a stored value in
+// place, such as Increment, Append, MergePatch and the list and hash
+// operations, when values are encrypted: the cache can't read them without
+// the key.
+var ErrEncrypted = errors.New("operation not supported on encrypted values")
+
+// sealedContentType marks a sealedValue in snapshots, the WAL and the second
+// tier, as a blob's content type marks a blob; see persistValue.
+const sealedContentType = "application/x-lru-cache-sealed"
+
+// sealedValue is a value stored encrypted by encryptedCache: the nonce
+// followed by the AES-GCM ciphertext of the value's encoding. It is
+// persisted as it is, so snapshots and the WAL hold only ciphertext.
+type sealedValue []byte
+
+// Kinds of value a sealedValue may hold, recorded in the first byte of its
+// plaintext.
+const (
+	sealedJSON byte = 'j'
+	sealedBlob byte = 'b'
+	sealedGzip byte = 'z'
+)
+
+// newAEAD returns AES-GCM for a hex-encoded key of 16, 24 or 32 bytes.
+func newAEAD(hexKey string) (cipher.AEAD, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be hex-encoded: %v", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("encryption key must be 32, 48 or 64 hex digits for AES-128, AES-192 or AES-256, not %d", len(hexKey))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptedCache wraps a Cache so that every value is stored encrypted with
+// AES-GCM and decrypted when read, so neither memory, snapshots, the WAL
+// nor a second tier hold it in the clear. Keys, TTLs and the like are not
+// encrypted. Each value is sealed with a fresh random 96-bit nonce and its
+// key as additional data, so a value can't be passed off as another key's;
+// with random nonces, one key should seal no more than about 2^32 values,
+// so rotate it well before then. Rotating means starting without the old
+// snapshot and WAL: values sealed with another key fail to decrypt, which
+// is logged and reported as a miss. Entries stored before encryption was
+// turned on, such as from an older snapshot, stay in the clear until they
+// are next written.
+//
+// Operations that change a value in place, inside the cache, fail with
+// ErrEncrypted. Wrap an encryptedCache with compressedCache, not the other
+// way round, so that values are compressed before they are encrypted.
+type encryptedCache struct {
+	Cache[interface{}]
+	aead cipher.AEAD
+}
+
+func newEncryptedCache(cache Cache[interface{}], aead cipher.AEAD) *encryptedCache {
+	return &encryptedCache{Cache: cache, aead: aead}
+}
+
+// seal returns the value to store under key for value. A value that is
+// already sealed, such as one being promoted from the second tier, is
+// stored as it is.
+func (c *encryptedCache) seal(key string, value interface{}) interface{} {
+	var plain []byte
+	switch v := value.(type) {
+	case sealedValue:
+		return value
+	case blob:
+		plain = append([]byte{sealedBlob}, v.ContentType...)
+		plain = append(plain, 0)
+		plain = append(plain, v.Data...)
+	case gzipValue:
+		plain = append([]byte{sealedGzip}, v...)
+	case json.RawMessage:
+		plain = append([]byte{sealedJSON}, v...)
+	default:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			// Values from the API are always one of the above.
+			slog.Error("cache", "op", "encrypt", "key", key, "err", err)
+			raw = []byte("null")
+		}
+		plain = append([]byte{sealedJSON}, raw...)
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic("encrypt: reading random nonce: " + err.Error())
+	}
+	return sealedValue(c.aead.Seal(nonce, nonce, plain, []byte(key)))
+}
+
+// open returns the original value for one stored under key. Values that
+// aren't sealed are returned as they are.
+func (c *encryptedCache) open(key string, value interface{}) (interface{}, error) {
+	s, ok := value.(sealedValue)
+	if !ok {
+		return value, nil
+	}
+	n := c.aead.NonceSize()
+	if len(s) < n {
+		return nil, errors.New("sealed value too short")
+	}
+	plain, err := c.aead.Open(nil, s[:n], s[n:], []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) == 0 {
+		return nil, errors.New("sealed value is empty")
+	}
+	switch data := plain[1:]; plain[0] {
+	case sealedJSON:
+		return json.RawMessage(data), nil
+	case sealedGzip:
+		return gzipValue(data), nil
+	case sealedBlob:
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return blob{ContentType: string(data[:i]), Data: data[i+1:]}, nil
+		}
+	}
+	return nil, fmt.Errorf("sealed value of unknown kind %q", plain[0])
+}
+
+// decodeStored implements storedDecoder.
+func (c *encryptedCache) decodeStored(key string, value interface{}) (interface{}, error) {
+	if d, ok := c.Cache.(storedDecoder); ok {
+		var err error
+		if value, err = d.decodeStored(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return c.open(key, value)
+}
+
+// openItem decrypts item in place and recomputes its ETag, which the cache
+// took from the ciphertext and so would change on every write. A value that
+// fails to decrypt is logged and reported as a miss.
+func (c *encryptedCache) openItem(key string, item Item[interface{}], ok bool) (Item[interface{}], bool) {
+	if !ok {
+		return item, false
+	}
+	if _, sealed := item.Value.(sealedValue); !sealed {
+		return item, true
+	}
+	v, err := c.open(key, item.Value)
+	if err != nil {
+		slog.Error("cache", "op", "decrypt", "key", key, "err", err)
+		return Item[interface{}]{}, false
+	}
+	item.Value = v
+	item.ETag = valueETag(v)
+	return item, true
+}
+
+func (c *encryptedCache) Get(key string) (interface{}, bool) {
+	item, ok := c.GetItem(key)
+	return item.Value, ok
+}
+
+func (c *encryptedCache) GetItem(key string) (Item[interface{}], bool) {
+	item, ok := c.Cache.GetItem(key)
+	return c.openItem(key, item, ok)
+}
+
+func (c *encryptedCache) GetCtx(ctx context.Context, key string) (interface{}, bool, error) {
+	item, ok, err := c.GetItemCtx(ctx, key)
+	return item.Value, ok, err
+}
+
+func (c *encryptedCache) GetItemCtx(ctx context.Context, key string) (Item[interface{}], bool, error) {
+	item, ok, err := c.Cache.GetItemCtx(ctx, key)
+	if err != nil {
+		return Item[interface{}]{}, false, err
+	}
+	item, ok = c.openItem(key, item, ok)
+	return item, ok, nil
+}
+
+func (c *encryptedCache) GetMany(keys []string) map[string]interface{} {
+	found := c.Cache.GetMany(keys)
+	for key, value := range found {
+		item, ok := c.openItem(key, Item[interface{}]{Value: value}, true)
+		if !ok {
+			delete(found, key)
+			continue
+		}
+		found[key] = item.Value
+	}
+	return found
+}
+
+func (c *encryptedCache) Peek(key string) (interface{}, bool) {
+	item, ok := c.PeekItem(key)
+	return item.Value, ok
+}
+
+func (c *encryptedCache) PeekItem(key string) (Item[interface{}], bool) {
+	item, ok := c.Cache.PeekItem(key)
+	return c.openItem(key, item, ok)
+}
+
+func (c *encryptedCache) Random() (string, interface{}, bool) {
+	key, value, ok := c.Cache.Random()
+	item, ok := c.openItem(key, Item[interface{}]{Value: value}, ok)
+	return key, item.Value, ok
+}
+
+func (c *encryptedCache) GetOrLoad(key string, loader func() (interface{}, time.Duration, error)) (interface{}, error) {
+	value, err := c.Cache.GetOrLoad(key, func() (interface{}, time.Duration, error) {
+		value, ttl, err := loader()
+		if err != nil {
+			return nil, 0, err
+		}
+		return c.seal(key, value), ttl, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.open(key, value)
+}
+
+func (c *encryptedCache) Set(key string, value interface{}, expiration time.Duration) {
+	c.Cache.Set(key, c.seal(key, value), expiration)
+}
+
+func (c *encryptedCache) SetCtx(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return c.Cache.SetCtx(ctx, key, c.seal(key, value), expiration)
+}
+
+func (c *encryptedCache) Revert(key string, written uint64, prev Item[interface{}]) bool {
+	prev.Value = c.seal(key, prev.Value)
+	return c.Cache.Revert(key, written, prev)
+}
+
+func (c *encryptedCache) CompareAndSwap(key string, expectedVersion uint64, newValue interface{}, ttl time.Duration) bool {
+	return c.Cache.CompareAndSwap(key, expectedVersion, c.seal(key, newValue), ttl)
+}
+
+func (c *encryptedCache) SetNX(key string, value interface{}, expiration time.Duration) bool {
+	return c.Cache.SetNX(key, c.seal(key, value), expiration)
+}
+
+func (c *encryptedCache) Transaction(ops []Op[interface{}]) error {
+	sealed := make([]Op[interface{}], len(ops))
+	for i, op := range ops {
+		if op.Kind == OpSet {
+			op.Value = c.seal(op.Key, op.Value)
+		}
+		sealed[i] = op
+	}
+	return c.Cache.Transaction(sealed)
+}
+
+func (c *encryptedCache) SetMany(entries []BulkEntry[interface{}]) ([]bool, []error) {
+	sealed := make([]BulkEntry[interface{}], len(entries))
+	for i, e := range entries {
+		e.Value = c.seal(e.Key, e.Value)
+		sealed[i] = e
+	}
+	return c.Cache.SetMany(sealed)
+}
+
+func (c *encryptedCache) Increment(key string, delta int64) (int64, error) {
+	return 0, ErrEncrypted
+}
+
+func (c *encryptedCache) Append(key string, data []byte) (int, error) {
+	return 0, ErrEncrypted
+}
+
+func (c *encryptedCache) MergePatch(key string, patch []byte, ttl time.Duration) (interface{}, bool, error) {
+	return nil, false, ErrEncrypted
+}
+
+func (c *encryptedCache) LPush(key string, values []interface{}, ttl time.Duration) (int, error) {
+	return 0, ErrEncrypted
+}
+
+func (c *encryptedCache) RPush(key string, values []interface{}, ttl time.Duration) (int, error) {
+	return 0, ErrEncrypted
+}
+
+func (c *encryptedCache) LRange(key string, start, stop int) ([]interface{}, error) {
+	return nil, ErrEncrypted
+}
+
+func (c *encryptedCache) LLen(key string) (int, error) {
+	return 0, ErrEncrypted
+}
+
+func (c *encryptedCache) HSet(key, field string, value interface{}, ttl time.Duration) (bool, error) {
+	return false, ErrEncrypted
+}
+
+func (c *encryptedCache) HGet(key, field string) (interface{}, bool, error) {
+	return nil, false, ErrEncrypted
+}
+
+func (c *encryptedCache) HDel(key, field string) (bool, error) {
+	return false, ErrEncrypted
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testKey2 = "ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100"
+)
+
+func newTestEncryptedCache(t *testing.T, hexKey string, under Cache[interface{}]) *encryptedCache {
+	t.Helper()
+	aead, err := newAEAD(hexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newEncryptedCache(under, aead)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	values := []interface{}{
+		json.RawMessage(`{"name": "secret"}`),
+		blob{ContentType: "text/plain", Data: []byte("plain secret")},
+		blob{ContentType: "application/octet-stream", Data: []byte{0, 1, 0, 2}},
+		gzipValue("not really gzip"),
+	}
+	under := NewLRUCache[interface{}](10)
+	c := newTestEncryptedCache(t, testKey1, under)
+	for i, value := range values {
+		c.Set("k", value, 0)
+
+		stored, _ := under.Peek("k")
+		sealed, ok := stored.(sealedValue)
+		if !ok {
+			t.Fatalf("value %d is stored as %T, not sealed", i, stored)
+		}
+		if bytes.Contains(sealed, []byte("secret")) {
+			t.Errorf("value %d is stored in the clear: %q", i, sealed)
+		}
+		got, ok := c.Get("k")
+		if !ok || !reflect.DeepEqual(got, value) {
+			t.Errorf("value %d: Get = %#v, %v; want %#v", i, got, ok, value)
+		}
+	}
+
+	c.Set("a", json.RawMessage(`1`), 0)
+	c.Set("b", json.RawMessage(`1`), 0)
+	a, _ := under.Peek("a")
+	b, _ := under.Peek("b")
+	if bytes.Equal(a.(sealedValue), b.(sealedValue)) {
+		t.Error("equal values sealed to the same ciphertext")
+	}
+}
+
+func TestEncryptRejects(t *testing.T) {
+	under := NewLRUCache[interface{}](10)
+	c := newTestEncryptedCache(t, testKey1, under)
+	c.Set("k", json.RawMessage(`"secret"`), 0)
+	stored, _ := under.Peek("k")
+	sealed := stored.(sealedValue)
+
+	other := newTestEncryptedCache(t, testKey2, under)
+	if v, ok := other.Get("k"); ok {
+		t.Errorf("wrong key decrypted the value to %s", v)
+	}
+
+	tampered := append(sealedValue(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	under.Set("k", tampered, 0)
+	if v, ok := c.Get("k"); ok {
+		t.Errorf("tampered ciphertext decrypted to %s", v)
+	}
+
+	// The key is bound in as additional data, so a sealed value copied
+	// under another key doesn't open.
+	under.Set("moved", sealed, 0)
+	if v, ok := c.Get("moved"); ok {
+		t.Errorf("value moved to another key decrypted to %s", v)
+	}
+
+	under.Set("short", sealedValue{1, 2, 3}, 0)
+	if _, ok := c.Get("short"); ok {
+		t.Error("truncated sealed value was accepted")
+	}
+
+	if _, err := c.Increment("n", 1); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Increment: err = %v, want ErrEncrypted", err)
+	}
+}
+
+func TestNewAEAD(t *testing.T) {
+	for _, key := range []string{strings.Repeat("ab", 16), strings.Repeat("ab", 24), testKey1} {
+		if _, err := newAEAD(key); err != nil {
+			t.Errorf("%d-digit key: %v", len(key), err)
+		}
+	}
+	for _, key := range []string{"", "zz" + testKey1[2:], strings.Repeat("ab", 20)} {
+		if _, err := newAEAD(key); err == nil {
+			t.Errorf("key %q was accepted", key)
+		}
+	}
+}
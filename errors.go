@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Machine-readable error codes sent in errorResponse.Code.
+const (
+	codeBadRequest        = "bad_request"
+	codeInvalidPayload    = "invalid_payload"
+	codeInvalidTTL        = "invalid_ttl"
+	codeInvalidIfMatch    = "invalid_if_match"
+	codeInvalidKey        = "invalid_key"
+	codeInvalidQuery      = "invalid_query"
+	codeUnauthorized      = "unauthorized"
+	codeNotFound          = "not_found"
+	codeNamespaceNotFound = "namespace_not_found"
+	codeMethodNotAllowed  = "method_not_allowed"
+	codeKeyExists         = "key_exists"
+	codeNotInteger        = "not_integer"
+	codeOverflow          = "overflow"
+	codeNotList           = "not_list"
+	codeListTooLong       = "list_too_long"
+	codeNotHash           = "not_hash"
+	codeNotJSON           = "not_json"
+	codeNotAppendable     = "not_appendable"
+	codeEncrypted         = "encrypted"
+	codeSchemaViolation   = "schema_violation"
+	codeVersionMismatch   = "version_mismatch"
+	codeValueTooLarge     = "value_too_large"
+	codeBadEncoding       = "unsupported_encoding"
+	codeCacheFull         = "cache_full"
+	codeRateLimited       = "rate_limited"
+	codeOverloaded        = "overloaded"
+	codeCanceled          = "canceled"
+	codeNotReady          = "not_ready"
+	codeTooManyNamespaces = "too_many_namespaces"
+	codeOriginFailed      = "origin_failed"
+	codeOriginUnavailable = "origin_unavailable"
+	codeWriteFailed       = "write_through_failed"
+	codeInternal          = "internal"
+)
+
+// errorResponse is the body of every error the API returns.
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+	// Details lists the individual problems, where there are several, such
+	// as the schema violations of a value.
+	Details []string `json:"details,omitempty"`
+}
+
+// writeError sends a JSON errorResponse with the given status.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+// writeErrorDetails is writeError with errorResponse.Details set.
+func writeErrorDetails(w http.ResponseWriter, status int, code, message string, details []string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message, Code: code, Details: details})
+}
+
+// writeCacheError sends the response for an error returned by a cache
+// operation that the handler has no more specific answer for.
+func writeCacheError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrCanceled):
+		writeError(w, http.StatusServiceUnavailable, codeCanceled, err.Error())
+	case errors.Is(err, ErrInvalidKey):
+		writeError(w, http.StatusBadRequest, codeInvalidKey, err.Error())
+	case errors.Is(err, ErrValueTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, err.Error())
+	case errors.Is(err, ErrNotInteger):
+		writeError(w, http.StatusConflict, codeNotInteger, err.Error())
+	case errors.Is(err, ErrOverflow):
+		writeError(w, http.StatusConflict, codeOverflow, err.Error())
+	case errors.Is(err, ErrNotList):
+		writeError(w, http.StatusConflict, codeNotList, err.Error())
+	case errors.Is(err, ErrListTooLong):
+		writeError(w, http.StatusConflict, codeListTooLong, err.Error())
+	case errors.Is(err, ErrNotHash):
+		writeError(w, http.StatusConflict, codeNotHash, err.Error())
+	case errors.Is(err, ErrNotAppendable):
+		writeError(w, http.StatusConflict, codeNotAppendable, err.Error())
+	case errors.Is(err, ErrNotJSON):
+		writeError(w, http.StatusConflict, codeNotJSON, err.Error())
+	case errors.Is(err, ErrEncrypted):
+		writeError(w, http.StatusConflict, codeEncrypted, err.Error())
+	case errors.Is(err, ErrAllPinned):
+		writeError(w, http.StatusInsufficientStorage, codeCacheFull, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+	}
+}
+
+// notFoundHandler answers requests that match no route.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, codeNotFound, "no such route")
+}
+
+// routeMethods are the methods methodNotAllowedHandler tries when working
+// out which ones a path accepts.
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete,
+}
+
+// methodNotAllowedHandler answers requests whose path matches a route of
+// router but whose method doesn't. The Allow header lists the methods that
+// would have matched, found by retrying the request against router with
+// each of routeMethods, so routes added later are covered without listing
+// them here.
+func methodNotAllowedHandler(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range routeMethods {
+			probe := *r
+			probe.Method = method
+			var match mux.RouteMatch
+			if router.Match(&probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+	}
+}
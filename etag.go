@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// valueETag returns a strong, quoted entity tag for value: an FNV-1a hash of
+// its bytes for byte slices and strings, and of its JSON encoding otherwise.
+// Equal values always get the same tag, so a client polling a key that is
+// rewritten with unchanged content keeps getting 304s.
+func valueETag(value interface{}) string {
+	h := fnv.New64a()
+	switch v := value.(type) {
+	case []byte:
+		h.Write(v)
+	case gzipValue:
+		h.Write(v)
+	case sealedValue:
+		h.Write(v)
+	case blob:
+		h.Write([]byte(v.ContentType))
+		h.Write([]byte{0})
+		h.Write(v.Data)
+	case json.RawMessage:
+		h.Write(v)
+	case string:
+		h.Write([]byte(v))
+	default:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return ""
+		}
+		h.Write(b)
+	}
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+// The header may list several tags or be "*"; as the weak comparison that
+// If-None-Match calls for, W/ prefixes are ignored.
+func etagMatches(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
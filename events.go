@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published to subscribers.
+const (
+	EventInsert = "insert"
+	EventUpdate = "update"
+	EventDelete = "delete"
+	EventEvict  = "evict"
+	EventExpire = "expire"
+	EventClear  = "clear"
+)
+
+// Event describes one change to the cache. Key is empty for EventClear.
+type Event struct {
+	Type string    `json:"type"`
+	Key  string    `json:"key,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Subscribe registers for a stream of cache changes, buffered up to buffer
+// events, and returns the channel along with a function that unsubscribes
+// and closes it. Events are published while the cache lock is held, so they
+// arrive in the order the changes happened, but publishing never blocks: if
+// the subscriber falls more than buffer events behind, further events are
+// dropped until it catches up.
+func (c *LRUCache[V]) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	c.mutex.Lock()
+	if c.subscribers == nil {
+		c.subscribers = make(map[chan Event]struct{})
+	}
+	c.subscribers[ch] = struct{}{}
+	c.unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			c.mutex.Lock()
+			delete(c.subscribers, ch)
+			c.unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish sends an event to every subscriber that has room for it. The
+// caller must hold c.mutex for writing.
+func (c *LRUCache[V]) publish(typ, key string) {
+	if len(c.subscribers) == 0 {
+		return
+	}
+	ev := Event{Type: typ, Key: key, Time: time.Now()}
+	for ch := range c.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
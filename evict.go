@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Reasons passed to LRUCache.OnEvict.
+const (
+	EvictReasonCapacity = "capacity"
+	EvictReasonExpired  = "expired"
+	EvictReasonIdle     = "idle"
+)
+
+// evictedEntry is a removal waiting to be reported to OnEvict and Spill.
+type evictedEntry[V any] struct {
+	key        string
+	value      V
+	expiration time.Time
+	reason     string
+	// evicted is when the entry was removed, for Spill.
+	evicted time.Time
+}
+
+// queueEviction records a removed entry for OnEvict and Spill. The caller
+// must hold c.mutex for writing; the callbacks run from unlock.
+func (c *LRUCache[V]) queueEviction(ent *entry[V], reason string) {
+	if c.OnEvict == nil && (c.Spill == nil || reason != EvictReasonCapacity) {
+		return
+	}
+	c.evicted = append(c.evicted, evictedEntry[V]{
+		key:        ent.key,
+		value:      ent.value,
+		expiration: ent.expiration,
+		reason:     reason,
+		evicted:    time.Now(),
+	})
+}
+
+// expireLocked removes an entry whose TTL has elapsed or that has been idle
+// too long. The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) expireLocked(ent *entry[V]) {
+	reason := EvictReasonExpired
+	if now := time.Now(); !ent.expired(now) && c.idle(ent, now) {
+		reason = EvictReasonIdle
+	}
+	c.counters.expired.Add(1)
+	c.removeEntry(ent)
+	c.queueEviction(ent, reason)
+	c.publish(EventExpire, ent.key)
+}
+
+// WouldEvict returns the keys of the next n live entries that capacity
+// eviction would remove, in the order it would remove them, without changing
+// anything. Expired entries, which would go first, and pinned entries, which
+// never go, are left out.
+func (c *LRUCache[V]) WouldEvict(n int) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if n <= 0 {
+		return nil
+	}
+	now := time.Now()
+	keys := make([]string, 0, min(n, c.size))
+	add := func(ent *entry[V]) bool {
+		if !ent.pinned && !c.expired(ent, now) {
+			keys = append(keys, ent.key)
+		}
+		return len(keys) < n
+	}
+
+	if c.policy != PolicyLFU {
+		for ent := c.tail; ent != nil; ent = ent.prev {
+			if !add(ent) {
+				break
+			}
+		}
+		return keys
+	}
+
+	// The heap only knows its minimum, so order a copy; pinned entries
+	// aren't in it. As victim does, the most recently used entry goes last.
+	order := make(lfuHeap[V], 0, len(c.lfu))
+	for _, ent := range c.lfu {
+		if ent != c.head {
+			order = append(order, ent)
+		}
+	}
+	sort.Slice(order, order.Less)
+	if c.head != nil {
+		order = append(order, c.head)
+	}
+	for _, ent := range order {
+		if !add(ent) {
+			break
+		}
+	}
+	return keys
+}
+
+// unlock releases the write lock and then delivers the evictions queued
+// while it was held. Every write-locked section must end with unlock rather
+// than c.mutex.Unlock so that no removal goes unreported.
+func (c *LRUCache[V]) unlock() {
+	c.deliver(c.release())
+}
+
+// release is the first half of unlock: it releases the write lock, writes
+// the WAL records journaled while it was held and returns the evictions
+// queued, which the caller must pass to deliver. It lets a caller holding
+// several caches' locks release them all before any callback runs.
+func (c *LRUCache[V]) release() []evictedEntry[V] {
+	evicted, journaled, wal := c.evicted, c.journaled, c.wal
+	c.evicted, c.journaled = nil, nil
+	c.mutex.Unlock()
+	if len(journaled) > 0 {
+		if err := wal.write(journaled); err != nil {
+			slog.Error("cache", "op", "wal_append", "err", err)
+		}
+	}
+	return evicted
+}
+
+// deliver reports evictions returned by release to OnEvict and Spill.
+func (c *LRUCache[V]) deliver(evicted []evictedEntry[V]) {
+	for _, e := range evicted {
+		if c.OnEvict != nil {
+			c.OnEvict(e.key, e.value, e.reason)
+		}
+		if c.Spill != nil && e.reason == EvictReasonCapacity {
+			c.Spill(e.key, e.value, e.expiration, e.evicted)
+		}
+	}
+}
+
+// defaultSizer approximates a value's size: the length of byte slices and
+// strings, the compressed length of a gzipValue, the encrypted length of a
+// sealedValue, and the JSON-encoded length of anything else.
+func defaultSizer(value interface{}) int {
+	switch v := value.(type) {
+	case []byte:
+		return len(v)
+	case gzipValue:
+		return len(v)
+	case sealedValue:
+		return len(v)
+	case blob:
+		return len(v.Data)
+	case json.RawMessage:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
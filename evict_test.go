@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// BenchmarkFillPastCapacity keeps inserting new keys into a full cache.
+// passes/op is the share of writes that had to evict, each pass taking the
+// eviction path under the write lock: every write at steady state without
+// watermarks, and about one in (high-low)*capacity with them.
+func BenchmarkFillPastCapacity(b *testing.B) {
+	const capacity = 10000
+	// Writes start past the keys the cache was filled with, so that each
+	// one inserts.
+	names := make([]string, 4*capacity)
+	for i := range names {
+		names[i] = "key" + strconv.Itoa(i)
+	}
+	tests := []struct {
+		name      string
+		high, low float64
+	}{
+		{"one-per-insert", 0, 0},
+		{"watermarks=1.0/0.9", 1, 0.9},
+		{"watermarks=1.0/0.5", 1, 0.5},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			c := NewLRUCache[int](capacity)
+			c.HighWatermark, c.LowWatermark = tt.high, tt.low
+			for i := 0; i < capacity; i++ {
+				c.Set(names[i], i, 0)
+			}
+			passes := 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				before := c.counters.evictions.Load()
+				c.Set(names[(capacity+i)%len(names)], i, 0)
+				if c.counters.evictions.Load() != before {
+					passes++
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(passes)/float64(b.N), "passes/op")
+			b.ReportMetric(float64(c.counters.evictions.Load())/float64(b.N), "evictions/op")
+		})
+	}
+}
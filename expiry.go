@@ -0,0 +1,56 @@
+package main
+
+import "container/heap"
+
+// expiryHeap is a min-heap of the entries that expire, ordered by the time
+// they are due for removal, so the sweeper can find them without scanning
+// the whole cache.
+type expiryHeap[V any] []*entry[V]
+
+func (h expiryHeap[V]) Len() int { return len(h) }
+
+func (h expiryHeap[V]) Less(i, j int) bool {
+	return h[i].staleUntil.Before(h[j].staleUntil)
+}
+
+func (h expiryHeap[V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].expiryIndex = i
+	h[j].expiryIndex = j
+}
+
+func (h *expiryHeap[V]) Push(x any) {
+	ent := x.(*entry[V])
+	ent.expiryIndex = len(*h)
+	*h = append(*h, ent)
+}
+
+func (h *expiryHeap[V]) Pop() any {
+	old := *h
+	ent := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	ent.expiryIndex = -1
+	return ent
+}
+
+// trackExpiration adds ent, if it expires, to the expiry heap and to the
+// running sum behind Ages' AvgTTLRemaining; untrackExpiration takes it out
+// of both again. The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) trackExpiration(ent *entry[V]) {
+	if !ent.expiration.IsZero() {
+		c.expirySum += ent.expiration.Unix()
+		c.expiring++
+		heap.Push(&c.expiries, ent)
+	}
+}
+
+func (c *LRUCache[V]) untrackExpiration(ent *entry[V]) {
+	if !ent.expiration.IsZero() {
+		c.expirySum -= ent.expiration.Unix()
+		c.expiring--
+	}
+	if i := ent.expiryIndex; i >= 0 && i < len(c.expiries) && c.expiries[i] == ent {
+		heap.Remove(&c.expiries, i)
+	}
+}
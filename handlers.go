@@ -0,0 +1,1931 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// expiresIn returns the time left until expiration in seconds, rounded to
+// the millisecond, or nil for the zero expiration of an entry that never
+// expires.
+func expiresIn(expiration time.Time) *float64 {
+	if expiration.IsZero() {
+		return nil
+	}
+	seconds := time.Until(expiration).Round(time.Millisecond).Seconds()
+	return &seconds
+}
+
+// writeValue writes a stored value as the response body. Blobs and raw
+// JSON, as stored by PUT, are written back byte for byte, blobs with their
+// own content type; anything else, such as a counter created by incr, is
+// encoded as JSON.
+func writeValue(w http.ResponseWriter, value interface{}) {
+	if b, ok := value.(blob); ok {
+		w.Header().Set("Content-Type", b.ContentType)
+		w.Write(b.Data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if raw, ok := value.(json.RawMessage); ok {
+		w.Write(raw)
+		return
+	}
+	json.NewEncoder(w).Encode(value)
+}
+
+type itemResponse struct {
+	Value     interface{} `json:"value"`
+	Version   uint64      `json:"version"`
+	ExpiresIn *float64    `json:"expires_in"`
+}
+
+// cacheGetHandler returns the value stored under {key}. The entry's version
+// and its remaining lifetime in seconds are sent in the X-Cache-Version and
+// X-Cache-Expires-In headers; with ?meta=true they are also included in a
+// JSON envelope around the value. With ?peek=true the lookup doesn't affect
+// LRU order. The value's ETag is sent too, and a request whose If-None-Match
+// matches it gets 304 Not Modified with no body; the lookup still counts as
+// an access.
+//
+// An expired value served during the cache's stale window carries
+// X-Cache-Stale: true, and the first such response also X-Cache-Refresh: true
+// to ask that client to PUT a fresh value.
+//
+// A miss normally gets 404, but with a ?default= parameter or an
+// X-Cache-Default header the default is returned with 200 instead: as JSON
+// if it parses as JSON and as plain text otherwise. Adding
+// ?set_if_missing=true also stores the default under {key}, with the TTL
+// from the request or defaultTTL, unless another write got there first. The
+// X-Cache-Source header says whether the body came from the cache or the
+// default.
+//
+// With an origin, a miss that isn't a peek is first fetched from the origin
+// and stored, and X-Cache-Source says "origin". Only if the origin has no
+// such key either does the miss get the default or 404; an origin that
+// fails or can't be reached gets 502, and one whose circuit breaker is open
+// gets 503 origin_unavailable at once, with a Retry-After. A key the origin
+// recently answered 404 for may be negatively cached, in which case the
+// miss skips the origin and carries X-Cache-Negative: true.
+//
+// A request with Cache-Control: no-cache (or max-age=0, or Pragma: no-cache
+// without a Cache-Control) bypasses the cache: the lookup is a forced miss,
+// so it gets 404, or the default, which is then not stored. With an origin,
+// unless it is a peek, the key is fetched afresh, skipping any negative
+// record, and the result replaces the entry, or an origin 404 removes it.
+// Such a request is never served a stale value and so never takes the
+// stale window's X-Cache-Refresh: that still goes to the first plain read
+// of the stale entry, though one no-cache request through an origin
+// refreshes the entry for everyone.
+func cacheGetHandler(cache Cache[interface{}], defaultTTL time.Duration, origin *origin) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+		query := r.URL.Query()
+		peek := query.Get("peek") == "true"
+		meta := query.Get("meta") == "true"
+		setIfMissing := query.Get("set_if_missing") == "true"
+		rawDefault, hasDefault := query.Get("default"), query.Has("default")
+		if !hasDefault {
+			rawDefault = r.Header.Get("X-Cache-Default")
+			_, hasDefault = r.Header["X-Cache-Default"]
+		}
+
+		var ttl time.Duration
+		if hasDefault && setIfMissing {
+			var err error
+			ttl, err = parseTTL(r, defaultTTL)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+				return
+			}
+		}
+
+		noCache := bypassesCache(r.Header)
+
+		slog.Debug("request", "op", "get", "key", key, "peek", peek, "no_cache", noCache)
+
+		var item Item[interface{}]
+		var ok bool
+		switch {
+		case noCache:
+			// A forced miss.
+		case peek:
+			item, ok = cache.PeekItem(key)
+		default:
+			var err error
+			item, ok, err = cache.GetItemCtx(r.Context(), key)
+			if err != nil {
+				writeCacheError(w, err)
+				return
+			}
+		}
+
+		if item.Negative {
+			w.Header().Set("X-Cache-Negative", "true")
+		}
+		source := "cache"
+		if origin != nil && !peek && (noCache || (!ok && !item.Negative)) {
+			slog.Debug("request", "op", "origin_fetch", "key", key, "no_cache", noCache)
+
+			var err error
+			if noCache {
+				item, err = origin.refresh(r.Context(), cache, key)
+			} else {
+				item, err = origin.load(r.Context(), cache, key)
+			}
+			switch {
+			case err == nil:
+				ok, source = true, "origin"
+			case errors.Is(err, errCircuitOpen):
+				if wait := origin.breaker.retryAfter(); wait > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				}
+				writeError(w, http.StatusServiceUnavailable, codeOriginUnavailable, err.Error())
+				return
+			case !errors.Is(err, errOriginNotFound):
+				slog.Warn("cache", "op", "origin_fetch", "key", key, "err", err)
+				writeError(w, http.StatusBadGateway, codeOriginFailed, "origin fetch failed: "+err.Error())
+				return
+			}
+		}
+		if !ok && hasDefault {
+			source = "default"
+			value := defaultValue(rawDefault)
+			if setIfMissing && !noCache {
+				slog.Debug("request", "op", "set_default", "key", key, "ttl", ttl)
+
+				if cache.SetNX(key, value, ttl) {
+					item, ok = cache.PeekItem(key)
+				} else if item, ok = cache.PeekItem(key); ok {
+					source = "cache"
+				}
+			}
+			if !ok {
+				item, ok = Item[interface{}]{Value: value}, true
+			}
+		}
+
+		if ok {
+			expires := expiresIn(item.Expiration)
+			if hasDefault || origin != nil {
+				w.Header().Set("X-Cache-Source", source)
+			}
+			if item.Version != 0 {
+				w.Header().Set("X-Cache-Version", strconv.FormatUint(item.Version, 10))
+			}
+			if expires != nil {
+				w.Header().Set("X-Cache-Expires-In", strconv.FormatFloat(*expires, 'f', -1, 64))
+			}
+			if item.Stale {
+				w.Header().Set("X-Cache-Stale", "true")
+			}
+			if item.Refresh {
+				w.Header().Set("X-Cache-Refresh", "true")
+			}
+			if item.ETag != "" {
+				w.Header().Set("ETag", item.ETag)
+			}
+			if etagMatches(r.Header.Get("If-None-Match"), item.ETag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			if meta {
+				json.NewEncoder(w).Encode(itemResponse{
+					Value:     item.Value,
+					Version:   item.Version,
+					ExpiresIn: expires,
+				})
+				return
+			}
+			writeValue(w, item.Value)
+		} else {
+			writeError(w, http.StatusNotFound, codeNotFound, "key not found")
+		}
+	}
+}
+
+// defaultValue is the value a GET with a default returns on a miss: raw
+// JSON if the default parses as JSON, and a plain-text blob otherwise.
+func defaultValue(raw string) interface{} {
+	if json.Valid([]byte(raw)) {
+		return json.RawMessage(raw)
+	}
+	return blob{ContentType: "text/plain; charset=utf-8", Data: []byte(raw)}
+}
+
+// cacheHeadHandler answers HEAD /cache/{key} with 200 if the key is live and
+// 404 otherwise. It never writes a body.
+func cacheHeadHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		slog.Debug("request", "op", "head", "key", key)
+
+		if cache.Contains(key) {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+type mgetResponse struct {
+	Values  map[string]interface{} `json:"values"`
+	Missing []string               `json:"missing"`
+}
+
+// cacheMultiGetHandler looks up several keys in one request. Keys come from a
+// JSON array in the POST body, or from a comma-separated "keys" query
+// parameter on GET. Found values are returned under "values" and the keys
+// that missed under "missing". The status is 200 whenever the request itself
+// is well-formed, however many of the keys hit.
+//
+// With ?format=ndjson, or Accept: application/x-ndjson, the results are
+// instead streamed as NDJSON, one {"key": ..., "value": ...} line per key in
+// the order given, looked up and flushed dumpBatchSize keys at a time, so
+// that neither side has to hold them all. A miss is a line with a null
+// value and "missing": true, or with ?omit_missing=true no line at all.
+func cacheMultiGetHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var keys []string
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+				return
+			}
+		} else if raw := r.URL.Query().Get("keys"); raw != "" {
+			keys = strings.Split(raw, ",")
+		}
+
+		slog.Debug("request", "op", "mget", "keys", len(keys))
+
+		if r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+			streamMultiGet(w, cache, keys, r.URL.Query().Get("omit_missing") == "true")
+			return
+		}
+
+		resp := mgetResponse{Values: cache.GetMany(keys), Missing: []string{}}
+		for _, key := range keys {
+			if _, ok := resp.Values[key]; !ok {
+				resp.Missing = append(resp.Missing, key)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// mgetLine is one line of a streamed multi-get.
+type mgetLine struct {
+	Key     string      `json:"key"`
+	Value   interface{} `json:"value"`
+	Missing bool        `json:"missing,omitempty"`
+}
+
+// streamMultiGet writes the NDJSON form of a multi-get; see
+// cacheMultiGetHandler.
+func streamMultiGet(w http.ResponseWriter, cache Cache[interface{}], keys []string, omitMissing bool) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), dumpBatchSize)]
+		keys = keys[len(batch):]
+		found := cache.GetMany(batch)
+		for _, key := range batch {
+			value, ok := found[key]
+			if !ok && omitMissing {
+				continue
+			}
+			if err := enc.Encode(mgetLine{Key: key, Value: value, Missing: !ok}); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	}
+}
+
+// mdeleteResponse is the body returned by cacheMultiDeleteHandler.
+type mdeleteResponse struct {
+	Deleted  int `json:"deleted"`
+	NotFound int `json:"not_found"`
+}
+
+// cacheMultiDeleteHandler deletes the keys listed in a JSON array in the
+// request body. Keys that aren't cached are counted under "not_found" and
+// don't stop the rest from being deleted. A key listed twice is deleted
+// once and then counted as not found.
+func cacheMultiDeleteHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var keys []string
+		if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+			return
+		}
+
+		slog.Debug("request", "op", "mdelete", "keys", len(keys))
+
+		deleted := cache.DeleteMany(keys)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mdeleteResponse{Deleted: deleted, NotFound: len(keys) - deleted})
+	}
+}
+
+// parseTTL reads the expiration for a request from the "ttl" query parameter
+// or, failing that, the X-Cache-TTL header. Both use time.ParseDuration syntax
+// (e.g. "30s", "5m"). When neither is present, fallback is returned.
+func parseTTL(r *http.Request, fallback time.Duration) (time.Duration, error) {
+	raw := r.URL.Query().Get("ttl")
+	if raw == "" {
+		raw = r.Header.Get("X-Cache-TTL")
+	}
+	return parseTTLValue(raw, fallback)
+}
+
+// parseTTLValue parses a single TTL string, returning fallback when it is
+// empty. A TTL of zero means the entry never expires; negative durations are
+// rejected.
+func parseTTLValue(raw string, fallback time.Duration) (time.Duration, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+
+	ttl, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q: %v", raw, err)
+	}
+	if ttl < 0 {
+		return 0, errors.New("ttl must not be negative")
+	}
+	return ttl, nil
+}
+
+// bypassesCache reports whether a request's headers ask for a response
+// that doesn't come from a cache: Cache-Control no-cache or max-age=0, or,
+// without a Cache-Control, the HTTP/1.0 Pragma: no-cache.
+func bypassesCache(h http.Header) bool {
+	values, ok := h["Cache-Control"]
+	if !ok {
+		values = h["Pragma"]
+	}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-cache", "max-age=0", `max-age="0"`:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseIfMatch returns the version carried by the If-Match header, which may
+// be quoted like an entity tag. ok is false when the header is absent.
+func parseIfMatch(r *http.Request) (version uint64, ok bool, err error) {
+	raw := r.Header.Get("If-Match")
+	if raw == "" {
+		return 0, false, nil
+	}
+	version, err = strconv.ParseUint(strings.Trim(raw, `"`), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid If-Match version %q", raw)
+	}
+	return version, true, nil
+}
+
+// errUnsupportedEncoding is returned by decodedBody for a Content-Encoding
+// other than gzip or identity.
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding: only gzip is accepted")
+
+// decodedBody returns r's body with its Content-Encoding undone: a gzip body
+// is decompressed as it is read, and one with no encoding, or identity, is
+// returned as it is. The caller must close it once the body is read and
+// treat an error from Close as a bad body, since that is where a gzip
+// stream's trailer is checked.
+func decodedBody(r *http.Request) (io.ReadCloser, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return gzipBody{Reader: zr, body: r.Body}, nil
+	}
+	return nil, errUnsupportedEncoding
+}
+
+// gzipBody is a request body decompressed by a gzip.Reader. Closing it
+// closes the reader, reporting a bad trailer, and then the body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (b gzipBody) Close() error {
+	err := b.Reader.Close()
+	if cerr := b.body.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readDecoded reads all of a body returned by decodedBody and closes it,
+// returning the first error from either.
+func readDecoded(decoded io.ReadCloser) ([]byte, error) {
+	data, err := io.ReadAll(decoded)
+	if cerr := decoded.Close(); err == nil {
+		err = cerr
+	}
+	return data, err
+}
+
+// cacheSetHandler stores the body under {key}, exactly as sent, along with
+// its Content-Type (application/octet-stream if none is given). A JSON body,
+// sent as application/json or a +json type, is checked to be well-formed and
+// stored as raw JSON, which incr and the JSON endpoints understand; anything
+// else is stored as an opaque blob. When an If-Match header
+// carries a version, the write becomes a compare-and-swap that fails with 412
+// unless the key exists with exactly that version. With ?nx=true the write
+// only happens if the key holds no live value, failing with 409 otherwise.
+// A body longer than maxValueBytes, when positive, is rejected with 413.
+// The TTL is jittered if the cache is configured to; ?jitter=false stores it
+// exactly, and is only accepted on a plain PUT, without nx or If-Match.
+// ?pin=true, likewise only on a plain PUT, pins the key against capacity
+// eviction; a write of a new key fails with 507 cache_full if the cache is
+// full of pinned entries and set to refuse more.
+// With a schema, the body must be JSON that matches it, or the write is
+// rejected with 422 schema_violation, listing the violations in details.
+// With a writeThrough, the write is also forwarded to its backing store; a
+// strict one that fails gets 502 write_through_failed, and if the write was
+// already stored, the key is put back as it was before. A body sent with
+// Content-Encoding: gzip is decompressed first, and maxValueBytes applies
+// to the decompressed value; any other encoding is rejected with 415.
+func cacheSetHandler(cache Cache[interface{}], defaultTTL time.Duration, maxValueBytes int64, schema *jsonSchema, writeThrough *writeThrough) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		ttl, err := parseTTL(r, defaultTTL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+			return
+		}
+
+		decoded, err := decodedBody(r)
+		if errors.Is(err, errUnsupportedEncoding) {
+			w.Header().Set("Accept-Encoding", "gzip")
+			writeError(w, http.StatusUnsupportedMediaType, codeBadEncoding, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+			return
+		}
+		if maxValueBytes > 0 {
+			decoded = http.MaxBytesReader(w, decoded, maxValueBytes)
+		}
+		body, err := readDecoded(decoded)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, ErrValueTooLarge.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+			return
+		}
+		var value interface{}
+		contentType := r.Header.Get("Content-Type")
+		if isJSONContentType(contentType) {
+			if !json.Valid(body) {
+				writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: not well-formed JSON")
+				return
+			}
+			value = json.RawMessage(body)
+		} else {
+			if contentType == "" {
+				contentType = defaultContentType
+			}
+			value = blob{ContentType: contentType, Data: body}
+		}
+		if schema != nil {
+			if _, isBlob := value.(blob); isBlob {
+				writeError(w, http.StatusUnprocessableEntity, codeSchemaViolation, "value must be JSON to be checked against the schema")
+				return
+			}
+			if violations := schema.Validate(body); len(violations) > 0 {
+				writeErrorDetails(w, http.StatusUnprocessableEntity, codeSchemaViolation, "value does not match the schema", violations)
+				return
+			}
+		}
+
+		expected, conditional, err := parseIfMatch(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidIfMatch, err.Error())
+			return
+		}
+
+		nx := r.URL.Query().Get("nx") == "true"
+		if nx && conditional {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "nx and If-Match cannot be combined")
+			return
+		}
+		ctx := r.Context()
+		if r.URL.Query().Get("jitter") == "false" {
+			if nx || conditional {
+				writeError(w, http.StatusBadRequest, codeBadRequest, "jitter=false cannot be combined with nx or If-Match")
+				return
+			}
+			ctx = WithExactTTL(ctx)
+		}
+		if r.URL.Query().Get("pin") == "true" {
+			if nx || conditional {
+				writeError(w, http.StatusBadRequest, codeBadRequest, "pin=true cannot be combined with nx or If-Match")
+				return
+			}
+			ctx = WithPin(ctx)
+		}
+
+		slog.Debug("request", "op", "set", "key", key, "ttl", ttl)
+
+		forwardFirst := writeThrough != nil && !writeThrough.after && !nx && !conditional
+		// A strict forward made after the write has to be able to take it
+		// back, so remember what the key held.
+		var prev Item[interface{}]
+		var existed bool
+		if writeThrough != nil && !forwardFirst && writeThrough.strict {
+			prev, existed = cache.PeekItem(key)
+		}
+		if forwardFirst {
+			if err := writeThrough.send(ctx, key, contentType, body); err != nil && writeThrough.strict {
+				writeError(w, http.StatusBadGateway, codeWriteFailed, "write-through failed: "+err.Error())
+				return
+			}
+		}
+
+		if nx {
+			if !cache.SetNX(key, value, ttl) {
+				writeError(w, http.StatusConflict, codeKeyExists, "key already exists")
+				return
+			}
+		} else if conditional {
+			if !cache.CompareAndSwap(key, expected, value, ttl) {
+				writeError(w, http.StatusPreconditionFailed, codeVersionMismatch, "version mismatch")
+				return
+			}
+		} else if err := cache.SetCtx(ctx, key, value, ttl); err != nil {
+			writeCacheError(w, err)
+			return
+		}
+
+		if writeThrough != nil && !forwardFirst {
+			// The value is already stored, so finish forwarding it even if
+			// the client goes away.
+			written, _ := cache.PeekItem(key)
+			err := writeThrough.send(context.WithoutCancel(ctx), key, contentType, body)
+			if err != nil && writeThrough.strict {
+				writeFailure(w, undoWrite(cache, key, written.Version, prev, existed), err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// cachePatchHandler applies the body, a JSON Merge Patch (RFC 7386), to the
+// JSON value under {key} and returns the result. The key must exist. A ?ttl=
+// or X-Cache-TTL resets its expiration; without one it is kept. With
+// writeThrough set, the result is forwarded after it is stored, as for a
+// conditional PUT, and a strict forward that fails puts the unpatched value
+// back.
+func cachePatchHandler(cache Cache[interface{}], maxValueBytes int64, writeThrough *writeThrough) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		ttl, err := parseTTL(r, KeepTTL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+			return
+		}
+		if maxValueBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxValueBytes)
+		}
+		patch, err := io.ReadAll(r.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, ErrValueTooLarge.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+			return
+		}
+		if !json.Valid(patch) {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: not well-formed JSON")
+			return
+		}
+
+		slog.Debug("request", "op", "patch", "key", key, "ttl", ttl)
+
+		// A strict forward has to be able to take the patch back.
+		var prev Item[interface{}]
+		var existed bool
+		if writeThrough != nil && writeThrough.strict {
+			prev, existed = cache.PeekItem(key)
+		}
+		value, ok, err := cache.MergePatch(key, patch, ttl)
+		if err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, codeNotFound, "key not found")
+			return
+		}
+		merged, _ := value.(json.RawMessage)
+
+		if writeThrough != nil {
+			written, _ := cache.PeekItem(key)
+			err := writeThrough.send(context.WithoutCancel(r.Context()), key, "application/json", merged)
+			if err != nil && writeThrough.strict {
+				writeFailure(w, undoWrite(cache, key, written.Version, prev, existed), err)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(merged)
+	}
+}
+
+type bulkSetItem struct {
+	Value json.RawMessage `json:"value"`
+	TTL   string          `json:"ttl"`
+}
+
+type bulkSetResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// cacheBulkSetHandler stores a JSON object of key -> {value, ttl} in one
+// batch. Entries whose TTL doesn't parse are rejected individually while the
+// rest are still stored; the response then uses 207 Multi-Status and reports
+// each key as "inserted", "updated" or "error". Values whose JSON encoding is
+// longer than maxValueBytes, when positive, and keys that keyRules reject are
+// rejected the same way. New keys that pinned entries leave no room for are
+// reported as errors with code cache_full, and if that leaves nothing stored
+// the response is 507 Insufficient Storage.
+func cacheBulkSetHandler(cache Cache[interface{}], defaultTTL time.Duration, maxValueBytes int64, keyRules KeyRules) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var items map[string]bulkSetItem
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+			return
+		}
+
+		slog.Debug("request", "op", "bulk_set", "keys", len(items))
+
+		keys := make([]string, 0, len(items))
+		for key := range items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		results := make(map[string]bulkSetResult, len(items))
+		entries := make([]BulkEntry[interface{}], 0, len(items))
+		for _, key := range keys {
+			if err := keyRules.Check(key); err != nil {
+				results[key] = bulkSetResult{Status: "error", Error: err.Error()}
+				continue
+			}
+			item := items[key]
+			ttl, err := parseTTLValue(item.TTL, defaultTTL)
+			if err != nil {
+				results[key] = bulkSetResult{Status: "error", Error: err.Error()}
+				continue
+			}
+			if maxValueBytes > 0 && int64(defaultSizer(item.Value)) > maxValueBytes {
+				results[key] = bulkSetResult{Status: "error", Error: ErrValueTooLarge.Error()}
+				continue
+			}
+			value := item.Value
+			if value == nil {
+				value = json.RawMessage("null")
+			}
+			entries = append(entries, BulkEntry[interface{}]{Key: key, Value: value, Expiration: ttl})
+		}
+
+		inserted, errs := cache.SetMany(entries)
+		stored, full := 0, false
+		for i, e := range entries {
+			if errs != nil && errs[i] != nil {
+				result := bulkSetResult{Status: "error", Error: errs[i].Error()}
+				if errors.Is(errs[i], ErrAllPinned) {
+					result.Code = codeCacheFull
+					full = true
+				}
+				results[e.Key] = result
+				continue
+			}
+			status := "updated"
+			if inserted[i] {
+				status = "inserted"
+			}
+			results[e.Key] = bulkSetResult{Status: status}
+			stored++
+		}
+
+		status := http.StatusOK
+		switch {
+		case full && stored == 0:
+			status = http.StatusInsufficientStorage
+		case stored < len(items):
+			status = http.StatusMultiStatus
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+type txnOp struct {
+	Op    OpKind          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+	TTL   string          `json:"ttl"`
+}
+
+type txnRequest struct {
+	Ops []txnOp `json:"ops"`
+}
+
+// cacheTxnHandler applies {"ops": [...]} as one Transaction: each op is
+// {"op": "set", "key", "value", "ttl"} or {"op": "delete", "key"}, and either
+// all of them are applied or, if any is invalid, none is and the error names
+// the first bad op. A set's value is stored as JSON and its ttl defaults to
+// defaultTTL. Unlike a PUT, a transaction isn't forwarded to a
+// write-through store.
+func cacheTxnHandler(cache Cache[interface{}], defaultTTL time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req txnRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+			return
+		}
+
+		slog.Debug("request", "op", "txn", "ops", len(req.Ops))
+
+		ops := make([]Op[interface{}], len(req.Ops))
+		for i, o := range req.Ops {
+			op := Op[interface{}]{Kind: o.Op, Key: o.Key}
+			switch o.Op {
+			case OpSet:
+				ttl, err := parseTTLValue(o.TTL, defaultTTL)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, codeInvalidTTL, fmt.Sprintf("op %d: %v", i, err))
+					return
+				}
+				op.Expiration = ttl
+				op.Value = o.Value
+				if o.Value == nil {
+					op.Value = json.RawMessage("null")
+				}
+			case OpDelete:
+			default:
+				writeError(w, http.StatusBadRequest, codeInvalidPayload, fmt.Sprintf("invalid request payload: op %d: op must be set or delete", i))
+				return
+			}
+			ops[i] = op
+		}
+
+		if err := cache.Transaction(ops); err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"applied": len(ops)})
+	}
+}
+
+// dumpBatchSize is how many entries the dump and restore handlers handle
+// between flushes and cache writes respectively.
+const dumpBatchSize = 256
+
+// streamWriteTimeout bounds each write of the responses that can outlast
+// the server's WriteTimeout: the dump, the NDJSON multi-get and the event
+// stream. They push the write deadline this far ahead as they go instead,
+// so they can run for as long as the client keeps reading, and a client
+// that stops is dropped.
+const streamWriteTimeout = 30 * time.Second
+
+// cacheDumpHandler streams every live entry as NDJSON, one snapshotEntry
+// per line with the TTL it has left, or 0 if it never expires. Only the key
+// list is taken up front; values are read one at a time as they are written
+// out, so the response isn't buffered in memory. Entries that expire or are
+// deleted while the dump runs are left out, and the dump isn't a
+// point-in-time copy of the cache.
+func cacheDumpHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request", "op", "dump")
+
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for i, key := range cache.Keys() {
+			item, ok := cache.PeekItem(key)
+			if !ok {
+				continue
+			}
+			var ttl time.Duration
+			if !item.Expiration.IsZero() {
+				if ttl = time.Until(item.Expiration); ttl <= 0 {
+					continue
+				}
+			}
+			value, contentType := persistValue(item.Value)
+			if err := enc.Encode(snapshotEntry[interface{}]{Key: key, Value: value, ContentType: contentType, TTL: ttl}); err != nil {
+				return
+			}
+			if (i+1)%dumpBatchSize == 0 {
+				if err := rc.Flush(); err != nil {
+					return
+				}
+				rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			}
+		}
+	}
+}
+
+type restoreResponse struct {
+	Restored int `json:"restored"`
+	Skipped  int `json:"skipped"`
+	// CacheFull counts the skipped entries that pinned entries left no
+	// room for.
+	CacheFull int `json:"cache_full,omitempty"`
+}
+
+// cacheRestoreHandler stores the entries of an NDJSON body in the format
+// written by cacheDumpHandler. Each TTL restarts from the time of the
+// import. Entries with a negative TTL, a value over maxValueBytes or a key
+// that keyRules reject are skipped, as are new keys the cache refuses
+// because pinned entries fill it. The body is decoded as it arrives and
+// stored in batches, so an invalid line fails the request with 400 after
+// the entries before it have already been stored.
+func cacheRestoreHandler(cache Cache[interface{}], maxValueBytes int64, keyRules KeyRules) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request", "op", "restore")
+
+		var resp restoreResponse
+		batch := make([]BulkEntry[interface{}], 0, dumpBatchSize)
+		flush := func() {
+			_, errs := cache.SetMany(batch)
+			resp.Restored += len(batch)
+			for _, err := range errs {
+				if err == nil {
+					continue
+				}
+				resp.Restored--
+				resp.Skipped++
+				if errors.Is(err, ErrAllPinned) {
+					resp.CacheFull++
+				}
+			}
+			batch = batch[:0]
+		}
+
+		dec := json.NewDecoder(r.Body)
+		for line := 1; ; line++ {
+			var e snapshotEntry[interface{}]
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				flush()
+				writeError(w, http.StatusBadRequest, codeInvalidPayload,
+					fmt.Sprintf("invalid entry %d: %v (%d entries restored)", line, err, resp.Restored))
+				return
+			}
+			value := restoreValue(e.Value, e.ContentType)
+			if e.TTL < 0 || value == nil || keyRules.Check(e.Key) != nil ||
+				(maxValueBytes > 0 && int64(defaultSizer(value)) > maxValueBytes) {
+				resp.Skipped++
+				continue
+			}
+			batch = append(batch, BulkEntry[interface{}]{Key: e.Key, Value: value, Expiration: e.TTL})
+			if len(batch) == dumpBatchSize {
+				flush()
+			}
+		}
+		flush()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+type incrRequest struct {
+	Delta *int64 `json:"delta"`
+}
+
+// cacheIncrHandler atomically adds the body's delta (default 1) to the
+// integer stored under {key} and returns the new value.
+func cacheIncrHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		var req incrRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+			return
+		}
+		delta := int64(1)
+		if req.Delta != nil {
+			delta = *req.Delta
+		}
+
+		slog.Debug("request", "op", "incr", "key", key, "delta", delta)
+
+		value, err := cache.Increment(key, delta)
+		if err != nil {
+			writeCacheError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"value": value})
+	}
+}
+
+// cacheAppendHandler appends the body, byte for byte, to the blob under
+// {key}, creating it if needed, and returns the value's new length in bytes.
+// The body may be gzip-encoded, as with PUT, and one longer than
+// maxValueBytes, when positive, is rejected with 413, as is a value that
+// would grow past it. An existing value keeps its expiration; a new one gets
+// the default TTL and is typed application/octet-stream, so PUT it first to
+// give it another type. A JSON value can't be appended to and gets 409
+// not_appendable.
+func cacheAppendHandler(maxValueBytes int64) func(cache Cache[interface{}]) http.HandlerFunc {
+	return func(cache Cache[interface{}]) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			params := mux.Vars(r)
+			key := params["key"]
+
+			decoded, err := decodedBody(r)
+			if errors.Is(err, errUnsupportedEncoding) {
+				w.Header().Set("Accept-Encoding", "gzip")
+				writeError(w, http.StatusUnsupportedMediaType, codeBadEncoding, err.Error())
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+				return
+			}
+			if maxValueBytes > 0 {
+				decoded = http.MaxBytesReader(w, decoded, maxValueBytes)
+			}
+			data, err := readDecoded(decoded)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, ErrValueTooLarge.Error())
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+				return
+			}
+
+			slog.Debug("request", "op", "append", "key", key, "bytes", len(data))
+
+			length, err := cache.Append(key, data)
+			if err != nil {
+				writeCacheError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int{"length": length})
+		}
+	}
+}
+
+type pushRequest struct {
+	Values []json.RawMessage `json:"values"`
+}
+
+type listResponse struct {
+	Values []interface{} `json:"values,omitempty"`
+	Length int           `json:"length"`
+}
+
+// cachePushHandler adds the body's values to the front (LPush) or, with
+// front unset, the back (RPush) of the list under {key} and returns its new
+// length. A ?ttl= or X-Cache-TTL resets the list's expiration; without one
+// an existing list keeps it and a new one gets the default.
+func cachePushHandler(front bool) func(cache Cache[interface{}]) http.HandlerFunc {
+	return func(cache Cache[interface{}]) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			params := mux.Vars(r)
+			key := params["key"]
+
+			ttl, err := parseTTL(r, KeepTTL)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+				return
+			}
+			var req pushRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+				return
+			}
+			if len(req.Values) == 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: values must not be empty")
+				return
+			}
+
+			slog.Debug("request", "op", "push", "key", key, "front", front, "values", len(req.Values))
+
+			values := make([]interface{}, len(req.Values))
+			for i, v := range req.Values {
+				values[i] = v
+			}
+			push := cache.RPush
+			if front {
+				push = cache.LPush
+			}
+			n, err := push(key, values, ttl)
+			if err != nil {
+				writeCacheError(w, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(listResponse{Length: n})
+		}
+	}
+}
+
+// cacheRangeHandler returns the elements of the list under {key} from
+// ?start= to ?stop=, both inclusive and defaulting to the whole list, as in
+// LRange. An absent key is an empty list.
+func cacheRangeHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		query := r.URL.Query()
+		start, stop := 0, -1
+		for name, index := range map[string]*int{"start": &start, "stop": &stop} {
+			if raw := query.Get(name); raw != "" {
+				v, err := strconv.Atoi(raw)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, codeInvalidQuery, "invalid "+name+": must be an integer")
+					return
+				}
+				*index = v
+			}
+		}
+
+		slog.Debug("request", "op", "lrange", "key", key, "start", start, "stop", stop)
+
+		values, err := cache.LRange(key, start, stop)
+		if err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		if values == nil {
+			values = []interface{}{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]interface{}{"values": values})
+	}
+}
+
+// cacheLenHandler returns the length of the list under {key}, 0 if the key
+// is absent.
+func cacheLenHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		slog.Debug("request", "op", "llen", "key", key)
+
+		n, err := cache.LLen(key)
+		if err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listResponse{Length: n})
+	}
+}
+
+// cacheFieldSetHandler sets {field} of the hash under {key} to the body,
+// which must be JSON whatever its Content-Type. It answers 201 for a new
+// field and 204 for one it replaced. A ?ttl= or X-Cache-TTL resets the
+// key's expiration; without one an existing hash keeps it and a new one
+// gets the default.
+func cacheFieldSetHandler(maxValueBytes int64) func(cache Cache[interface{}]) http.HandlerFunc {
+	return func(cache Cache[interface{}]) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			params := mux.Vars(r)
+			key, field := params["key"], params["field"]
+
+			ttl, err := parseTTL(r, KeepTTL)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+				return
+			}
+			if maxValueBytes > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, maxValueBytes)
+			}
+			body, err := io.ReadAll(r.Body)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, ErrValueTooLarge.Error())
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+				return
+			}
+			if !json.Valid(body) {
+				writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: not well-formed JSON")
+				return
+			}
+
+			slog.Debug("request", "op", "hset", "key", key, "field", field, "ttl", ttl)
+
+			created, err := cache.HSet(key, field, json.RawMessage(body), ttl)
+			if err != nil {
+				writeCacheError(w, err)
+				return
+			}
+			if created {
+				w.WriteHeader(http.StatusCreated)
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}
+	}
+}
+
+// cacheFieldGetHandler returns {field} of the hash under {key}.
+func cacheFieldGetHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key, field := params["key"], params["field"]
+
+		slog.Debug("request", "op", "hget", "key", key, "field", field)
+
+		value, ok, err := cache.HGet(key, field)
+		if err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, codeNotFound, "field not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(value)
+	}
+}
+
+// cacheFieldDeleteHandler removes {field} from the hash under {key}. Like
+// DELETE /cache/{key}, it answers 204 whether or not the field was there.
+func cacheFieldDeleteHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key, field := params["key"], params["field"]
+
+		slog.Debug("request", "op", "hdel", "key", key, "field", field)
+
+		if _, err := cache.HDel(key, field); err != nil {
+			writeCacheError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// cacheUndeleteHandler restores {key} after a soft delete, subject to the
+// conditions of LRUCache.Undelete, and answers 404 when it can't.
+func cacheUndeleteHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		slog.Debug("request", "op", "undelete", "key", key)
+
+		if !cache.Undelete(key) {
+			writeError(w, http.StatusNotFound, codeNotFound, "no deleted entry to restore")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// cachePinHandler pins {key} so that capacity eviction skips it, as
+// LRUCache.Pin does, and answers 404 if the key is absent or expired.
+func cachePinHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		slog.Debug("request", "op", "pin", "key", key)
+
+		if !cache.Pin(key) {
+			writeError(w, http.StatusNotFound, codeNotFound, "key not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// cacheUnpinHandler makes {key} evictable again, and answers 404 if the key
+// is absent, expired or not pinned.
+func cacheUnpinHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		slog.Debug("request", "op", "unpin", "key", key)
+
+		if !cache.Unpin(key) {
+			writeError(w, http.StatusNotFound, codeNotFound, "no pinned entry")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// debugResponse is the body returned by cacheDebugHandler.
+type debugResponse struct {
+	Key       string     `json:"key"`
+	Version   uint64     `json:"version"`
+	Accesses  uint64     `json:"accesses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	ExpiresIn *float64   `json:"expires_in"`
+	Size      int        `json:"size"`
+	ETag      string     `json:"etag"`
+}
+
+// cacheDebugHandler returns the metadata of {key} without its value: how
+// many reads have hit it, when it was inserted and expires (null if never),
+// and the value's size as the cache measures it. The lookup is a peek, so it
+// neither counts as an access nor changes the entry's LRU position.
+func cacheDebugHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		slog.Debug("request", "op", "debug", "key", key)
+
+		item, ok := cache.PeekItem(key)
+		if !ok {
+			writeError(w, http.StatusNotFound, codeNotFound, "key not found")
+			return
+		}
+		resp := debugResponse{
+			Key:       key,
+			Version:   item.Version,
+			Accesses:  item.Accesses,
+			CreatedAt: item.CreatedAt,
+			ExpiresIn: expiresIn(item.Expiration),
+			Size:      defaultSizer(item.Value),
+			ETag:      item.ETag,
+		}
+		if !item.Expiration.IsZero() {
+			resp.ExpiresAt = &item.Expiration
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// cacheTouchHandler extends the lifetime of {key} to the requested TTL (or
+// the default) without resending its value. Expired keys are not revived.
+func cacheTouchHandler(cache Cache[interface{}], defaultTTL time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		ttl, err := parseTTL(r, defaultTTL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+			return
+		}
+
+		slog.Debug("request", "op", "touch", "key", key, "ttl", ttl)
+
+		if !cache.Touch(key, ttl) {
+			writeError(w, http.StatusNotFound, codeNotFound, "key not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type ttlResponse struct {
+	TTL int64 `json:"ttl"`
+}
+
+// cacheTTLHandler returns the whole seconds {key} has left to live, rounded
+// to the nearest, as Redis's TTL does: -1 for a key that never expires and
+// -2 for one that is absent or expired. It peeks, so the key's recency is
+// left alone.
+func cacheTTLHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		slog.Debug("request", "op", "ttl", "key", key)
+
+		resp := ttlResponse{TTL: -2}
+		if item, ok := cache.PeekItem(key); ok {
+			resp.TTL = -1
+			if !item.Expiration.IsZero() {
+				resp.TTL = max(int64(time.Until(item.Expiration).Round(time.Second)/time.Second), 0)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// cacheSetTTLHandler sets the TTL of {key} through Touch, as the touch
+// endpoint does, but requires the TTL to be given: in the ttl parameter or
+// X-Cache-TTL header as elsewhere, or else as the body, either a duration
+// such as "90s" or a number of seconds. A TTL of zero makes the key never
+// expire. Absent and expired keys get 404.
+func cacheSetTTLHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		ttl, err := parseTTL(r, -1)
+		if err == nil && ttl < 0 {
+			var body []byte
+			body, err = io.ReadAll(io.LimitReader(r.Body, 64))
+			if err == nil {
+				ttl, err = parseTTLBody(strings.TrimSpace(string(body)))
+			}
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+			return
+		}
+
+		slog.Debug("request", "op", "set_ttl", "key", key, "ttl", ttl)
+
+		if !cache.Touch(key, ttl) {
+			writeError(w, http.StatusNotFound, codeNotFound, "key not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// parseTTLBody parses the body of PUT /cache/{key}/ttl: a number of seconds
+// or a duration.
+func parseTTLBody(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, errors.New("ttl is required, as the ttl parameter, an X-Cache-TTL header or the body")
+	}
+	if seconds, err := strconv.ParseFloat(raw, 64); err == nil {
+		if seconds < 0 || seconds >= math.MaxInt64/float64(time.Second) {
+			return 0, fmt.Errorf("invalid ttl %q: must be a non-negative number of seconds", raw)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return parseTTLValue(raw, 0)
+}
+
+// cacheDeleteHandler removes {key}, answering 204 whether or not it was
+// there. When an If-Match header carries a version, the delete only happens
+// if the key exists with exactly that version: a key written since gets 412,
+// and an absent one 404.
+func cacheDeleteHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		key := params["key"]
+
+		expected, conditional, err := parseIfMatch(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidIfMatch, err.Error())
+			return
+		}
+
+		slog.Debug("request", "op", "delete", "key", key, "conditional", conditional)
+
+		if !conditional {
+			cache.Delete(key)
+		} else if deleted, found := cache.CompareAndDelete(key, expected); !found {
+			writeError(w, http.StatusNotFound, codeNotFound, "key not found")
+			return
+		} else if !deleted {
+			writeError(w, http.StatusPreconditionFailed, codeVersionMismatch, "version mismatch")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// queryInt parses the named query parameter as a non-negative integer,
+// returning fallback when it is absent.
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", name, raw)
+	}
+	return n, nil
+}
+
+// keyPattern compiles the key filter named by the "match" (a glob, where *
+// matches any run of characters and ? any single one) or "regex" query
+// parameter. It returns nil when neither is set.
+func keyPattern(r *http.Request) (*regexp.Regexp, error) {
+	query := r.URL.Query()
+	glob, expr := query.Get("match"), query.Get("regex")
+	switch {
+	case glob != "" && expr != "":
+		return nil, errors.New("match and regex are mutually exclusive")
+	case glob != "":
+		var b strings.Builder
+		b.WriteString("^")
+		for _, ch := range glob {
+			switch ch {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(ch)))
+			}
+		}
+		b.WriteString("$")
+		return regexp.Compile(b.String())
+	case expr != "":
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+		return re, nil
+	}
+	return nil, nil
+}
+
+type randomResponse struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// cacheRandomHandler returns a live key and its value, chosen uniformly at
+// random, or 404 if the cache is empty. The key's recency is left alone, so
+// sampling doesn't change what gets evicted.
+func cacheRandomHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request", "op", "random")
+
+		key, value, ok := cache.Random()
+		if !ok {
+			writeError(w, http.StatusNotFound, codeNotFound, "cache is empty")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(randomResponse{Key: key, Value: value})
+	}
+}
+
+// cacheKeysHandler lists live keys, most recently used first. The optional
+// offset and limit query parameters page through the list; a limit of 0 (the
+// default) means no limit. A match or regex parameter keeps only the keys
+// matching it, before paging; see keyPattern. A regex matches anywhere in the
+// key unless anchored.
+func cacheKeysHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pattern, err := keyPattern(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+			return
+		}
+		offset, err := queryInt(r, "offset", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+			return
+		}
+		limit, err := queryInt(r, "limit", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+			return
+		}
+
+		slog.Debug("request", "op", "keys", "offset", offset, "limit", limit)
+
+		keys := cache.Keys()
+		if pattern != nil {
+			matched := keys[:0]
+			for _, key := range keys {
+				if pattern.MatchString(key) {
+					matched = append(matched, key)
+				}
+			}
+			keys = matched
+		}
+		if offset > len(keys) {
+			offset = len(keys)
+		}
+		keys = keys[offset:]
+		if limit > 0 && limit < len(keys) {
+			keys = keys[:limit]
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// cacheClearHandler empties the whole cache and reports how many entries were
+// removed. With a "prefix" query parameter only the keys starting with it are
+// removed; that scans the whole cache, so it costs about as much however few
+// keys match.
+func cacheClearHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var removed int
+		if query := r.URL.Query(); query.Has("prefix") {
+			prefix := query.Get("prefix")
+			if prefix == "" {
+				writeError(w, http.StatusBadRequest, codeInvalidQuery, "prefix must not be empty")
+				return
+			}
+
+			slog.Debug("request", "op", "delete_prefix", "prefix", prefix)
+
+			removed = cache.DeletePrefix(prefix)
+		} else {
+			slog.Debug("request", "op", "clear")
+
+			removed = cache.Clear()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+	}
+}
+
+// statsResponse is the body of the stats endpoints. Newest and Oldest are
+// the most and least recently used entries, absent when the cache is empty;
+// AvgTTLRemaining is in seconds, over the entries that expire.
+type statsResponse struct {
+	Size            int            `json:"size"`
+	Capacity        int            `json:"capacity"`
+	Bytes           int64          `json:"bytes"`
+	Newest          *EntryTimes    `json:"newest,omitempty"`
+	Oldest          *EntryTimes    `json:"oldest,omitempty"`
+	AvgTTLRemaining float64        `json:"avg_ttl_remaining"`
+	ContentTypes    map[string]int `json:"content_types"`
+	Pinned          int            `json:"pinned"`
+}
+
+func cacheStatsHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request", "op", "stats")
+
+		w.Header().Set("Content-Type", "application/json")
+		ages := cache.Ages()
+		json.NewEncoder(w).Encode(statsResponse{
+			Size:            cache.Len(),
+			Capacity:        cache.Capacity(),
+			Bytes:           cache.Bytes(),
+			Newest:          ages.Newest,
+			Oldest:          ages.Oldest,
+			AvgTTLRemaining: ages.AvgTTLRemaining.Seconds(),
+			ContentTypes:    cache.ContentTypes(),
+			Pinned:          cache.Pinned(),
+		})
+	}
+}
+
+type resizeRequest struct {
+	Capacity *int `json:"capacity"`
+}
+
+type resizeResponse struct {
+	Capacity int `json:"capacity"`
+	Evicted  int `json:"evicted"`
+}
+
+// cacheResizeHandler changes the cache's capacity to the body's capacity,
+// evicting entries if it shrinks, and reports how many it evicted.
+func cacheResizeHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req resizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+			return
+		}
+		if req.Capacity == nil || *req.Capacity < 1 {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: capacity must be a positive integer")
+			return
+		}
+
+		slog.Debug("request", "op", "resize", "capacity", *req.Capacity)
+
+		evicted := cache.Resize(*req.Capacity)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resizeResponse{Capacity: cache.Capacity(), Evicted: evicted})
+	}
+}
+
+type statsResetResponse struct {
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// cacheStatsResetHandler zeroes the operation counters of the cache and of
+// every namespace, so that the metrics start afresh, for instance after a
+// configuration change. Prometheus takes the drop as a counter reset, as it
+// would a restart.
+func cacheStatsResetHandler(cache Cache[interface{}], namespaces *namespaceRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request", "op", "reset_stats")
+
+		cache.ResetStats()
+		for _, name := range namespaces.names() {
+			if ns, ok := namespaces.get(name); ok {
+				ns.ResetStats()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statsResetResponse{ResetAt: time.Now().UTC()})
+	}
+}
+
+type evictionsResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// cacheEvictionsHandler lists the next ?n= (default 10) live keys capacity
+// eviction would remove, in order, without evicting anything. To preview a
+// resize, pass the current size minus the new capacity.
+func cacheEvictionsHandler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := 10
+		if raw := r.URL.Query().Get("n"); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v < 0 {
+				writeError(w, http.StatusBadRequest, codeInvalidQuery, "invalid n: must be a non-negative integer")
+				return
+			}
+			n = v
+		}
+
+		slog.Debug("request", "op", "would_evict", "n", n)
+
+		keys := cache.WouldEvict(n)
+		if keys == nil {
+			keys = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(evictionsResponse{Keys: keys})
+	}
+}
+
+// promMetric describes one metric family served by prometheusHandler.
+type promMetric struct {
+	name, kind, help string
+	value            func(m Metrics, cache Cache[interface{}]) float64
+}
+
+var promMetrics = []promMetric{
+	{"lru_cache_hits_total", "counter", "Number of lookups that found a live entry.",
+		func(m Metrics, _ Cache[interface{}]) float64 { return float64(m.Hits) }},
+	{"lru_cache_misses_total", "counter", "Number of lookups that found no live entry.",
+		func(m Metrics, _ Cache[interface{}]) float64 { return float64(m.Misses) }},
+	{"lru_cache_expired_total", "counter", "Number of entries removed because their TTL elapsed.",
+		func(m Metrics, _ Cache[interface{}]) float64 { return float64(m.Expired) }},
+	{"lru_cache_evictions_total", "counter", "Number of entries evicted to stay within capacity.",
+		func(m Metrics, _ Cache[interface{}]) float64 { return float64(m.Evictions) }},
+	{"lru_cache_inserts_total", "counter", "Number of new keys stored.",
+		func(m Metrics, _ Cache[interface{}]) float64 { return float64(m.Inserts) }},
+	{"lru_cache_updates_total", "counter", "Number of writes to existing keys.",
+		func(m Metrics, _ Cache[interface{}]) float64 { return float64(m.Updates) }},
+	{"lru_cache_deletes_total", "counter", "Number of keys removed by explicit deletes.",
+		func(m Metrics, _ Cache[interface{}]) float64 { return float64(m.Deletes) }},
+	{"lru_cache_size", "gauge", "Number of entries currently held.",
+		func(_ Metrics, c Cache[interface{}]) float64 { return float64(c.Len()) }},
+	{"lru_cache_bytes", "gauge", "Approximate total size of stored values, when a byte budget is set.",
+		func(_ Metrics, c Cache[interface{}]) float64 { return float64(c.Bytes()) }},
+	{"lru_cache_capacity", "gauge", "Maximum number of entries held before evicting.",
+		func(_ Metrics, c Cache[interface{}]) float64 { return float64(c.Capacity()) }},
+	{"lru_cache_pinned", "gauge", "Number of entries pinned against capacity eviction.",
+		func(_ Metrics, c Cache[interface{}]) float64 { return float64(c.Pinned()) }},
+}
+
+// prometheusHandler serves the cache counters in the Prometheus text format.
+// The default cache's samples are unlabelled; each namespace adds a sample
+// labelled with its name to every family. With a breaker, the origin's
+// circuit breaker is reported too, and with a limiter, the requests in
+// flight. The eviction rate is that of the default cache and the namespaces
+// together.
+func prometheusHandler(cache Cache[interface{}], namespaces *namespaceRegistry, breaker *circuitBreaker, limiter *concurrencyLimiter, pressure *evictionPressure) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type source struct {
+			labels  string
+			cache   Cache[interface{}]
+			metrics Metrics
+		}
+		sources := []source{{cache: cache, metrics: cache.Metrics()}}
+		for _, name := range namespaces.names() {
+			if ns, ok := namespaces.get(name); ok {
+				sources = append(sources, source{fmt.Sprintf("{namespace=%q}", name), ns, ns.Metrics()})
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range promMetrics {
+			fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+			fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+			for _, src := range sources {
+				value := m.value(src.metrics, src.cache)
+				fmt.Fprintf(w, "%s%s %s\n", m.name, src.labels, strconv.FormatFloat(value, 'g', -1, 64))
+			}
+		}
+		if breaker != nil {
+			bm, state := breaker.metrics()
+			fmt.Fprintf(w, "# HELP lru_cache_origin_breaker_state State of the origin circuit breaker: 0 closed, 1 half-open, 2 open.\n")
+			fmt.Fprintf(w, "# TYPE lru_cache_origin_breaker_state gauge\n")
+			fmt.Fprintf(w, "lru_cache_origin_breaker_state %d\n", state)
+			fmt.Fprintf(w, "# HELP lru_cache_origin_breaker_failures Consecutive origin failures counted by the circuit breaker.\n")
+			fmt.Fprintf(w, "# TYPE lru_cache_origin_breaker_failures gauge\n")
+			fmt.Fprintf(w, "lru_cache_origin_breaker_failures %d\n", bm.ConsecutiveFailures)
+			fmt.Fprintf(w, "# HELP lru_cache_origin_breaker_trips_total Number of times the origin circuit breaker opened.\n")
+			fmt.Fprintf(w, "# TYPE lru_cache_origin_breaker_trips_total counter\n")
+			fmt.Fprintf(w, "lru_cache_origin_breaker_trips_total %d\n", bm.Trips)
+		}
+		fmt.Fprintf(w, "# HELP lru_cache_eviction_rate Evictions per second over the eviction pressure window.\n")
+		fmt.Fprintf(w, "# TYPE lru_cache_eviction_rate gauge\n")
+		fmt.Fprintf(w, "lru_cache_eviction_rate %s\n", strconv.FormatFloat(pressure.rate(), 'g', -1, 64))
+		if limiter != nil {
+			cm := limiter.metrics()
+			fmt.Fprintf(w, "# HELP lru_cache_http_requests_in_flight Number of requests being served.\n")
+			fmt.Fprintf(w, "# TYPE lru_cache_http_requests_in_flight gauge\n")
+			fmt.Fprintf(w, "lru_cache_http_requests_in_flight %d\n", cm.InFlight)
+			fmt.Fprintf(w, "# HELP lru_cache_http_requests_rejected_total Number of requests rejected for exceeding -max-concurrent.\n")
+			fmt.Fprintf(w, "# TYPE lru_cache_http_requests_rejected_total counter\n")
+			fmt.Fprintf(w, "lru_cache_http_requests_rejected_total %d\n", cm.Rejected)
+		}
+	}
+}
+
+// metricsResponse is the default cache's Metrics, with those of each
+// namespace, the eviction pressure, the state of the origin's circuit
+// breaker and the requests in flight alongside.
+type metricsResponse struct {
+	Metrics
+	Namespaces       map[string]Metrics  `json:"namespaces,omitempty"`
+	EvictionPressure pressureMetrics     `json:"eviction_pressure"`
+	OriginBreaker    *breakerMetrics     `json:"origin_breaker,omitempty"`
+	Concurrency      *concurrencyMetrics `json:"concurrency,omitempty"`
+}
+
+// metricsHandler serves the cache counters as JSON.
+func metricsHandler(cache Cache[interface{}], namespaces *namespaceRegistry, breaker *circuitBreaker, limiter *concurrencyLimiter, pressure *evictionPressure) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := metricsResponse{Metrics: cache.Metrics(), EvictionPressure: pressure.metrics()}
+		if breaker != nil {
+			bm, _ := breaker.metrics()
+			resp.OriginBreaker = &bm
+		}
+		if limiter != nil {
+			cm := limiter.metrics()
+			resp.Concurrency = &cm
+		}
+		for _, name := range namespaces.names() {
+			if ns, ok := namespaces.get(name); ok {
+				if resp.Namespaces == nil {
+					resp.Namespaces = make(map[string]Metrics)
+				}
+				resp.Namespaces[name] = ns.Metrics()
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// namespacesHandler lists the namespaces that exist.
+func namespacesHandler(namespaces *namespaceRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request", "op", "namespaces")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(namespaces.names())
+	}
+}
+
+// eventsKeepAlive is how often an idle event stream sends a comment line, so
+// that proxies don't time out the connection.
+const eventsKeepAlive = 15 * time.Second
+
+// eventsBuffer is how many events a slow event-stream client may fall behind
+// before further events are dropped for it.
+const eventsBuffer = 256
+
+// cacheEventsHandler streams cache changes as server-sent events, one JSON
+// Event per "data:" line, until the client disconnects or shutdown is
+// closed; long-lived streams would otherwise hold up a graceful shutdown.
+func cacheEventsHandler(cache Cache[interface{}], shutdown <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("request", "op", "events")
+
+		rc := http.NewResponseController(w)
+		events, cancel := cache.Subscribe(eventsBuffer)
+		defer cancel()
+
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-shutdown:
+				return
+			case ev, ok := <-events:
+				rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(ev)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			case <-keepAlive.C:
+				rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				io.WriteString(w, ": keep-alive\n\n")
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// healthzHandler is the liveness probe: it answers 200 whenever the server is
+// able to serve requests at all.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok\n")
+}
+
+// readyzHandler is the readiness probe: 200 once ready is set, 503 before.
+// It reads a flag and never touches the cache lock.
+func readyzHandler(ready *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			writeError(w, http.StatusServiceUnavailable, codeNotReady, "not ready")
+			return
+		}
+		io.WriteString(w, "ok\n")
+	}
+}
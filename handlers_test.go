@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestGzipBodyTrailer checks that a gzip body whose trailer is corrupt or
+// missing is rejected rather than stored.
+func TestGzipBodyTrailer(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("hello, world"))
+	zw.Close()
+	good := buf.Bytes()
+
+	badCRC := append([]byte(nil), good...)
+	badCRC[len(badCRC)-8] ^= 0xff
+	badLength := append([]byte(nil), good...)
+	badLength[len(badLength)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		body []byte
+		ok   bool
+	}{
+		{"valid", good, true},
+		{"bad checksum", badCRC, false},
+		{"bad length", badLength, false},
+		{"no trailer", good[:len(good)-8], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewLRUCache[interface{}](10)
+			handler := cacheSetHandler(cache, 0, 0, nil, nil)
+			r := httptest.NewRequest(http.MethodPut, "/cache/k", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			handler(rec, mux.SetURLVars(r, map[string]string{"key": "k"}))
+			if tt.ok && rec.Code >= 300 {
+				t.Fatalf("status %d, want success: %s", rec.Code, rec.Body)
+			}
+			if !tt.ok && rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want 400: %s", rec.Code, rec.Body)
+			}
+			if stored := cache.Contains("k"); stored != tt.ok {
+				t.Errorf("stored = %v, want %v", stored, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrNotHash is returned by the hash operations when the key holds a value
+// that isn't a JSON object, or when the cache's value type can't hold one.
+// The value is left as it is rather than overwritten.
+var ErrNotHash = errors.New("value is not a hash")
+
+// HSet sets field of the hash stored under key to value and reports whether
+// the field is new. An absent or expired key is created holding just that
+// field. Like a list, a hash is stored as a raw JSON object, so GET returns
+// the whole of it and an object PUT under a key can be updated field by
+// field. The ttl applies to the whole key, as for LPush: zero or more resets
+// its expiration, KeepTTL keeps it. A value over MaxValueBytes fails with
+// ErrValueTooLarge; like the other writes, only the value is measured, not
+// the hash it joins.
+func (c *LRUCache[V]) HSet(key, field string, value V, ttl time.Duration) (bool, error) {
+	if err := c.checkWrite(key, value); err != nil {
+		return false, err
+	}
+	elem, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if ok && c.expired(ent, time.Now()) {
+		c.expireLocked(ent)
+		ok = false
+	}
+	if err := c.checkRoom(key); err != nil {
+		return false, err
+	}
+	hash := map[string]json.RawMessage{}
+	if ok {
+		if hash, err = toHash(ent.value); err != nil {
+			return false, err
+		}
+	}
+	_, exists := hash[field]
+	hash[field] = elem
+
+	stored, err := fromHash[V](hash)
+	if err != nil {
+		return false, err
+	}
+
+	slog.Debug("cache", "op", "hset", "key", key, "field", field, "created", !exists)
+	switch {
+	case !ok && ttl < 0:
+		c.setLocked(key, stored, c.jitter(c.DefaultTTL))
+	case ttl >= 0:
+		c.setLocked(key, stored, c.jitter(ttl))
+	default:
+		c.replaceLocked(ent, stored)
+	}
+	return !exists, nil
+}
+
+// HGet returns field of the hash stored under key. It reports false if the
+// key or the field is absent. Like Get, it marks the key as most recently
+// used.
+func (c *LRUCache[V]) HGet(key, field string) (V, bool, error) {
+	var zero V
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent := c.getLocked(key)
+	if ent == nil {
+		return zero, false, nil
+	}
+	hash, err := toHash(ent.value)
+	if err != nil {
+		return zero, false, err
+	}
+	elem, ok := hash[field]
+	if !ok {
+		return zero, false, nil
+	}
+	v, isV := any(elem).(V)
+	if !isV {
+		return zero, false, ErrNotHash
+	}
+	return v, true, nil
+}
+
+// HDel removes field from the hash stored under key, keeping the key's
+// expiration, and reports whether the field was there. Removing the last
+// field deletes the key, as Delete would.
+func (c *LRUCache[V]) HDel(key, field string) (bool, error) {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if !ok {
+		return false, nil
+	}
+	if c.expired(ent, time.Now()) {
+		c.expireLocked(ent)
+		return false, nil
+	}
+	hash, err := toHash(ent.value)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := hash[field]; !ok {
+		return false, nil
+	}
+	delete(hash, field)
+
+	slog.Debug("cache", "op", "hdel", "key", key, "field", field, "remaining", len(hash))
+	if len(hash) == 0 {
+		c.deleteLocked(ent)
+		return true, nil
+	}
+	stored, err := fromHash[V](hash)
+	if err != nil {
+		return false, err
+	}
+	c.replaceLocked(ent, stored)
+	return true, nil
+}
+
+// toHash decodes a stored value holding a JSON object into its fields.
+func toHash(value interface{}) (map[string]json.RawMessage, error) {
+	raw, ok, err := storedJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	var hash map[string]json.RawMessage
+	if !ok || json.Unmarshal(raw, &hash) != nil || hash == nil {
+		return nil, ErrNotHash
+	}
+	return hash, nil
+}
+
+// fromHash encodes hash as the value to store, failing with ErrNotHash if
+// V can't hold it.
+func fromHash[V any](hash map[string]json.RawMessage) (V, error) {
+	var zero V
+	raw, err := json.Marshal(hash)
+	if err != nil {
+		return zero, err
+	}
+	v, ok := any(json.RawMessage(raw)).(V)
+	if !ok {
+		return zero, ErrNotHash
+	}
+	return v, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrInvalidKey is returned when a key breaks the cache's KeyRules.
+var ErrInvalidKey = errors.New("invalid key")
+
+// KeyRules constrain the keys a cache accepts. A key must always be
+// non-empty, valid UTF-8 and free of control characters. On top of that, a
+// positive MaxLength caps its length in bytes and a non-nil Pattern must
+// match the whole key. The zero value applies only the fixed checks.
+type KeyRules struct {
+	MaxLength int
+	Pattern   *regexp.Regexp
+}
+
+// Check returns an error wrapping ErrInvalidKey that says why key is
+// rejected, or nil if it is allowed.
+func (kr KeyRules) Check(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("%w: empty", ErrInvalidKey)
+	case kr.MaxLength > 0 && len(key) > kr.MaxLength:
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidKey, kr.MaxLength)
+	case !utf8.ValidString(key):
+		return fmt.Errorf("%w: not valid UTF-8", ErrInvalidKey)
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: contains control character %U", ErrInvalidKey, r)
+		}
+	}
+	if kr.Pattern != nil && !kr.Pattern.MatchString(key) {
+		return fmt.Errorf("%w: does not match %s", ErrInvalidKey, kr.Pattern)
+	}
+	return nil
+}
+
+// compileKeyPattern compiles pattern so that it must match a whole key
+// rather than any part of one. An empty pattern returns nil, allowing any
+// key.
+func compileKeyPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// requireValidKey rejects requests whose {key} route variable breaks rules
+// with 400, before the handler sees them. Routes without a key pass through.
+func requireValidKey(rules KeyRules) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := mux.Vars(r)["key"]; ok {
+				if err := rules.Check(key); err != nil {
+					writeError(w, http.StatusBadRequest, codeInvalidKey, err.Error())
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
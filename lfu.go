@@ -0,0 +1,147 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Policy selects how a full cache chooses the entry to evict.
+type Policy int
+
+const (
+	// PolicyLRU evicts the least recently used entry.
+	PolicyLRU Policy = iota
+	// PolicyLFU evicts the least frequently used entry, breaking ties in
+	// favour of keeping the more recently used one.
+	PolicyLFU
+)
+
+// ParsePolicy maps "lru" or "lfu" to a Policy.
+func ParsePolicy(name string) (Policy, bool) {
+	switch name {
+	case "lru":
+		return PolicyLRU, true
+	case "lfu":
+		return PolicyLFU, true
+	}
+	return 0, false
+}
+
+// NewLFUCache returns a cache that evicts by access frequency rather than
+// recency. It has the same API as an LRU cache; only the choice of victim
+// differs. Reads and writes of existing keys count as uses. Every hit takes
+// the write lock, since it has to update the entry's position in the
+// frequency heap.
+func NewLFUCache[V any](capacity int) *LRUCache[V] {
+	return newCache[V](capacity, PolicyLFU)
+}
+
+// lfuHeap is a min-heap of entries ordered by use count and then by the time
+// of last use.
+type lfuHeap[V any] []*entry[V]
+
+func (h lfuHeap[V]) Len() int { return len(h) }
+
+func (h lfuHeap[V]) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].lastUse < h[j].lastUse
+}
+
+func (h lfuHeap[V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *lfuHeap[V]) Push(x any) {
+	ent := x.(*entry[V])
+	ent.heapIndex = len(*h)
+	*h = append(*h, ent)
+}
+
+func (h *lfuHeap[V]) Pop() any {
+	old := *h
+	ent := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	ent.heapIndex = -1
+	return ent
+}
+
+// promote records a use of ent: it moves to the front of the recency list
+// and, under LFU, its use count goes up. A pinned entry isn't in the heap,
+// but its count still goes up so that it is placed right once unpinned.
+func (c *LRUCache[V]) promote(ent *entry[V]) {
+	ent.accessed.Store(time.Now().UnixNano())
+	c.moveToFront(ent)
+	if c.policy == PolicyLFU {
+		c.useClock++
+		ent.freq++
+		ent.lastUse = c.useClock
+		if c.inLFU(ent) {
+			heap.Fix(&c.lfu, ent.heapIndex)
+		}
+	}
+}
+
+// lfuAdd starts tracking a newly inserted entry under LFU.
+func (c *LRUCache[V]) lfuAdd(ent *entry[V]) {
+	if c.policy == PolicyLFU {
+		c.useClock++
+		ent.freq = 1
+		ent.lastUse = c.useClock
+		heap.Push(&c.lfu, ent)
+	}
+}
+
+// lfuRemove stops tracking an entry that is leaving the cache.
+func (c *LRUCache[V]) lfuRemove(ent *entry[V]) {
+	if c.inLFU(ent) {
+		heap.Remove(&c.lfu, ent.heapIndex)
+	}
+}
+
+// inLFU reports whether ent is in the LFU heap.
+func (c *LRUCache[V]) inLFU(ent *entry[V]) bool {
+	return c.policy == PolicyLFU && ent.heapIndex >= 0 && ent.heapIndex < len(c.lfu) && c.lfu[ent.heapIndex] == ent
+}
+
+// victim returns the entry eviction should remove next, or nil if there is
+// none. Pinned entries are never chosen. Under LFU, the most recently used
+// entry is skipped in favour of the next candidate so that a fresh insert
+// isn't evicted by its own write. Once everything else is pinned, the most
+// recently used entry isn't chosen under either policy: the cache then
+// holds more than its capacity rather than evicting each write it makes.
+func (c *LRUCache[V]) victim() *entry[V] {
+	if c.policy != PolicyLFU {
+		ent := c.tail
+		for ent != nil && ent.pinned {
+			ent = ent.prev
+		}
+		if ent == c.head && c.pinned > 0 {
+			return nil
+		}
+		return ent
+	}
+	if len(c.lfu) == 0 {
+		return nil
+	}
+	if c.lfu[0] != c.head {
+		return c.lfu[0]
+	}
+	if len(c.lfu) == 1 {
+		if c.pinned > 0 {
+			return nil
+		}
+		return c.lfu[0]
+	}
+	// The root is the entry just written; the next smallest is one of its
+	// children.
+	next := 1
+	if len(c.lfu) > 2 && c.lfu.Less(2, 1) {
+		next = 2
+	}
+	return c.lfu[next]
+}
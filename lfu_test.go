@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+// TestPoliciesEvictDifferentKeys runs one access sequence against both
+// policies: a is read most often but least recently, so LRU evicts it while
+// LFU keeps it and evicts b, the less recent of the two keys used least.
+func TestPoliciesEvictDifferentKeys(t *testing.T) {
+	tests := []struct {
+		policy  Policy
+		evicted string
+	}{
+		{PolicyLRU, "a"},
+		{PolicyLFU, "b"},
+	}
+	for _, tt := range tests {
+		c := newCache[int](3, tt.policy)
+		c.Set("a", 1, 0)
+		c.Set("b", 2, 0)
+		c.Set("c", 3, 0)
+		c.Get("a")
+		c.Get("a")
+		c.Get("b")
+		c.Get("c")
+		c.Set("d", 4, 0)
+
+		for _, key := range []string{"a", "b", "c", "d"} {
+			want := key != tt.evicted
+			if got := c.Contains(key); got != want {
+				t.Errorf("policy %d: Contains(%q) = %v, want %v", tt.policy, key, got, want)
+			}
+		}
+		if got := c.Metrics().Evictions; got != 1 {
+			t.Errorf("policy %d: %d evictions, want 1", tt.policy, got)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrNotList is returned by the list operations when the key holds a value
+// that isn't a JSON array, or when the cache's value type can't hold one.
+// The value is left as it is rather than overwritten.
+var ErrNotList = errors.New("value is not a list")
+
+// ErrListTooLong is returned by LPush and RPush when the list would grow
+// past MaxListLength. Nothing is pushed.
+var ErrListTooLong = errors.New("list would exceed the maximum length")
+
+// KeepTTL, passed as the TTL to LPush or RPush, leaves an existing list's
+// expiration as it is. A list the push creates gets DefaultTTL.
+const KeepTTL time.Duration = -1
+
+// LPush adds values to the front of the list stored under key, so that the
+// last of them ends up first, and returns the list's new length. An absent
+// or expired key is created holding just values. A list is stored as the
+// raw JSON array of its elements, so GET returns it as is and a JSON array
+// PUT under a key can be pushed to. A ttl of zero or more resets the
+// expiration as Set does; KeepTTL keeps it.
+func (c *LRUCache[V]) LPush(key string, values []V, ttl time.Duration) (int, error) {
+	return c.push(key, values, ttl, true)
+}
+
+// RPush is LPush that adds values to the back of the list, in order.
+func (c *LRUCache[V]) RPush(key string, values []V, ttl time.Duration) (int, error) {
+	return c.push(key, values, ttl, false)
+}
+
+func (c *LRUCache[V]) push(key string, values []V, ttl time.Duration, front bool) (int, error) {
+	if err := c.KeyRules.Check(key); err != nil {
+		return 0, err
+	}
+	elems := make([]json.RawMessage, len(values))
+	for i, v := range values {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		elems[i] = raw
+	}
+
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if ok && c.expired(ent, time.Now()) {
+		c.expireLocked(ent)
+		ok = false
+	}
+	if err := c.checkRoom(key); err != nil {
+		return 0, err
+	}
+	var list []json.RawMessage
+	if ok {
+		var err error
+		if list, err = toList(ent.value); err != nil {
+			return 0, err
+		}
+	}
+	if c.MaxListLength > 0 && len(list)+len(elems) > c.MaxListLength {
+		return 0, ErrListTooLong
+	}
+
+	if front {
+		pushed := make([]json.RawMessage, 0, len(list)+len(elems))
+		for i := len(elems) - 1; i >= 0; i-- {
+			pushed = append(pushed, elems[i])
+		}
+		list = append(pushed, list...)
+	} else {
+		list = append(list, elems...)
+	}
+	raw, err := json.Marshal(list)
+	if err != nil {
+		return 0, err
+	}
+	value, isV := any(json.RawMessage(raw)).(V)
+	if !isV {
+		return 0, ErrNotList
+	}
+
+	slog.Debug("cache", "op", "push", "key", key, "front", front, "length", len(list))
+	switch {
+	case !ok && ttl < 0:
+		c.setLocked(key, value, c.jitter(c.DefaultTTL))
+	case ttl >= 0:
+		c.setLocked(key, value, c.jitter(ttl))
+	default:
+		c.replaceLocked(ent, value)
+	}
+	return len(list), nil
+}
+
+// LRange returns the elements of the list under key from index start to
+// stop, both inclusive. Negative indices count from the end, so 0 and -1
+// cover the whole list; indices past either end are clamped. An absent key
+// is an empty list. Like Get, it marks the key as most recently used.
+func (c *LRUCache[V]) LRange(key string, start, stop int) ([]V, error) {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent := c.getLocked(key)
+	if ent == nil {
+		return nil, nil
+	}
+	list, err := toList(ent.value)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start = max(len(list)+start, 0)
+	}
+	if stop < 0 {
+		stop = len(list) + stop
+	}
+	stop = min(stop, len(list)-1)
+	if start > stop {
+		return nil, nil
+	}
+
+	values := make([]V, 0, stop-start+1)
+	for _, elem := range list[start : stop+1] {
+		v, ok := any(elem).(V)
+		if !ok {
+			return nil, ErrNotList
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// LLen returns the length of the list under key, or 0 for an absent key.
+// Like Get, it marks the key as most recently used.
+func (c *LRUCache[V]) LLen(key string) (int, error) {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent := c.getLocked(key)
+	if ent == nil {
+		return 0, nil
+	}
+	list, err := toList(ent.value)
+	return len(list), err
+}
+
+// toList decodes a stored value holding a JSON array into its elements.
+func toList(value interface{}) ([]json.RawMessage, error) {
+	raw, ok, err := storedJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	var list []json.RawMessage
+	if !ok || json.Unmarshal(raw, &list) != nil || list == nil {
+		return nil, ErrNotList
+	}
+	return list, nil
+}
+
+// storedJSON returns the JSON a stored value holds, reporting false for a
+// value that isn't JSON, such as a blob.
+func storedJSON(value interface{}) ([]byte, bool, error) {
+	switch v := value.(type) {
+	case json.RawMessage:
+		return v, true, nil
+	case gzipValue:
+		raw, err := v.MarshalJSON()
+		return raw, err == nil, err
+	}
+	return nil, false, nil
+}
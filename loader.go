@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLoaderPanicked is returned to GetOrLoad callers that were waiting on a
+// loader that panicked. The caller that ran it sees the panic itself.
+var ErrLoaderPanicked = errors.New("cache loader panicked")
+
+// inflightCall is a computation in progress for a single key. Callers that
+// arrive while it runs wait on done and then share value and err.
+type inflightCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// GetOrSet returns the live value stored under key or, on a miss, calls
+// compute and stores its result with the returned TTL.
+//
+// compute runs without the cache lock held, so a slow computation doesn't
+// stall unrelated keys. To still compute each missing key only once,
+// concurrent GetOrSet calls for the same key wait for the first caller's
+// computation and return its value instead of running their own. The cost of
+// not holding the lock is that a plain Set for the key that lands while
+// compute runs is overwritten by the computed value. If compute panics, the
+// waiters receive the zero value and the key is left unset. Every caller
+// gets the zero value, and nothing is stored, when the computed value is
+// over MaxValueBytes or, under PinnedReject, when pinned entries leave no
+// room for the key.
+func (c *LRUCache[V]) GetOrSet(key string, compute func() (V, time.Duration)) V {
+	value, _ := c.GetOrLoad(key, func() (V, time.Duration, error) {
+		value, ttl := compute()
+		return value, ttl, nil
+	})
+	return value
+}
+
+// GetOrLoad is GetOrSet for a loader that can fail, such as a fetch from an
+// origin. Concurrent misses for the same key share a single loader call, as
+// with GetOrSet. When the loader returns an error, nothing is stored and
+// every caller waiting on it gets the same error; the next GetOrLoad for the
+// key calls the loader again. A loaded value over MaxValueBytes is treated
+// the same way, failing with ErrValueTooLarge. A key that KeyRules reject
+// fails without calling the loader, and under PinnedReject a loaded value that pinned
+// entries leave no room for is dropped and the callers get ErrAllPinned.
+//
+// The cache lock is only held to look the key up, to record the call in
+// c.inflight and to store the result, never while the loader runs. The
+// in-flight record is in effect a lock on that one key: a slow loader holds
+// up GetOrLoad callers for the same key and nothing else, not even keys that
+// a striped lock would have put alongside it.
+func (c *LRUCache[V]) GetOrLoad(key string, loader func() (V, time.Duration, error)) (V, error) {
+	if err := c.KeyRules.Check(key); err != nil {
+		var zero V
+		return zero, err
+	}
+	c.mutex.Lock()
+	if ent := c.getLocked(key); ent != nil {
+		value := ent.value
+		c.unlock()
+		return value, nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &inflightCall[V]{done: make(chan struct{}), err: ErrLoaderPanicked}
+	if c.inflight == nil {
+		c.inflight = make(map[string]*inflightCall[V])
+	}
+	c.inflight[key] = call
+	c.unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.inflight, key)
+		c.unlock()
+		close(call.done)
+	}()
+
+	value, ttl, err := loader()
+	if err == nil {
+		err = c.checkValueSize(value)
+	}
+	if err != nil {
+		var zero V
+		call.err = err
+		return zero, err
+	}
+
+	c.mutex.Lock()
+	if err := c.checkRoom(key); err != nil {
+		c.unlock()
+		var zero V
+		call.err = err
+		return zero, err
+	}
+	c.setLocked(key, value, c.jitter(ttl))
+	c.unlock()
+
+	call.value, call.err = value, nil
+	return value, nil
+}
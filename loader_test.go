@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSlowLoaderBlocksOnlyItsKey holds a loader for key a until the end and
+// checks that operations on key b, including a load of its own, finish
+// within a deadline meanwhile, while a second load of a waits for and
+// shares the first one's result.
+func TestSlowLoaderBlocksOnlyItsKey(t *testing.T) {
+	const deadline = 2 * time.Second
+	c := NewLRUCache[string](10)
+	c.Set("b", "b0", 0)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var calls atomic.Int32
+	slow := func() (string, time.Duration, error) {
+		calls.Add(1)
+		close(started)
+		<-release
+		return "a1", 0, nil
+	}
+	first := make(chan string, 1)
+	go func() {
+		v, _ := c.GetOrLoad("a", slow)
+		first <- v
+	}()
+	<-started
+
+	second := make(chan string, 1)
+	go func() {
+		v, _ := c.GetOrLoad("a", func() (string, time.Duration, error) {
+			calls.Add(1)
+			return "a2", 0, nil
+		})
+		second <- v
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, ok := c.Get("b"); !ok || v != "b0" {
+			t.Errorf("Get(b) = %q, %v; want b0, true", v, ok)
+		}
+		c.Set("b", "b1", 0)
+		if v, err := c.GetOrLoad("c", func() (string, time.Duration, error) { return "c1", 0, nil }); err != nil || v != "c1" {
+			t.Errorf("GetOrLoad(c) = %q, %v; want c1", v, err)
+		}
+		c.Delete("b")
+	}()
+	select {
+	case <-done:
+	case <-time.After(deadline):
+		t.Fatal("operations on other keys blocked behind the loader for a")
+	}
+
+	select {
+	case v := <-second:
+		t.Fatalf("second GetOrLoad(a) returned %q before the first load finished", v)
+	default:
+	}
+	close(release)
+	for name, ch := range map[string]chan string{"first": first, "second": second} {
+		select {
+		case v := <-ch:
+			if v != "a1" {
+				t.Errorf("%s GetOrLoad(a) = %q, want a1", name, v)
+			}
+		case <-time.After(deadline):
+			t.Fatalf("%s GetOrLoad(a) didn't return once the loader finished", name)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("loader for a called %d times, want 1", n)
+	}
+}
+
+// TestLoaderValueTooLarge checks that GetOrLoad holds a loaded value to
+// MaxValueBytes like any other write, failing every caller sharing the load.
+func TestLoaderValueTooLarge(t *testing.T) {
+	c := NewLRUCache[string](10)
+	c.MaxValueBytes = 4
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	loader := func() (string, time.Duration, error) {
+		close(started)
+		<-release
+		return "far too long", 0, nil
+	}
+	waiter := make(chan error, 1)
+	go func() {
+		<-started
+		_, err := c.GetOrLoad("a", func() (string, time.Duration, error) {
+			t.Error("second loader ran")
+			return "", 0, nil
+		})
+		waiter <- err
+	}()
+	go func() {
+		// Give the waiter time to join the in-flight load.
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+
+	if _, err := c.GetOrLoad("a", loader); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("GetOrLoad: err = %v, want ErrValueTooLarge", err)
+	}
+	if err := <-waiter; !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("waiting GetOrLoad: err = %v, want ErrValueTooLarge", err)
+	}
+	if v := c.GetOrSet("b", func() (string, time.Duration) { return "also too long", 0 }); v != "" {
+		t.Errorf("GetOrSet = %q, want the zero value", v)
+	}
+	if c.Contains("a") || c.Contains("b") {
+		t.Errorf("oversized values were stored: keys %v", c.Keys())
+	}
+}
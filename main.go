@@ -1,209 +1,819 @@
 package main
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"crypto/cipher"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
-	"sync"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 )
 
-type entry struct {
-	key        string
-	value      interface{}
-	expiration time.Time
-	next       *entry
-	prev       *entry
-}
+// defaultCapacity is the number of entries the cache holds when neither the
+// -capacity flag nor CACHE_CAPACITY is set.
+const defaultCapacity = 1000
 
-type LRUCache struct {
-	capacity int
-	size     int
-	cache    map[string]*entry
-	head     *entry
-	tail     *entry
-	mutex    sync.Mutex
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
-func NewLRUCache(capacity int) *LRUCache {
-	return &LRUCache{
-		capacity: capacity,
-		cache:    make(map[string]*entry),
+// envString returns the value of the named environment variable, or fallback
+// when it is unset or empty.
+func envString(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
 	}
+	return fallback
 }
 
-func (c *LRUCache) Get(key string) (interface{}, bool) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+// envInt returns the integer value of the named environment variable, or
+// fallback when it is unset. A set but malformed value is fatal.
+func envInt(name string, fallback int) int {
+	raw, ok := os.LookupEnv(name)
+	if !ok || raw == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		fatal("invalid environment variable", "name", name, "value", raw, "err", err)
+	}
+	return n
+}
 
-	if ent, ok := c.cache[key]; ok {
-		if ent.expiration.After(time.Now()) {
-			log.Printf("Cache HIT: Key %s", key)
-			c.moveToFront(ent)
-			return ent.value, true
-		} else {
-			log.Printf("Cache EXPIRED: Key %s", key)
-			c.removeEntry(ent)
+// splitList splits a comma-separated flag value, dropping blanks.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
-	} else {
-		log.Printf("Cache MISS: Key %s", key)
 	}
-	return nil, false
+	return items
 }
 
-func (c *LRUCache) Set(key string, value interface{}, expiration time.Duration) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	expirationTime := time.Now().Add(expiration)
-	if ent, ok := c.cache[key]; ok {
-		// Update existing entry
-		log.Printf("Cache UPDATE: Key %s", key)
-		ent.value = value
-		ent.expiration = expirationTime
-		c.moveToFront(ent)
-	} else {
-		// Add new entry
-		log.Printf("Cache INSERT: Key %s", key)
-		newEntry := &entry{
-			key:        key,
-			value:      value,
-			expiration: expirationTime,
+// loadState restores cache from the snapshot at snapshotPath and replays
+// the WAL at walPath on top, then attaches a freshly opened WAL, which it
+// returns. Either path may be empty to skip that step. Failures other than a
+// missing or unreadable snapshot are fatal.
+func loadState(cache *LRUCache[interface{}], snapshotPath, walPath string, walMaxBytes int64) *WAL[interface{}] {
+	if snapshotPath != "" {
+		n, err := cache.RestoreSnapshot(snapshotPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			slog.Info("no snapshot, starting empty", "path", snapshotPath)
+		case errors.Is(err, ErrCorruptSnapshot):
+			slog.Warn("ignoring unreadable snapshot, starting empty", "path", snapshotPath, "err", err)
+		case err != nil:
+			fatal("failed to load snapshot", "path", snapshotPath, "err", err)
+		default:
+			slog.Info("restored snapshot", "path", snapshotPath, "entries", n)
+		}
+	}
+	var wal *WAL[interface{}]
+	if walPath != "" {
+		n, err := cache.ReplayWAL(walPath)
+		if err != nil {
+			fatal("failed to replay write-ahead log", "path", walPath, "err", err)
 		}
-		c.cache[key] = newEntry
-		c.addToFront(newEntry)
-		c.size++
+		slog.Info("replayed write-ahead log", "path", walPath, "records", n)
 
-		// Evict if cache exceeds capacity
-		if c.size > c.capacity {
-			c.evictOldest()
+		wal, err = OpenWAL[interface{}](walPath, walMaxBytes)
+		if err != nil {
+			fatal("failed to open write-ahead log", "path", walPath, "err", err)
+		}
+		if err := cache.AttachWAL(wal, snapshotPath); err != nil {
+			fatal("failed to compact write-ahead log", "path", walPath, "err", err)
 		}
 	}
+	return wal
 }
 
-func (c *LRUCache) Delete(key string) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	if ent, ok := c.cache[key]; ok {
-		log.Printf("Cache DELETE: Key %s", key)
-		c.removeEntry(ent)
-	} else {
-		log.Printf("Cache DELETE FAILED: Key %s not found", key)
+func main() {
+	// Configuration precedence, highest first: command-line flag, -config
+	// file, environment variable, built-in default. The environment is
+	// consulted only to seed the flag's default, which the config file then
+	// overrides for the flags it names, so an explicit flag always wins.
+	configPath := flag.String("config", "",
+		"load settings from this YAML (.yaml, .yml) or JSON (.json) file, keyed by flag name; flags on the command line take precedence")
+	capacity := flag.Int("capacity", envInt("CACHE_CAPACITY", defaultCapacity),
+		"maximum number of cache entries (env CACHE_CAPACITY)")
+	addr := flag.String("addr", envString("LISTEN_ADDR", ":8080"),
+		"address to listen on, e.g. 127.0.0.1:9000 or :0 for an ephemeral port (env LISTEN_ADDR)")
+	policyName := flag.String("policy", "lru", "eviction policy: lru or lfu")
+	defaultTTL := flag.Duration("default-ttl", 0,
+		"expiration for writes that don't specify a TTL; 0 means they never expire")
+	staleWindow := flag.Duration("stale-window", 0,
+		"how long past its expiration an entry is still served, flagged as stale, while a client refreshes it")
+	idleTimeout := flag.Duration("idle-timeout", 0,
+		"expire entries not read or written for this long, on top of their TTL; 0 disables it")
+	ttlJitter := flag.Float64("ttl-jitter", 0,
+		"randomize each TTL within this fraction of itself, e.g. 0.1 for ±10%; PUT ?jitter=false opts out")
+	softDelete := flag.Duration("soft-delete", 0,
+		"keep deleted entries this long so POST /cache/{key}/undelete can restore them; 0 makes deletes final")
+	highWatermark := flag.Float64("evict-high-watermark", 1,
+		"with -evict-low-watermark, start batch eviction once the cache holds more than this fraction of its capacity")
+	lowWatermark := flag.Float64("evict-low-watermark", 0,
+		"evict down to this fraction of capacity in one pass once the high watermark is passed; 0 evicts one entry at a time")
+	pressureWindow := flag.Duration("pressure-window", time.Minute,
+		"window over which the eviction rate reported in the metrics is measured")
+	pressureThreshold := flag.Float64("pressure-threshold", 0,
+		"evictions per second, over -pressure-window, at which responses to writes carry X-Cache-Pressure: high; 0 never sets it")
+	reclaimExpired := flag.Bool("evict-expired-first", false,
+		"when the cache is full, remove an entry whose TTL has run out, if any, before evicting a live one; costs a heap lookup per eviction, not a scan")
+	pinnedOverflowName := flag.String("pinned-overflow", "reject",
+		"what a write that creates a key does when the cache is full of pinned entries: reject, failing with 507, or grow past the capacity")
+	maxBytes := flag.Int64("max-bytes", 0,
+		"approximate cap on the total size of stored values in bytes; 0 means no limit")
+	maxValueBytes := flag.Int64("max-value-bytes", 1<<20,
+		"largest value accepted, measured as its JSON encoding in bytes; 0 means no limit")
+	schemaPath := flag.String("schema", "",
+		"JSON Schema file that values PUT to /cache/{key} must match, in every namespace; a value that doesn't is rejected with 422. Empty disables validation")
+	maxListLength := flag.Int("max-list-length", 10000,
+		"most elements a list built with lpush or rpush may hold; 0 means no limit")
+	maxKeyLength := flag.Int("max-key-length", 250,
+		"longest key accepted in bytes; 0 means no limit")
+	keyPattern := flag.String("key-pattern", "",
+		"regular expression that every key must match in full, such as [A-Za-z0-9._:-]+; empty allows any key without control characters")
+	otelEndpoint := flag.String("otel-endpoint", envString("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		"OTLP/HTTP collector to export a trace span per request to, such as http://localhost:4318; empty disables tracing")
+	otelHashKeys := flag.Bool("otel-hash-keys", false,
+		"with -otel-endpoint, record a SHA-256 of each key in spans instead of the key")
+	originURL := flag.String("origin-url", "",
+		"read-through origin fetched on GET misses in the default cache, with {key} replaced by the escaped key, such as https://api.example/{key}; empty disables it")
+	negativeTTL := flag.Duration("negative-ttl", 0,
+		"with -origin-url, remember for this long that the origin answered 404 for a key, so misses on it skip the origin; 0 disables negative caching")
+	originTimeout := flag.Duration("origin-timeout", 5*time.Second,
+		"with -origin-url, how long a single origin fetch may take")
+	breakerThreshold := flag.Int("origin-breaker-threshold", 0,
+		"with -origin-url, consecutive origin failures after which origin fetches stop and misses get 503 for -origin-breaker-cooldown; 0 disables the circuit breaker")
+	breakerCoolDown := flag.Duration("origin-breaker-cooldown", 30*time.Second,
+		"with -origin-breaker-threshold, how long origin fetches stop before a single fetch is let through to test the origin")
+	writeThroughURL := flag.String("write-through-url", "",
+		"backing store that PUTs on the default cache are POSTed to, with {key} replaced by the escaped key; empty disables it")
+	writeThroughOrder := flag.String("write-through-order", "before",
+		"with -write-through-url, forward writes before or after storing them locally")
+	writeThroughPolicy := flag.String("write-through-policy", "strict",
+		"with -write-through-url, strict fails a write the backing store rejects with 502; best-effort only logs it")
+	writeThroughTimeout := flag.Duration("write-through-timeout", 5*time.Second,
+		"with -write-through-url, how long a single forwarded write may take")
+	redisAddr := flag.String("redis-addr", "",
+		"host:port of a Redis server to spill entries evicted for capacity to and read back on misses; empty keeps the cache in memory only")
+	redisPassword := flag.String("redis-password", envString("REDIS_PASSWORD", ""),
+		"with -redis-addr, password to AUTH with")
+	redisPrefix := flag.String("redis-prefix", "lru-cache:",
+		"with -redis-addr, prefix for the keys the cache stores in Redis")
+	redisTimeout := flag.Duration("redis-timeout", time.Second,
+		"with -redis-addr, how long a single Redis command may take")
+	compress := flag.Bool("compress", false,
+		"store large values gzip-compressed in memory")
+	compressMinBytes := flag.Int("compress-min-bytes", 1024,
+		"with -compress, only compress values whose JSON encoding is at least this long")
+	encryptionKey := flag.String("encryption-key", envString("CACHE_ENCRYPTION_KEY", ""),
+		"hex-encoded AES-128, AES-192 or AES-256 key to encrypt values with in memory, snapshots, the WAL and Redis; prefer CACHE_ENCRYPTION_KEY, which isn't visible in the process list. Empty stores values in the clear")
+	namespaceCapacity := flag.Int("namespace-capacity", 0,
+		"capacity of namespaces created on first write; 0 means the same as -capacity")
+	namespaceCapacities := flag.String("namespaces", "",
+		"per-namespace capacities as a comma-separated list of name=capacity")
+	maxNamespaces := flag.Int("max-namespaces", 64,
+		"maximum number of namespaces; 0 means no limit")
+	sweepInterval := flag.Duration("sweep-interval", 0,
+		"how often to remove expired entries in the background; 0 disables the sweeper")
+	sweepOnly := flag.Bool("sweep-only", false,
+		"leave removing expired entries to the sweeper, so reads never take the write lock to do it; requires -sweep-interval")
+	snapshotPath := flag.String("snapshot", "",
+		"file to restore the cache from on startup and save it to on shutdown")
+	snapshotInterval := flag.Duration("snapshot-interval", 0,
+		"how often to save the snapshot in the background; 0 saves only on shutdown")
+	walPath := flag.String("wal", "",
+		"write-ahead log file journaling every write; requires -snapshot")
+	walMaxBytes := flag.Int64("wal-max-bytes", 64<<20,
+		"compact the write-ahead log into the snapshot once it grows past this size")
+	authToken := flag.String("auth-token", envString("AUTH_TOKEN", ""),
+		"bearer token required on every request; empty leaves the API open (env AUTH_TOKEN)")
+	corsOrigins := flag.String("cors-origins", "http://localhost:3000",
+		"comma-separated origins allowed to make cross-origin requests; * allows any origin, without credentials")
+	corsMethods := flag.String("cors-methods", "GET,HEAD,POST,PUT,PATCH,DELETE",
+		"comma-separated methods allowed in cross-origin requests")
+	corsHeaders := flag.String("cors-headers", "Content-Type,Content-Encoding,Cache-Control,Authorization,If-Match,If-None-Match,X-Cache-TTL,X-Cache-Default",
+		"comma-separated request headers allowed in cross-origin requests")
+	rateLimit := flag.Float64("rate-limit", 0,
+		"requests per second allowed per client IP; 0 disables rate limiting")
+	rateBurst := flag.Int("rate-burst", 20,
+		"with -rate-limit, requests a client may make at once before being limited")
+	maxConcurrent := flag.Int("max-concurrent", 0,
+		"most requests served at once; beyond that requests get 503 with a Retry-After. 0 means no limit")
+	trustProxy := flag.Bool("trust-proxy", false,
+		"identify clients by X-Forwarded-For when rate limiting; only set behind a proxy that sets it")
+	warmConcurrency := flag.Int("warm-concurrency", 8,
+		"maximum number of origin fetches a single /cache/warm request runs at once")
+	warmTimeout := flag.Duration("warm-timeout", 10*time.Second,
+		"time limit for each origin fetch made by /cache/warm")
+	logLevel := flag.String("log-level", "info",
+		"minimum level to log: debug, info, warn or error; per-operation lines are debug")
+	accessLog := flag.String("access-log", "",
+		"write an HTTP access log line per request to this file, or to stdout for -; empty disables the access log")
+	accessLogFormat := flag.String("access-log-format", "combined",
+		"format of -access-log lines: combined or common")
+	readTimeout := flag.Duration("read-timeout", 30*time.Second,
+		"longest a client may take to send a request, headers and body; 0 means no limit")
+	writeTimeout := flag.Duration("write-timeout", 60*time.Second,
+		"longest a response may take to write, from the end of the request headers; the dump, NDJSON mget and event stream instead get 30s per write; 0 means no limit")
+	connIdleTimeout := flag.Duration("conn-idle-timeout", 120*time.Second,
+		"how long an idle keep-alive connection stays open waiting for its next request; 0 means the read timeout")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second,
+		"how long to wait for in-flight requests to finish on shutdown")
+	tlsCert := flag.String("tls-cert", "",
+		"PEM certificate chain to serve HTTPS, and HTTP/2, with; requires -tls-key")
+	tlsKey := flag.String("tls-key", "",
+		"PEM private key for -tls-cert")
+	expiryWebhook := flag.String("expiry-webhook", "",
+		"POST a JSON notification with the key and reason to this URL whenever an entry expires or is evicted; best-effort, from a bounded queue")
+	expiryWebhookPrefixes := flag.String("expiry-webhook-prefixes", "",
+		"comma-separated key prefixes to limit -expiry-webhook notifications to; empty notifies for every key")
+	adminAddr := flag.String("admin-addr", "",
+		"serve /metrics, /admin/, /debug/pprof/ and the probes on this separate address instead of -addr, e.g. 127.0.0.1:9090; empty serves everything on -addr")
+	pprofEnabled := flag.Bool("pprof", false,
+		"serve Go's profiling endpoints under /debug/pprof/, on -admin-addr if set; firewall them off, as profiles expose the process's internals")
+	flag.Parse()
+	if *configPath != "" {
+		if err := loadConfigFile(flag.CommandLine, *configPath); err != nil {
+			fatal("invalid config file", "path", *configPath, "err", err)
+		}
 	}
-}
 
-func (c *LRUCache) removeEntry(ent *entry) {
-	delete(c.cache, ent.key)
-	c.removeNode(ent)
-	c.size--
-}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fatal("invalid log level", "level", *logLevel, "err", err)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
 
-func (c *LRUCache) removeNode(ent *entry) {
-	if ent.prev != nil {
-		ent.prev.next = ent.next
-	} else {
-		c.head = ent.next
+	if *capacity <= 0 {
+		fatal("invalid capacity: must be a positive integer", "capacity", *capacity)
 	}
-	if ent.next != nil {
-		ent.next.prev = ent.prev
-	} else {
-		c.tail = ent.prev
+	policy, ok := ParsePolicy(*policyName)
+	if !ok {
+		fatal("invalid policy: must be lru or lfu", "policy", *policyName)
+	}
+	pinnedOverflow, ok := ParsePinnedOverflow(*pinnedOverflowName)
+	if !ok {
+		fatal("invalid pinned overflow: must be reject or grow", "overflow", *pinnedOverflowName)
+	}
+	if *defaultTTL < 0 {
+		fatal("invalid default TTL: must not be negative", "ttl", *defaultTTL)
+	}
+	if *ttlJitter < 0 || *ttlJitter >= 1 {
+		fatal("invalid TTL jitter: must be at least 0 and less than 1", "jitter", *ttlJitter)
+	}
+	if *lowWatermark < 0 || (*lowWatermark > 0 && (*highWatermark <= *lowWatermark || *highWatermark > 1)) {
+		fatal("invalid eviction watermarks: need 0 < low < high <= 1", "low", *lowWatermark, "high", *highWatermark)
+	}
+	if *softDelete < 0 {
+		fatal("invalid soft-delete window: must not be negative", "window", *softDelete)
+	}
+	if *staleWindow < 0 {
+		fatal("invalid stale window: must not be negative", "window", *staleWindow)
+	}
+	if *idleTimeout < 0 {
+		fatal("invalid idle timeout: must not be negative", "timeout", *idleTimeout)
+	}
+	if *maxListLength < 0 {
+		fatal("invalid max list length: must not be negative", "length", *maxListLength)
+	}
+	if *maxKeyLength < 0 {
+		fatal("invalid max key length: must not be negative", "length", *maxKeyLength)
+	}
+	pattern, err := compileKeyPattern(*keyPattern)
+	if err != nil {
+		fatal("invalid key pattern", "pattern", *keyPattern, "err", err)
+	}
+	keyRules := KeyRules{MaxLength: *maxKeyLength, Pattern: pattern}
+	var aead cipher.AEAD
+	if *encryptionKey != "" {
+		if aead, err = newAEAD(*encryptionKey); err != nil {
+			fatal("invalid -encryption-key", "err", err)
+		}
+	}
+	var schema *jsonSchema
+	if *schemaPath != "" {
+		if schema, err = loadJSONSchema(*schemaPath); err != nil {
+			fatal("invalid -schema", "path", *schemaPath, "err", err)
+		}
+	}
+	var limiter *concurrencyLimiter
+	if *maxConcurrent > 0 {
+		limiter = newConcurrencyLimiter(*maxConcurrent)
+	}
+	var readThrough *origin
+	var breaker *circuitBreaker
+	if *originURL != "" {
+		u, err := url.Parse(strings.ReplaceAll(*originURL, "{key}", "key"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("invalid -origin-url: must be an http or https URL", "url", *originURL)
+		}
+		if !strings.Contains(*originURL, "{key}") {
+			fatal("invalid -origin-url: must contain {key}", "url", *originURL)
+		}
+		if *originTimeout <= 0 {
+			fatal("invalid origin timeout: must be positive", "timeout", *originTimeout)
+		}
+		readThrough = newOrigin(*originURL, *originTimeout, *defaultTTL, *negativeTTL, *maxValueBytes)
+		if *breakerThreshold > 0 {
+			if *breakerCoolDown <= 0 {
+				fatal("invalid origin breaker cool-down: must be positive", "cooldown", *breakerCoolDown)
+			}
+			readThrough.breaker = newCircuitBreaker(*breakerThreshold, *breakerCoolDown)
+			breaker = readThrough.breaker
+		}
+	}
+	var forwardWrites *writeThrough
+	if *writeThroughURL != "" {
+		u, err := url.Parse(strings.ReplaceAll(*writeThroughURL, "{key}", "key"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("invalid -write-through-url: must be an http or https URL", "url", *writeThroughURL)
+		}
+		if !strings.Contains(*writeThroughURL, "{key}") {
+			fatal("invalid -write-through-url: must contain {key}", "url", *writeThroughURL)
+		}
+		if *writeThroughOrder != "before" && *writeThroughOrder != "after" {
+			fatal("invalid write-through order: must be before or after", "order", *writeThroughOrder)
+		}
+		if *writeThroughPolicy != "strict" && *writeThroughPolicy != "best-effort" {
+			fatal("invalid write-through policy: must be strict or best-effort", "policy", *writeThroughPolicy)
+		}
+		if *writeThroughTimeout <= 0 {
+			fatal("invalid write-through timeout: must be positive", "timeout", *writeThroughTimeout)
+		}
+		forwardWrites = newWriteThrough(*writeThroughURL, *writeThroughTimeout,
+			*writeThroughOrder == "after", *writeThroughPolicy == "strict")
+	}
+	if *redisAddr != "" && *redisTimeout <= 0 {
+		fatal("invalid redis timeout: must be positive", "timeout", *redisTimeout)
+	}
+	if *otelEndpoint != "" {
+		if u, err := url.Parse(*otelEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("invalid -otel-endpoint: must be an http or https URL", "endpoint", *otelEndpoint)
+		}
 	}
-}
 
-func (c *LRUCache) moveToFront(ent *entry) {
-	c.removeNode(ent)
-	c.addToFront(ent)
-}
+	if *namespaceCapacity == 0 {
+		*namespaceCapacity = *capacity
+	}
+	if *namespaceCapacity < 0 {
+		fatal("invalid namespace capacity: must be a positive integer", "capacity", *namespaceCapacity)
+	}
+	capacities, err := parseCapacities(*namespaceCapacities)
+	if err != nil {
+		fatal("invalid -namespaces", "err", err)
+	}
 
-func (c *LRUCache) addToFront(ent *entry) {
-	ent.next = c.head
-	ent.prev = nil
-	if c.head != nil {
-		c.head.prev = ent
+	// Nothing else would remove expired entries.
+	if *sweepOnly && *sweepInterval <= 0 {
+		fatal("-sweep-only requires -sweep-interval")
 	}
-	c.head = ent
-	if c.tail == nil {
-		c.tail = ent
+	if *readTimeout < 0 || *writeTimeout < 0 || *connIdleTimeout < 0 {
+		fatal("invalid server timeouts: must not be negative", "read", *readTimeout, "write", *writeTimeout, "idle", *connIdleTimeout)
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatal("-tls-cert and -tls-key must be given together", "tls_cert", *tlsCert, "tls_key", *tlsKey)
+	}
+	var tlsConfig *tls.Config
+	if *tlsCert != "" {
+		// Loaded up front so that a bad pair fails startup, not the first
+		// handshake.
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			fatal("failed to load TLS certificate", "tls_cert", *tlsCert, "tls_key", *tlsKey, "err", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if *accessLogFormat != "combined" && *accessLogFormat != "common" {
+		fatal("invalid access log format: must be combined or common", "format", *accessLogFormat)
+	}
+	if *negativeTTL < 0 {
+		fatal("invalid negative TTL: must not be negative", "ttl", *negativeTTL)
+	}
+	if *negativeTTL > 0 && *originURL == "" {
+		fatal("-negative-ttl requires -origin-url")
+	}
+	if *breakerThreshold < 0 {
+		fatal("invalid origin breaker threshold: must not be negative", "threshold", *breakerThreshold)
+	}
+	if *breakerThreshold > 0 && *originURL == "" {
+		fatal("-origin-breaker-threshold requires -origin-url")
+	}
+	if *pressureWindow < pressureSampleInterval {
+		fatal("invalid pressure window: must be at least a second", "window", *pressureWindow)
+	}
+	if *pressureThreshold < 0 {
+		fatal("invalid pressure threshold: must not be negative", "threshold", *pressureThreshold)
+	}
+	if *expiryWebhook != "" {
+		if u, err := url.Parse(*expiryWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fatal("invalid -expiry-webhook: must be an http or https URL", "url", *expiryWebhook)
+		}
+	} else if *expiryWebhookPrefixes != "" {
+		fatal("-expiry-webhook-prefixes requires -expiry-webhook")
+	}
+	if *maxConcurrent < 0 {
+		fatal("invalid max concurrent requests: must not be negative", "max", *maxConcurrent)
+	}
+	if *walPath != "" && *snapshotPath == "" {
+		fatal("-wal requires -snapshot to compact the log into")
 	}
-}
 
-func (c *LRUCache) evictOldest() {
-	if c.tail != nil {
-		log.Printf("Cache EVICT: Key %s", c.tail.key)
-		c.removeEntry(c.tail)
+	var notifier *expiryNotifier
+	if *expiryWebhook != "" {
+		notifier = newExpiryNotifier(*expiryWebhook, splitList(*expiryWebhookPrefixes))
 	}
-}
 
-func cacheGetHandler(cache *LRUCache) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		key := params["key"]
+	cache := newCache[interface{}](*capacity, policy)
+	cache.DefaultTTL = *defaultTTL
+	cache.StaleWindow = *staleWindow
+	cache.IdleTimeout = *idleTimeout
+	cache.SweepOnly = *sweepOnly
+	cache.TTLJitter = *ttlJitter
+	cache.UndoWindow = *softDelete
+	cache.HighWatermark = *highWatermark
+	cache.LowWatermark = *lowWatermark
+	cache.ReclaimExpiredFirst = *reclaimExpired
+	cache.PinnedOverflow = pinnedOverflow
+	cache.MaxBytes = *maxBytes
+	cache.MaxValueBytes = *maxValueBytes
+	cache.MaxListLength = *maxListLength
+	cache.KeyRules = keyRules
+	if notifier != nil {
+		cache.OnEvict = notifier.onEvict("")
+	}
+	// Handlers go through store, which adds encryption and then compression
+	// on top of the cache when enabled, so values are compressed before they
+	// are encrypted. Entries restored from a snapshot or the WAL are stored
+	// uncompressed until they are next written.
+	var store Cache[interface{}] = cache
+	if aead != nil {
+		store = newEncryptedCache(store, aead)
+	}
+	if *compress {
+		store = newCompressedCache(store, *compressMinBytes)
+	}
+	if *redisAddr != "" {
+		tiered := newTieredCache(store, newRedisStore(*redisAddr, *redisPassword, *redisPrefix), *redisTimeout)
+		cache.Spill = tiered.spill
+		store = tiered
+	}
+	namespaces := newNamespaceRegistry(*namespaceCapacity, capacities, *maxNamespaces, func(name string, capacity int) namespace {
+		ns := namespace{cache: newCache[interface{}](capacity, policy)}
+		if notifier != nil {
+			ns.cache.OnEvict = notifier.onEvict(name)
+		}
+		ns.cache.DefaultTTL = *defaultTTL
+		ns.cache.StaleWindow = *staleWindow
+		ns.cache.IdleTimeout = *idleTimeout
+		ns.cache.SweepOnly = *sweepOnly
+		ns.cache.TTLJitter = *ttlJitter
+		ns.cache.UndoWindow = *softDelete
+		ns.cache.HighWatermark = *highWatermark
+		ns.cache.LowWatermark = *lowWatermark
+		ns.cache.ReclaimExpiredFirst = *reclaimExpired
+		ns.cache.PinnedOverflow = pinnedOverflow
+		ns.cache.MaxBytes = *maxBytes
+		ns.cache.MaxValueBytes = *maxValueBytes
+		ns.cache.MaxListLength = *maxListLength
+		ns.cache.KeyRules = keyRules
+		if *sweepInterval > 0 {
+			ns.cache.StartSweeper(*sweepInterval)
+		}
+		ns.store = ns.cache
+		if aead != nil {
+			ns.store = newEncryptedCache(ns.store, aead)
+		}
+		if *compress {
+			ns.store = newCompressedCache(ns.store, *compressMinBytes)
+		}
+		return ns
+	})
+	pressure := newEvictionPressure(func() uint64 {
+		n := store.Metrics().Evictions
+		for _, name := range namespaces.names() {
+			if ns, ok := namespaces.get(name); ok {
+				n += ns.Metrics().Evictions
+			}
+		}
+		return n
+	}, *pressureWindow, *pressureThreshold)
+
+	// shutdown is closed when the server starts shutting down, to end
+	// streaming responses.
+	shutdown := make(chan struct{})
+	// ready is set once the startup snapshot and WAL have been loaded; until
+	// then /readyz and the cache routes answer 503.
+	var ready atomic.Bool
 
-		log.Printf("GET request received for key: %s", key)
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	r.MethodNotAllowedHandler = methodNotAllowedHandler(r)
+	// Fixed paths are registered before /cache/{key} so they aren't captured
+	// as keys.
+	r.HandleFunc("/cache", cacheClearHandler(store)).Methods("DELETE")
+	r.HandleFunc("/cache/stats", cacheStatsHandler(store)).Methods("GET")
+	r.HandleFunc("/cache/keys", cacheKeysHandler(store)).Methods("GET")
+	r.HandleFunc("/cache/random", cacheRandomHandler(store)).Methods("GET")
+	r.HandleFunc("/cache/bulk", cacheBulkSetHandler(store, *defaultTTL, *maxValueBytes, keyRules)).Methods("POST")
+	r.HandleFunc("/cache/mget", cacheMultiGetHandler(store)).Methods("GET", "POST")
+	r.HandleFunc("/cache/txn", cacheTxnHandler(store, *defaultTTL)).Methods("POST")
+	r.HandleFunc("/cache/mdelete", cacheMultiDeleteHandler(store)).Methods("POST")
+	r.HandleFunc("/cache/dump", cacheDumpHandler(store)).Methods("GET")
+	r.HandleFunc("/cache/restore", cacheRestoreHandler(store, *maxValueBytes, keyRules)).Methods("POST")
+	r.HandleFunc("/cache/warm", newWarmer(*warmConcurrency, *warmTimeout, *defaultTTL, *maxValueBytes).handler(store)).Methods("POST")
+	r.HandleFunc("/cache/events", cacheEventsHandler(store, shutdown)).Methods("GET")
+	// With -admin-addr the operator endpoints get a router of their own,
+	// served on that address only.
+	admin := r
+	if *adminAddr != "" {
+		admin = mux.NewRouter()
+		admin.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+		admin.MethodNotAllowedHandler = methodNotAllowedHandler(admin)
+	}
+	admin.HandleFunc("/admin/resize", cacheResizeHandler(store)).Methods("POST")
+	admin.HandleFunc("/admin/evictions", cacheEvictionsHandler(store)).Methods("GET")
+	admin.HandleFunc("/admin/stats/reset", cacheStatsResetHandler(store, namespaces)).Methods("POST")
+	admin.HandleFunc("/metrics", prometheusHandler(store, namespaces, breaker, limiter, pressure)).Methods("GET")
+	admin.HandleFunc("/metrics/json", metricsHandler(store, namespaces, breaker, limiter, pressure)).Methods("GET")
+	if *pprofEnabled {
+		registerPprof(admin)
+	}
+	r.HandleFunc("/cache/{key}", cacheGetHandler(store, *defaultTTL, readThrough)).Methods("GET")
+	r.HandleFunc("/cache/{key}", cacheHeadHandler(store)).Methods("HEAD")
+	r.HandleFunc("/cache/{key}", cacheSetHandler(store, *defaultTTL, *maxValueBytes, schema, forwardWrites)).Methods("PUT")
+	r.HandleFunc("/cache/{key}", cachePatchHandler(store, *maxValueBytes, forwardWrites)).Methods("PATCH")
+	r.HandleFunc("/cache/{key}", cacheDeleteHandler(store)).Methods("DELETE")
+	r.HandleFunc("/cache/{key}/incr", cacheIncrHandler(store)).Methods("POST")
+	r.HandleFunc("/cache/{key}/append", cacheAppendHandler(*maxValueBytes)(store)).Methods("POST")
+	r.HandleFunc("/cache/{key}/touch", cacheTouchHandler(store, *defaultTTL)).Methods("POST")
+	r.HandleFunc("/cache/{key}/undelete", cacheUndeleteHandler(store)).Methods("POST")
+	r.HandleFunc("/cache/{key}/pin", cachePinHandler(store)).Methods("POST")
+	r.HandleFunc("/cache/{key}/unpin", cacheUnpinHandler(store)).Methods("POST")
+	r.HandleFunc("/cache/{key}/lpush", cachePushHandler(true)(store)).Methods("POST")
+	r.HandleFunc("/cache/{key}/rpush", cachePushHandler(false)(store)).Methods("POST")
+	// Registered ahead of the namespace routes, so a GET for a key named
+	// "debug", "lrange", "llen" or "ttl" in a namespace is answered as that
+	// view of the default cache's key named after the namespace, and a PUT
+	// of a key named "ttl" there sets a TTL instead.
+	r.HandleFunc("/cache/{key}/debug", cacheDebugHandler(store)).Methods("GET")
+	r.HandleFunc("/cache/{key}/lrange", cacheRangeHandler(store)).Methods("GET")
+	r.HandleFunc("/cache/{key}/llen", cacheLenHandler(store)).Methods("GET")
+	r.HandleFunc("/cache/{key}/ttl", cacheTTLHandler(store)).Methods("GET")
+	r.HandleFunc("/cache/{key}/ttl", cacheSetTTLHandler(store)).Methods("PUT")
+	// Likewise ahead of the namespace routes, which would otherwise take a
+	// field named "incr" or "debug" for a namespaced key operation.
+	r.HandleFunc("/cache/{key}/field/{field}", cacheFieldGetHandler(store)).Methods("GET")
+	r.HandleFunc("/cache/{key}/field/{field}", cacheFieldSetHandler(*maxValueBytes)(store)).Methods("PUT")
+	r.HandleFunc("/cache/{key}/field/{field}", cacheFieldDeleteHandler(store)).Methods("DELETE")
+
+	// Namespaced keys live under /cache/{namespace}/{key}; operations on a
+	// namespace as a whole are under /namespaces, since DELETE
+	// /cache/{namespace} already deletes a key of the default cache.
+	// Writes create the namespace.
+	r.HandleFunc("/namespaces", namespacesHandler(namespaces)).Methods("GET")
+	r.HandleFunc("/namespaces/{namespace}", namespaces.handle(false, cacheClearHandler)).Methods("DELETE")
+	r.HandleFunc("/namespaces/{namespace}/stats", namespaces.handle(false, cacheStatsHandler)).Methods("GET")
+	r.HandleFunc("/namespaces/{namespace}/keys", namespaces.handle(false, cacheKeysHandler)).Methods("GET")
+	r.HandleFunc("/namespaces/{namespace}/random", namespaces.handle(false, cacheRandomHandler)).Methods("GET")
+	r.HandleFunc("/cache/{namespace}/{key}", namespaces.handle(false, func(c Cache[interface{}]) http.HandlerFunc {
+		return cacheGetHandler(c, *defaultTTL, nil)
+	})).Methods("GET")
+	r.HandleFunc("/cache/{namespace}/{key}", namespaces.handle(false, cacheHeadHandler)).Methods("HEAD")
+	r.HandleFunc("/cache/{namespace}/{key}", namespaces.handle(true, func(c Cache[interface{}]) http.HandlerFunc {
+		return cacheSetHandler(c, *defaultTTL, *maxValueBytes, schema, nil)
+	})).Methods("PUT")
+	r.HandleFunc("/cache/{namespace}/{key}", namespaces.handle(false, func(c Cache[interface{}]) http.HandlerFunc {
+		return cachePatchHandler(c, *maxValueBytes, nil)
+	})).Methods("PATCH")
+	r.HandleFunc("/cache/{namespace}/{key}", namespaces.handle(false, cacheDeleteHandler)).Methods("DELETE")
+	r.HandleFunc("/cache/{namespace}/{key}/incr", namespaces.handle(true, cacheIncrHandler)).Methods("POST")
+	r.HandleFunc("/cache/{namespace}/{key}/append", namespaces.handle(true, cacheAppendHandler(*maxValueBytes))).Methods("POST")
+	r.HandleFunc("/cache/{namespace}/{key}/debug", namespaces.handle(false, cacheDebugHandler)).Methods("GET")
+	r.HandleFunc("/cache/{namespace}/{key}/lpush", namespaces.handle(true, cachePushHandler(true))).Methods("POST")
+	r.HandleFunc("/cache/{namespace}/{key}/rpush", namespaces.handle(true, cachePushHandler(false))).Methods("POST")
+	r.HandleFunc("/cache/{namespace}/{key}/lrange", namespaces.handle(false, cacheRangeHandler)).Methods("GET")
+	r.HandleFunc("/cache/{namespace}/{key}/llen", namespaces.handle(false, cacheLenHandler)).Methods("GET")
+	r.HandleFunc("/cache/{namespace}/{key}/ttl", namespaces.handle(false, cacheTTLHandler)).Methods("GET")
+	r.HandleFunc("/cache/{namespace}/{key}/ttl", namespaces.handle(false, cacheSetTTLHandler)).Methods("PUT")
+	r.HandleFunc("/cache/{namespace}/{key}/field/{field}", namespaces.handle(false, cacheFieldGetHandler)).Methods("GET")
+	r.HandleFunc("/cache/{namespace}/{key}/field/{field}", namespaces.handle(true, cacheFieldSetHandler(*maxValueBytes))).Methods("PUT")
+	r.HandleFunc("/cache/{namespace}/{key}/field/{field}", namespaces.handle(false, cacheFieldDeleteHandler)).Methods("DELETE")
+	r.HandleFunc("/cache/{namespace}/{key}/undelete", namespaces.handle(false, cacheUndeleteHandler)).Methods("POST")
+	r.HandleFunc("/cache/{namespace}/{key}/pin", namespaces.handle(false, cachePinHandler)).Methods("POST")
+	r.HandleFunc("/cache/{namespace}/{key}/unpin", namespaces.handle(false, cacheUnpinHandler)).Methods("POST")
+	r.HandleFunc("/cache/{namespace}/{key}/touch", namespaces.handle(false, func(c Cache[interface{}]) http.HandlerFunc {
+		return cacheTouchHandler(c, *defaultTTL)
+	})).Methods("POST")
+
+	var tracing *tracer
+	if *otelEndpoint != "" {
+		tracing = newTracer(*otelEndpoint, *otelHashKeys)
+		r.Use(tracing.Middleware)
+	}
+	r.Use(requireReady(&ready))
+	if limiter != nil {
+		r.Use(limiter.Middleware)
+	}
+	if *rateLimit > 0 {
+		r.Use(newRateLimiter(*rateLimit, *rateBurst, *trustProxy).Middleware)
+	}
+	if *authToken != "" {
+		r.Use(requireToken(*authToken))
+		if admin != r {
+			admin.Use(requireToken(*authToken))
+		}
+	} else {
+		slog.Warn("no -auth-token set; the cache API is open to anyone who can reach it")
+	}
+	r.Use(requireValidKey(keyRules))
+	if *pressureThreshold > 0 {
+		r.Use(pressure.Middleware)
+	}
 
-		if value, ok := cache.Get(key); ok {
-			json.NewEncoder(w).Encode(value)
+	// CORS middleware configuration. Credentials can't be combined with a
+	// wildcard origin, so they are only allowed for an explicit list.
+	origins := splitList(*corsOrigins)
+	corsOptions := []handlers.CORSOption{
+		handlers.AllowedOrigins(origins),
+		handlers.AllowedMethods(splitList(*corsMethods)),
+		handlers.AllowedHeaders(splitList(*corsHeaders)),
+		handlers.ExposedHeaders([]string{"ETag", "X-Cache-Version", "X-Cache-Expires-In", "X-Cache-Source", "X-Cache-Stale", "X-Cache-Refresh", "X-Cache-Negative", "X-Cache-Pressure"}),
+	}
+	if !slices.Contains(origins, "*") {
+		corsOptions = append(corsOptions, handlers.AllowCredentials())
+	}
+	corsHandler := handlers.CORS(corsOptions...)
+
+	api := logRequests(corsHandler(r))
+	// The access log goes to its own destination, apart from the structured
+	// logs on stderr, so that the two can be routed separately.
+	var accessLogFile *os.File
+	switch *accessLog {
+	case "":
+	case "-":
+		accessLogFile = os.Stdout
+	default:
+		accessLogFile, err = os.OpenFile(*accessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			fatal("failed to open access log", "path", *accessLog, "err", err)
+		}
+	}
+	if accessLogFile != nil {
+		if *accessLogFormat == "common" {
+			api = handlers.LoggingHandler(accessLogFile, api)
 		} else {
-			http.Error(w, "Key not found", http.StatusNotFound)
+			api = handlers.CombinedLoggingHandler(accessLogFile, api)
 		}
 	}
-}
 
-func cacheSetHandler(cache *LRUCache) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		key := params["key"]
-		var value interface{}
+	// The probes sit outside the router so that they skip auth, CORS and
+	// request logging. With -admin-addr they are served there, and the
+	// admin listener skips readiness, the concurrency and rate limits and
+	// CORS, so that metrics can be scraped during startup and overload.
+	root := http.NewServeMux()
+	root.Handle("/", api)
+	probes := root
+	if admin != r {
+		probes = http.NewServeMux()
+		probes.Handle("/", logRequests(admin))
+	}
+	probes.HandleFunc("/healthz", healthzHandler)
+	probes.HandleFunc("/readyz", readyzHandler(&ready))
+
+	// Without timeouts a client that sends or reads slowly, or just keeps
+	// its connection open, holds a goroutine and a socket indefinitely.
+	server := &http.Server{
+		Handler:      root,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *connIdleTimeout,
+		TLSConfig:    tlsConfig,
+	}
+	server.RegisterOnShutdown(func() { close(shutdown) })
 
-		err := json.NewDecoder(r.Body).Decode(&value)
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fatal("failed to listen", "addr", *addr, "err", err)
+	}
+	var adminServer *http.Server
+	var adminLn net.Listener
+	if admin != r {
+		adminServer = &http.Server{
+			Handler:      probes,
+			ReadTimeout:  *readTimeout,
+			WriteTimeout: *writeTimeout,
+			IdleTimeout:  *connIdleTimeout,
+			TLSConfig:    tlsConfig,
+		}
+		adminLn, err = net.Listen("tcp", *adminAddr)
 		if err != nil {
-			http.Error(w, "Invalid request payload", http.StatusBadRequest)
-			return
+			fatal("failed to listen", "admin_addr", *adminAddr, "err", err)
 		}
+	}
 
-		log.Printf("SET request received for key: %s", key)
-
-		// Example: Custom expiration of 10 seconds
-		cache.Set(key, value, 10*time.Second)
-		w.WriteHeader(http.StatusCreated)
+	// With TLS, ServeTLS also negotiates HTTP/2; both servers use the same
+	// certificate.
+	serve := func(srv *http.Server, ln net.Listener) error {
+		if tlsConfig != nil {
+			return srv.ServeTLS(ln, "", "")
+		}
+		return srv.Serve(ln)
+	}
+	serveErr := make(chan error, 2)
+	go func() {
+		slog.Info("starting server", "addr", ln.Addr().String(), "tls", tlsConfig != nil)
+		serveErr <- serve(server, ln)
+	}()
+	if adminServer != nil {
+		go func() {
+			slog.Info("starting admin server", "addr", adminLn.Addr().String(), "tls", tlsConfig != nil)
+			serveErr <- serve(adminServer, adminLn)
+		}()
 	}
-}
 
-func cacheDeleteHandler(cache *LRUCache) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		params := mux.Vars(r)
-		key := params["key"]
+	wal := loadState(cache, *snapshotPath, *walPath, *walMaxBytes)
+	if *sweepInterval > 0 {
+		cache.StartSweeper(*sweepInterval)
+	}
+	if *snapshotPath != "" && *snapshotInterval > 0 {
+		cache.StartSnapshots(*snapshotPath, *snapshotInterval)
+	}
+	ready.Store(true)
+	slog.Info("ready")
 
-		log.Printf("DELETE request received for key: %s", key)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 
-		cache.Delete(key)
-		w.WriteHeader(http.StatusNoContent)
+	select {
+	case err := <-serveErr:
+		fatal("server failed", "err", err)
+	case s := <-sig:
+		slog.Info("shutting down", "signal", s.String())
 	}
-}
 
-func main() {
-	cache := NewLRUCache(1000)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	// Both servers drain at once, within the same -shutdown-timeout.
+	adminDone := make(chan struct{})
+	go func() {
+		defer close(adminDone)
+		if adminServer == nil {
+			return
+		}
+		if err := adminServer.Shutdown(ctx); err != nil {
+			slog.Warn("admin server shutdown did not complete cleanly", "err", err)
+		}
+	}()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("server shutdown did not complete cleanly", "err", err)
+	} else {
+		slog.Info("server stopped accepting requests; in-flight requests drained")
+	}
+	<-adminDone
 
-	r := mux.NewRouter()
-	r.HandleFunc("/cache/{key}", cacheGetHandler(cache)).Methods("GET")
-	r.HandleFunc("/cache/{key}", cacheSetHandler(cache)).Methods("PUT")
-	r.HandleFunc("/cache/{key}", cacheDeleteHandler(cache)).Methods("DELETE")
-
-	// CORS middleware configuration
-	corsHandler := handlers.CORS(
-		handlers.AllowedHeaders([]string{"Content-Type"}),
-		handlers.AllowedOrigins([]string{"http://localhost:3000"}), // Replace with your frontend URL
-		handlers.AllowCredentials(),
-	)
-
-	// Apply CORS middleware to all routes
-	http.Handle("/", corsHandler(r))
-
-	log.Println("Starting server on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	if tracing != nil {
+		tracing.Close()
+	}
+	cache.Stop()
+	namespaces.Stop()
+	if notifier != nil {
+		notifier.Close()
+	}
+	if *snapshotPath != "" {
+		// With a WAL attached this also truncates the log.
+		if err := cache.Checkpoint(*snapshotPath); err != nil {
+			slog.Error("failed to save snapshot", "path", *snapshotPath, "err", err)
+		}
+	}
+	if wal != nil {
+		if err := wal.Close(); err != nil {
+			slog.Error("failed to close write-ahead log", "path", *walPath, "err", err)
+		}
+	}
+	if accessLogFile != nil && accessLogFile != os.Stdout {
+		if err := accessLogFile.Close(); err != nil {
+			slog.Error("failed to close access log", "path", *accessLog, "err", err)
+		}
+	}
+	slog.Info("shutdown complete")
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// cacheCounters tracks operation outcomes. The counters are atomic so they
+// can be bumped from the read-locked fast path of Get and read by the metrics
+// handler without taking the cache lock.
+type cacheCounters struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	expired   atomic.Uint64
+	evictions atomic.Uint64
+	inserts   atomic.Uint64
+	updates   atomic.Uint64
+	deletes   atomic.Uint64
+}
+
+// Metrics is a point-in-time copy of a cache's counters. Misses include
+// lookups that found an expired entry; Expired counts those separately, along
+// with entries removed by the background sweeper.
+type Metrics struct {
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Expired   uint64  `json:"expired"`
+	Evictions uint64  `json:"evictions"`
+	Inserts   uint64  `json:"inserts"`
+	Updates   uint64  `json:"updates"`
+	Deletes   uint64  `json:"deletes"`
+	HitRatio  float64 `json:"hit_ratio"`
+}
+
+func (m *cacheCounters) snapshot() Metrics {
+	return Metrics{
+		Hits:      m.hits.Load(),
+		Misses:    m.misses.Load(),
+		Expired:   m.expired.Load(),
+		Evictions: m.evictions.Load(),
+		Inserts:   m.inserts.Load(),
+		Updates:   m.updates.Load(),
+		Deletes:   m.deletes.Load(),
+	}
+}
+
+// reset zeroes the counters.
+func (m *cacheCounters) reset() {
+	m.hits.Store(0)
+	m.misses.Store(0)
+	m.expired.Store(0)
+	m.evictions.Store(0)
+	m.inserts.Store(0)
+	m.updates.Store(0)
+	m.deletes.Store(0)
+}
+
+// add accumulates other into m, leaving the hit ratio to be recomputed.
+func (m *Metrics) add(other Metrics) {
+	m.Hits += other.Hits
+	m.Misses += other.Misses
+	m.Expired += other.Expired
+	m.Evictions += other.Evictions
+	m.Inserts += other.Inserts
+	m.Updates += other.Updates
+	m.Deletes += other.Deletes
+}
+
+// computeHitRatio sets HitRatio to hits/(hits+misses), or 0 before any
+// lookups have happened.
+func (m *Metrics) computeHitRatio() {
+	if total := m.Hits + m.Misses; total > 0 {
+		m.HitRatio = float64(m.Hits) / float64(total)
+	} else {
+		m.HitRatio = 0
+	}
+}
+
+// Metrics returns a snapshot of the cache's operation counters.
+func (c *LRUCache[V]) Metrics() Metrics {
+	m := c.counters.snapshot()
+	m.computeHitRatio()
+	return m
+}
+
+// ResetStats zeroes the operation counters, so that the metrics cover only
+// what happens from now on. It takes the write lock, so no write or locked
+// lookup is counted half before and half after the reset. The one exception
+// is a Get on the read-locked fast path that has found its entry but not
+// yet been counted: it is counted after the reset, once. Counters only ever
+// go up, so none can go negative.
+func (c *LRUCache[V]) ResetStats() {
+	c.resetCounters()
+	slog.Info("cache", "op", "reset_stats", "at", time.Now().UTC())
+}
+
+func (c *LRUCache[V]) resetCounters() {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	c.counters.reset()
+}
+
+// ResetStats zeroes the counters of every shard, one shard at a time.
+func (s *ShardedLRUCache[V]) ResetStats() {
+	for _, shard := range s.shards {
+		shard.resetCounters()
+	}
+	slog.Info("cache", "op", "reset_stats", "at", time.Now().UTC())
+}
+
+// Metrics returns the counters summed over all shards.
+func (s *ShardedLRUCache[V]) Metrics() Metrics {
+	var m Metrics
+	for _, shard := range s.shards {
+		m.add(shard.counters.snapshot())
+	}
+	m.computeHitRatio()
+	return m
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logRequests logs one line per request with its method, path, status and
+// how long it took to serve.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		slog.Info("http",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// requireToken rejects requests that don't carry "Authorization: Bearer
+// <token>" with 401. The comparison takes constant time so the token can't be
+// guessed byte by byte from response timings.
+func requireToken(token string) mux.MiddlewareFunc {
+	want := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="lru-cache"`)
+				writeError(w, http.StatusUnauthorized, codeUnauthorized, "missing or invalid bearer token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireReady answers 503 until ready is set, so that no request sees the
+// cache while the startup snapshot is still being loaded into it.
+func requireReady(ready *atomic.Bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !ready.Load() {
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusServiceUnavailable, codeNotReady, "starting up")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// concurrencyLimiter caps how many requests are served at once. A request
+// beyond the cap is answered 503 at once with a Retry-After, rather than
+// queued, so that load the server can't keep up with doesn't pile up as
+// goroutines. Long-lived responses, such as an event stream, hold their slot
+// for as long as they last.
+type concurrencyLimiter struct {
+	slots    chan struct{}
+	inFlight atomic.Int64
+	rejected atomic.Uint64
+}
+
+func newConcurrencyLimiter(max int) *concurrencyLimiter {
+	return &concurrencyLimiter{slots: make(chan struct{}, max)}
+}
+
+func (l *concurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.rejected.Add(1)
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, codeOverloaded, "too many concurrent requests")
+			return
+		}
+		l.inFlight.Add(1)
+		defer func() {
+			l.inFlight.Add(-1)
+			<-l.slots
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// concurrencyMetrics is a point-in-time copy of a concurrencyLimiter's
+// counters.
+type concurrencyMetrics struct {
+	InFlight int64  `json:"in_flight"`
+	Limit    int    `json:"limit"`
+	Rejected uint64 `json:"rejected"`
+}
+
+func (l *concurrencyLimiter) metrics() concurrencyMetrics {
+	return concurrencyMetrics{InFlight: l.inFlight.Load(), Limit: cap(l.slots), Rejected: l.rejected.Load()}
+}
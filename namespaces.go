@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrTooManyNamespaces is returned when a write would create a namespace
+// beyond the configured limit.
+var ErrTooManyNamespaces = errors.New("too many namespaces")
+
+// namespace is one logical cache. store is what the handlers use; it may
+// wrap cache, which is kept for Stop.
+type namespace struct {
+	cache *LRUCache[interface{}]
+	store Cache[interface{}]
+}
+
+// namespaceRegistry holds the caches behind /cache/{namespace}/{key}. Each
+// namespace is an independent cache, created on its first write with the
+// capacity configured for its name, or defaultCapacity. Namespaces live in
+// memory only; snapshots and the WAL cover the default cache.
+type namespaceRegistry struct {
+	mu         sync.RWMutex
+	namespaces map[string]namespace
+
+	defaultCapacity int
+	capacities      map[string]int
+	// limit caps the number of namespaces; 0 means no limit.
+	limit int
+	// open creates the cache for a new namespace.
+	open func(name string, capacity int) namespace
+}
+
+func newNamespaceRegistry(defaultCapacity int, capacities map[string]int, limit int, open func(name string, capacity int) namespace) *namespaceRegistry {
+	return &namespaceRegistry{
+		namespaces:      make(map[string]namespace),
+		defaultCapacity: defaultCapacity,
+		capacities:      capacities,
+		limit:           limit,
+		open:            open,
+	}
+}
+
+// get returns the named namespace's cache, if it exists.
+func (n *namespaceRegistry) get(name string) (Cache[interface{}], bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	ns, ok := n.namespaces[name]
+	return ns.store, ok
+}
+
+// getOrCreate returns the named namespace's cache, creating it if needed.
+func (n *namespaceRegistry) getOrCreate(name string) (Cache[interface{}], error) {
+	if store, ok := n.get(name); ok {
+		return store, nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if ns, ok := n.namespaces[name]; ok {
+		return ns.store, nil
+	}
+	if n.limit > 0 && len(n.namespaces) >= n.limit {
+		return nil, ErrTooManyNamespaces
+	}
+	capacity, ok := n.capacities[name]
+	if !ok {
+		capacity = n.defaultCapacity
+	}
+	ns := n.open(name, capacity)
+	n.namespaces[name] = ns
+	return ns.store, nil
+}
+
+// names returns the existing namespaces in sorted order.
+func (n *namespaceRegistry) names() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	names := make([]string, 0, len(n.namespaces))
+	for name := range n.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stop stops the background tasks of every namespace.
+func (n *namespaceRegistry) Stop() {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	for _, ns := range n.namespaces {
+		ns.cache.Stop()
+	}
+}
+
+// handle adapts a handler factory to serve the namespace named in the route.
+// With create, a missing namespace is created; otherwise the request fails
+// with 404.
+func (n *namespaceRegistry) handle(create bool, handler func(Cache[interface{}]) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["namespace"]
+
+		var store Cache[interface{}]
+		if create {
+			var err error
+			store, err = n.getOrCreate(name)
+			if err != nil {
+				writeError(w, http.StatusInsufficientStorage, codeTooManyNamespaces, err.Error())
+				return
+			}
+		} else {
+			var ok bool
+			store, ok = n.get(name)
+			if !ok {
+				writeError(w, http.StatusNotFound, codeNamespaceNotFound, "namespace not found")
+				return
+			}
+		}
+		handler(store)(w, r)
+	}
+}
+
+// parseCapacities parses a comma-separated list of name=capacity pairs.
+func parseCapacities(raw string) (map[string]int, error) {
+	capacities := make(map[string]int)
+	if raw == "" {
+		return capacities, nil
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid namespace capacity %q: want name=capacity", pair)
+		}
+		capacity, err := strconv.Atoi(value)
+		if err != nil || capacity <= 0 {
+			return nil, fmt.Errorf("invalid capacity for namespace %q: %q", name, value)
+		}
+		capacities[name] = capacity
+	}
+	return capacities, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// SetNegative records that key is known not to exist, such as after the
+// origin answered 404, for ttl. Until then, or until a write stores the
+// key, GetItem, GetItemCtx and PeekItem report the key as a miss with
+// Item.Negative set, so the caller can skip looking it up again. A negative
+// record is not an entry: it isn't counted, listed, persisted or evicted,
+// and it is ignored if the key holds a live entry. At most capacity keys
+// are recorded at once; beyond that SetNegative does nothing.
+func (c *LRUCache[V]) SetNegative(key string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.unlock()
+
+	now := time.Now()
+	if ent, ok := c.cache[key]; ok && !c.expired(ent, now) {
+		slog.Debug("cache", "op", "set_negative", "key", key, "stored", false, "exists", true)
+		return
+	}
+	if _, ok := c.negatives[key]; !ok && len(c.negatives) >= c.capacity {
+		c.purgeNegativesLocked(now)
+	}
+	if _, ok := c.negatives[key]; !ok && len(c.negatives) >= c.capacity {
+		slog.Debug("cache", "op", "set_negative", "key", key, "stored", false)
+		return
+	}
+	if c.negatives == nil {
+		c.negatives = make(map[string]time.Time)
+	}
+	slog.Debug("cache", "op", "set_negative", "key", key, "ttl", ttl)
+	c.negatives[key] = now.Add(ttl)
+}
+
+// negative reports whether key is negatively cached at now. The caller must
+// hold c.mutex, for reading at least.
+func (c *LRUCache[V]) negative(key string, now time.Time) bool {
+	until, ok := c.negatives[key]
+	return ok && until.After(now)
+}
+
+// purgeNegativesLocked forgets the negative records that have run out and
+// returns how many it dropped. The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) purgeNegativesLocked(now time.Time) int {
+	purged := 0
+	for key, until := range c.negatives {
+		if !until.After(now) {
+			delete(c.negatives, key)
+			purged++
+		}
+	}
+	return purged
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotInteger is returned by Increment when the stored value isn't an
+// integer, or when the cache's value type can't hold an int64.
+var ErrNotInteger = errors.New("value is not an integer")
+
+// ErrOverflow is returned by Increment when the result doesn't fit in an
+// int64.
+var ErrOverflow = errors.New("increment would overflow")
+
+// Increment atomically adds delta to the integer stored under key and returns
+// the result. An absent or expired key is created holding delta, with
+// c.DefaultTTL. Incrementing an existing key preserves its expiration, so a
+// counter doesn't live forever just because it is busy; set it again to
+// extend its lifetime. A key that KeyRules reject fails with an error
+// wrapping ErrInvalidKey.
+func (c *LRUCache[V]) Increment(key string, delta int64) (int64, error) {
+	if err := c.KeyRules.Check(key); err != nil {
+		return 0, err
+	}
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if ok && c.expired(ent, time.Now()) {
+		c.expireLocked(ent)
+		ok = false
+	}
+	if err := c.checkRoom(key); err != nil {
+		return 0, err
+	}
+	if !ok {
+		value, ok := any(delta).(V)
+		if !ok {
+			return 0, ErrNotInteger
+		}
+		c.setLocked(key, value, c.jitter(c.DefaultTTL))
+		return delta, nil
+	}
+
+	current, err := toInt64(ent.value)
+	if err != nil {
+		return 0, err
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+
+	next := current + delta
+	value, ok := any(next).(V)
+	if !ok {
+		return 0, ErrNotInteger
+	}
+	c.replaceLocked(ent, value)
+	return next, nil
+}
+
+// Touch resets the expiration of a live key to ttl from now, or to never
+// with a ttl of zero, and marks it as most recently used, without changing
+// its value or version. It reports
+// false if the key is absent or already expired; an expired entry is removed
+// rather than brought back.
+func (c *LRUCache[V]) Touch(key string, ttl time.Duration) bool {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if !ok {
+		return false
+	}
+	if c.expired(ent, time.Now()) {
+		slog.Debug("cache", "op", "touch", "key", key, "hit", false, "expired", true)
+		c.expireLocked(ent)
+		return false
+	}
+
+	slog.Debug("cache", "op", "touch", "key", key, "hit", true)
+	c.setExpiration(ent, c.jitter(ttl))
+	c.promote(ent)
+	c.journalSet(ent)
+	return true
+}
+
+// toInt64 converts a stored value to an integer if it represents one. JSON
+// numbers decode as float64, so whole floats are accepted.
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, ErrNotInteger
+		}
+		return int64(v), nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		return n, nil
+	case blob:
+		n, err := strconv.ParseInt(strings.TrimSpace(string(v.Data)), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		return n, nil
+	case json.RawMessage:
+		var n json.Number
+		dec := json.NewDecoder(bytes.NewReader(v))
+		dec.UseNumber()
+		if err := dec.Decode(&n); err != nil {
+			return 0, ErrNotInteger
+		}
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		return toInt64(f)
+	default:
+		return 0, ErrNotInteger
+	}
+}
+
+// replaceLocked swaps the value of a live entry in place, keeping its
+// expiration, and marks it as most recently used. The caller must hold
+// c.mutex for writing.
+func (c *LRUCache[V]) replaceLocked(ent *entry[V], value V) {
+	slog.Debug("cache", "op", "update", "key", ent.key)
+	c.counters.updates.Add(1)
+	c.lastVersion++
+	ent.value = value
+	ent.version = c.lastVersion
+	ent.etag = valueETag(value)
+	c.promote(ent)
+	c.resizeLocked(ent)
+	c.journalSet(ent)
+	c.publish(EventUpdate, ent.key)
+	c.evictIfNeeded()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// errOriginNotFound is returned by fetchValue when the origin answers 404.
+var errOriginNotFound = errors.New("origin returned 404 Not Found")
+
+// fetchValue downloads u and returns its body as a value to store: raw JSON
+// for a JSON response and a blob carrying the response's Content-Type
+// otherwise. A body longer than maxValueBytes, when positive, fails with
+// ErrValueTooLarge.
+func fetchValue(ctx context.Context, client *http.Client, u string, maxValueBytes int64) (interface{}, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme %q", parsed.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errOriginNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("origin returned %s", resp.Status)
+	}
+
+	body := io.Reader(resp.Body)
+	if maxValueBytes > 0 {
+		body = io.LimitReader(resp.Body, maxValueBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if maxValueBytes > 0 && int64(len(data)) > maxValueBytes {
+		return nil, ErrValueTooLarge
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if isJSONContentType(contentType) {
+		if !json.Valid(data) {
+			return nil, errors.New("origin returned invalid JSON")
+		}
+		return json.RawMessage(data), nil
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	return blob{ContentType: contentType, Data: data}, nil
+}
+
+// origin is the read-through backend behind -origin-url. On a miss, GET
+// /cache/{key} fetches the key's URL, made by substituting the escaped key
+// for {key} in the template, and stores the body with the default TTL.
+// With a negativeTTL, a key the origin answers 404 for is negatively
+// cached for that long, so that misses on it don't reach the origin again
+// until then or until the key is written. With a breaker, fetches stop
+// for a while once the origin keeps failing; see circuitBreaker.
+type origin struct {
+	template      string
+	client        *http.Client
+	timeout       time.Duration
+	defaultTTL    time.Duration
+	negativeTTL   time.Duration
+	maxValueBytes int64
+	breaker       *circuitBreaker
+}
+
+func newOrigin(template string, timeout, defaultTTL, negativeTTL time.Duration, maxValueBytes int64) *origin {
+	return &origin{
+		template:      template,
+		client:        &http.Client{},
+		timeout:       timeout,
+		defaultTTL:    defaultTTL,
+		negativeTTL:   negativeTTL,
+		maxValueBytes: maxValueBytes,
+	}
+}
+
+// url returns the origin URL for key.
+func (o *origin) url(key string) string {
+	return strings.ReplaceAll(o.template, "{key}", url.PathEscape(key))
+}
+
+// load fetches key from the origin into cache and returns the stored item.
+// It goes through GetOrLoad, so concurrent misses for the same key share
+// one fetch; the fetch isn't tied to the first caller's request, so that
+// caller going away doesn't fail the others. An origin 404 is
+// errOriginNotFound and nothing is stored but the negative record; an open
+// breaker is errCircuitOpen.
+func (o *origin) load(ctx context.Context, cache Cache[interface{}], key string) (Item[interface{}], error) {
+	ctx = context.WithoutCancel(ctx)
+	value, err := cache.GetOrLoad(key, func() (interface{}, time.Duration, error) {
+		value, err := o.fetch(ctx, key)
+		return value, o.defaultTTL, err
+	})
+	if errors.Is(err, errOriginNotFound) && o.negativeTTL > 0 {
+		cache.SetNegative(key, o.negativeTTL)
+	}
+	if err != nil {
+		return Item[interface{}]{}, err
+	}
+	if item, ok := cache.PeekItem(key); ok {
+		return item, nil
+	}
+	return Item[interface{}]{Value: value}, nil
+}
+
+// refresh fetches key from the origin and stores it over whatever the cache
+// holds, for a request that bypasses the cache. Unlike load it defers to no
+// cached or negative record and shares its fetch with no other request. An
+// origin 404 removes the key, and records it as negative if negative
+// caching is on.
+func (o *origin) refresh(ctx context.Context, cache Cache[interface{}], key string) (Item[interface{}], error) {
+	value, err := o.fetch(ctx, key)
+	if errors.Is(err, errOriginNotFound) {
+		cache.Delete(key)
+		if o.negativeTTL > 0 {
+			cache.SetNegative(key, o.negativeTTL)
+		}
+	}
+	if err != nil {
+		return Item[interface{}]{}, err
+	}
+	cache.Set(key, value, o.defaultTTL)
+	if item, ok := cache.PeekItem(key); ok {
+		return item, nil
+	}
+	return Item[interface{}]{Value: value}, nil
+}
+
+// fetch fetches key from the origin through the breaker, if there is one.
+// Only failures to get an answer count against the origin: a 404 or a
+// value too large to store is the origin working.
+func (o *origin) fetch(ctx context.Context, key string) (interface{}, error) {
+	if o.breaker != nil && !o.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+	value, err := fetchValue(ctx, o.client, o.url(key), o.maxValueBytes)
+	if o.breaker != nil {
+		o.breaker.done(err == nil || errors.Is(err, errOriginNotFound) || errors.Is(err, ErrValueTooLarge))
+	}
+	return value, err
+}
+
+// writeThrough forwards PUTs on the default cache to the backing store behind
+// -write-through-url, POSTing the body, with its Content-Type, to the URL
+// made from the template as for origin. Writes are forwarded before they are
+// stored locally, or after with after set; conditional writes always go
+// after, since only then is it known whether they happen. With strict set a
+// failed forward fails the request with 502, and one made after the local
+// write also deletes the key again so the cache doesn't hold a value the
+// store lacks: the key gets back the value it held before, with its TTL and
+// version, or is deleted if it had none. Otherwise failures are only logged.
+type writeThrough struct {
+	template string
+	client   *http.Client
+	timeout  time.Duration
+	after    bool
+	strict   bool
+}
+
+func newWriteThrough(template string, timeout time.Duration, after, strict bool) *writeThrough {
+	return &writeThrough{
+		template: template,
+		client:   &http.Client{},
+		timeout:  timeout,
+		after:    after,
+		strict:   strict,
+	}
+}
+
+// send forwards a write of body under key to the backing store.
+func (wt *writeThrough) send(ctx context.Context, key, contentType string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, wt.timeout)
+	defer cancel()
+	u := strings.ReplaceAll(wt.template, "{key}", url.PathEscape(key))
+	err := wt.post(ctx, u, contentType, body)
+	if err != nil {
+		slog.Warn("cache", "op", "write_through", "key", key, "strict", wt.strict, "err", err)
+	}
+	return err
+}
+
+// undoWrite takes back a local write whose forward failed: given the item
+// the key held before it, if any, and the version the write produced, it
+// puts the old item back, or deletes the key if there was none. It reports
+// whether it could; it can't if the key has been written again since, in
+// which case that newer write is kept.
+func undoWrite(cache Cache[interface{}], key string, written uint64, prev Item[interface{}], existed bool) bool {
+	if existed {
+		return cache.Revert(key, written, prev)
+	}
+	deleted, _ := cache.CompareAndDelete(key, written)
+	return deleted
+}
+
+// writeFailure answers a request whose strict forward failed with err, once
+// undoWrite has been given the chance to take the local write back.
+func writeFailure(w http.ResponseWriter, undone bool, err error) {
+	if undone {
+		writeError(w, http.StatusBadGateway, codeWriteFailed, "write-through failed, local write undone: "+err.Error())
+		return
+	}
+	writeError(w, http.StatusBadGateway, codeWriteFailed, "write-through failed, local write already overwritten: "+err.Error())
+}
+
+func (wt *writeThrough) post(ctx context.Context, u, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := wt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("backing store returned %s", resp.Status)
+	}
+	return nil
+}
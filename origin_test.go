@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TestStrictWriteThroughFailureRestores checks that a strict forward made
+// after the write, and failing, puts back what the key held before: the old
+// value with its TTL and version for an update, nothing for an insert.
+func TestStrictWriteThroughFailureRestores(t *testing.T) {
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer store.Close()
+
+	cache := NewLRUCache[interface{}](10)
+	cache.Set("old", json.RawMessage(`1`), time.Hour)
+	before, _ := cache.PeekItem("old")
+
+	handler := cacheSetHandler(cache, 0, 0, nil, newWriteThrough(store.URL+"/{key}", time.Second, true, true))
+	put := func(key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/cache/"+key+"?ttl=1m", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, mux.SetURLVars(r, map[string]string{"key": key}))
+		return rec
+	}
+
+	if rec := put("old", `2`); rec.Code != http.StatusBadGateway {
+		t.Fatalf("update: status %d, want 502", rec.Code)
+	}
+	after, ok := cache.PeekItem("old")
+	if !ok {
+		t.Fatal("update: key was deleted instead of restored")
+	}
+	if string(after.Value.(json.RawMessage)) != `1` || after.Version != before.Version || !after.Expiration.Equal(before.Expiration) {
+		t.Errorf("update: restored %s version %d expiring %v, want %s version %d expiring %v",
+			after.Value, after.Version, after.Expiration, before.Value, before.Version, before.Expiration)
+	}
+
+	if rec := put("new", `3`); rec.Code != http.StatusBadGateway {
+		t.Fatalf("insert: status %d, want 502", rec.Code)
+	}
+	if cache.Contains("new") {
+		t.Error("insert: key kept after the forward failed")
+	}
+}
+
+func TestStrictWriteThroughFailureUnpatches(t *testing.T) {
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer store.Close()
+
+	cache := NewLRUCache[interface{}](10)
+	cache.Set("doc", json.RawMessage(`{"a":1,"b":2}`), time.Hour)
+	before, _ := cache.PeekItem("doc")
+
+	handler := cachePatchHandler(cache, 0, newWriteThrough(store.URL+"/{key}", time.Second, true, true))
+	r := httptest.NewRequest(http.MethodPatch, "/cache/doc?ttl=1m", strings.NewReader(`{"b":null,"c":3}`))
+	rec := httptest.NewRecorder()
+	handler(rec, mux.SetURLVars(r, map[string]string{"key": "doc"}))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status %d, want 502", rec.Code)
+	}
+
+	after, ok := cache.PeekItem("doc")
+	if !ok {
+		t.Fatal("key was deleted instead of restored")
+	}
+	if string(after.Value.(json.RawMessage)) != `{"a":1,"b":2}` || after.Version != before.Version || !after.Expiration.Equal(before.Expiration) {
+		t.Errorf("restored %s version %d expiring %v, want %s version %d expiring %v",
+			after.Value, after.Version, after.Expiration, before.Value, before.Version, before.Expiration)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrNotJSON is returned by MergePatch when the key holds a value that isn't
+// JSON, such as a blob, or when the cache's value type can't hold JSON.
+var ErrNotJSON = errors.New("value is not JSON")
+
+// MergePatch applies patch, an RFC 7386 JSON Merge Patch, to the JSON value
+// stored under key and stores the result, all under the lock, so concurrent
+// patches each see the other's result. It returns the new value, reporting
+// false without storing anything if the key is absent or expired. A ttl of
+// zero or more resets the expiration as Set does; KeepTTL keeps it. A result
+// over MaxValueBytes fails with ErrValueTooLarge.
+func (c *LRUCache[V]) MergePatch(key string, patch []byte, ttl time.Duration) (V, bool, error) {
+	var zero V
+	var p interface{}
+	if err := decodeJSON(patch, &p); err != nil {
+		return zero, false, err
+	}
+
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent, ok := c.cache[key]
+	if !ok {
+		return zero, false, nil
+	}
+	if c.expired(ent, time.Now()) {
+		c.expireLocked(ent)
+		return zero, false, nil
+	}
+	raw, isJSON, err := storedJSON(ent.value)
+	if err != nil {
+		return zero, false, err
+	}
+	var target interface{}
+	if !isJSON || decodeJSON(raw, &target) != nil {
+		return zero, false, ErrNotJSON
+	}
+	merged, err := json.Marshal(mergePatch(target, p))
+	if err != nil {
+		return zero, false, err
+	}
+	value, isV := any(json.RawMessage(merged)).(V)
+	if !isV {
+		return zero, false, ErrNotJSON
+	}
+	if err := c.checkValueSize(value); err != nil {
+		return zero, false, err
+	}
+
+	slog.Debug("cache", "op", "patch", "key", key, "ttl", ttl)
+	if ttl >= 0 {
+		c.setLocked(key, value, c.jitter(ttl))
+	} else {
+		c.replaceLocked(ent, value)
+	}
+	return value, true, nil
+}
+
+// mergePatch returns target with patch applied, following the MergePatch
+// function of RFC 7386: an object patch merges into an object target
+// member by member, a null member removes the member, and any other patch
+// replaces the target outright. target may be modified.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergePatch(t[name], value)
+		}
+	}
+	return t
+}
+
+// decodeJSON decodes data into v, keeping numbers as json.Number so that
+// they are written back exactly as they were.
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
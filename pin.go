@@ -0,0 +1,208 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrAllPinned is returned under PinnedReject by the writes that would create
+// a new key when the cache is full and every entry in it is pinned, so that
+// nothing can be evicted to make room.
+var ErrAllPinned = errors.New("cache is full of pinned entries")
+
+// PinnedOverflow selects what a cache does with a new key when it is full
+// and every entry in it is pinned.
+type PinnedOverflow int
+
+const (
+	// PinnedReject refuses the write: SetCtx, Increment, Append, the list
+	// and hash writes and GetOrLoad fail with ErrAllPinned, as does a
+	// Transaction that would need the room, before applying any op. SetMany
+	// reports the error for the entry, Set stores nothing, SetNX reports
+	// false and a snapshot restore skips the key. Replaying the WAL and
+	// Undelete, which put back what the cache held before, still store the
+	// key, as under PinnedGrow.
+	PinnedReject PinnedOverflow = iota
+	// PinnedGrow stores the key anyway, taking the cache over its capacity
+	// until pinned entries are unpinned or removed.
+	PinnedGrow
+)
+
+// ParsePinnedOverflow maps "reject" or "grow" to a PinnedOverflow.
+func ParsePinnedOverflow(name string) (PinnedOverflow, bool) {
+	switch name {
+	case "reject":
+		return PinnedReject, true
+	case "grow":
+		return PinnedGrow, true
+	}
+	return 0, false
+}
+
+type pinKey struct{}
+
+// WithPin returns a context that makes SetCtx pin the entry it writes; see
+// LRUCache.Pin.
+func WithPin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pinKey{}, true)
+}
+
+func pinRequested(ctx context.Context) bool {
+	pin, _ := ctx.Value(pinKey{}).(bool)
+	return pin
+}
+
+// Pin marks the entry under key as pinned and reports whether there was a
+// live one; pinning doesn't count as a use. Capacity eviction skips pinned
+// entries, whatever the policy, so a pinned key stays until it is unpinned,
+// deleted or cleared, or its TTL runs out; pinning doesn't stop expiry. Writing the key again keeps the
+// pin. Pinned entries still count against the capacity and MaxBytes: once
+// they fill the cache, new keys are refused or take the cache over its
+// capacity as PinnedOverflow says. Under LRU, eviction walks past the pinned
+// entries at the tail, so pinning a large share of a busy cache slows it;
+// under LFU they leave the frequency heap and cost nothing. Pins aren't
+// saved in snapshots or the WAL.
+func (c *LRUCache[V]) Pin(key string) bool {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent := c.liveLocked(key)
+	ok := ent != nil
+	if ok {
+		c.pinLocked(ent)
+	}
+	slog.Debug("cache", "op", "pin", "key", key, "found", ok)
+	return ok
+}
+
+// Unpin makes the entry under key evictable again and reports whether there
+// was a live pinned one. If pinned entries had taken the cache over its
+// capacity, it evicts what it now can to get back within it.
+func (c *LRUCache[V]) Unpin(key string) bool {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	ent := c.liveLocked(key)
+	ok := ent != nil && ent.pinned
+	if ok {
+		c.unpinLocked(ent)
+		c.evictIfNeeded()
+	}
+	slog.Debug("cache", "op", "unpin", "key", key, "found", ok)
+	return ok
+}
+
+// Pinned returns the number of pinned entries, including any that have
+// expired but have not been removed yet.
+func (c *LRUCache[V]) Pinned() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.pinned
+}
+
+// liveLocked returns the live entry for key without counting a use of it, or
+// nil, removing the entry if it has expired. The caller must hold c.mutex for
+// writing.
+func (c *LRUCache[V]) liveLocked(key string) *entry[V] {
+	ent, ok := c.cache[key]
+	if !ok {
+		return nil
+	}
+	if c.expired(ent, time.Now()) {
+		c.expireLocked(ent)
+		return nil
+	}
+	return ent
+}
+
+// pinLocked pins ent, taking it out of the LFU heap. The caller must hold
+// c.mutex for writing.
+func (c *LRUCache[V]) pinLocked(ent *entry[V]) {
+	if ent.pinned {
+		return
+	}
+	c.lfuRemove(ent)
+	ent.pinned = true
+	c.pinned++
+}
+
+// unpinLocked unpins ent, putting it back in the LFU heap with the use count
+// it had. The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) unpinLocked(ent *entry[V]) {
+	ent.pinned = false
+	c.pinned--
+	if c.policy == PolicyLFU {
+		heap.Push(&c.lfu, ent)
+	}
+}
+
+// checkRoom returns ErrAllPinned if storing key would need an eviction that
+// pinned entries rule out under PinnedReject. Writes to a key already held,
+// even an expired one, replace it and need no room. The caller must hold
+// c.mutex.
+func (c *LRUCache[V]) checkRoom(key string) error {
+	if c.PinnedOverflow != PinnedReject || c.pinned == 0 || c.pinned < c.size || c.size < c.capacity {
+		return nil
+	}
+	if _, ok := c.cache[key]; ok {
+		return nil
+	}
+	return ErrAllPinned
+}
+
+// checkRoomOps returns ErrAllPinned, naming the op, if applying ops in order
+// would reach a set that checkRoom refuses, counting the room the deletes
+// before it free and the new keys before it take. Ops for keys that mine
+// reports false for, if it isn't nil, belong to another shard and are passed
+// over. The caller must hold c.mutex.
+func (c *LRUCache[V]) checkRoomOps(ops []Op[V], mine func(key string) bool) error {
+	if c.PinnedOverflow != PinnedReject || c.pinned == 0 {
+		return nil
+	}
+	const (
+		absent = iota
+		unpinned
+		pinned
+	)
+	size, pins := c.size, c.pinned
+	state := make(map[string]int)
+	for i, op := range ops {
+		if mine != nil && !mine(op.Key) {
+			continue
+		}
+		st, seen := state[op.Key]
+		if !seen {
+			if ent, ok := c.cache[op.Key]; !ok {
+				st = absent
+			} else if ent.pinned {
+				st = pinned
+			} else {
+				st = unpinned
+			}
+		}
+		switch {
+		case op.Kind == OpDelete && st != absent:
+			size--
+			if st == pinned {
+				pins--
+			}
+			st = absent
+		case op.Kind == OpSet && st == absent:
+			if pins > 0 && pins >= size && size >= c.capacity {
+				return fmt.Errorf("op %d: %w", i, ErrAllPinned)
+			}
+			// A full cache evicts an unpinned entry for the key instead.
+			if size < c.capacity {
+				size++
+			}
+			st = unpinned
+		}
+		state[op.Key] = st
+	}
+	return nil
+}
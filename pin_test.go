@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPinnedFull returns a cache of capacity 2 holding a and b, both pinned.
+func newPinnedFull(t *testing.T) *LRUCache[interface{}] {
+	t.Helper()
+	c := NewLRUCache[interface{}](2)
+	c.Set("a", json.RawMessage(`1`), 0)
+	c.Set("b", json.RawMessage(`2`), 0)
+	if !c.Pin("a") || !c.Pin("b") {
+		t.Fatal("Pin did not find the keys")
+	}
+	return c
+}
+
+func TestPinnedRejectSetMany(t *testing.T) {
+	c := newPinnedFull(t)
+	inserted, errs := c.SetMany([]BulkEntry[interface{}]{
+		{Key: "a", Value: json.RawMessage(`3`)},
+		{Key: "c", Value: json.RawMessage(`4`)},
+	})
+	if inserted[0] || inserted[1] {
+		t.Errorf("inserted = %v, want neither", inserted)
+	}
+	if errs == nil || errs[0] != nil || !errors.Is(errs[1], ErrAllPinned) {
+		t.Fatalf("errs = %v, want only c refused with ErrAllPinned", errs)
+	}
+	if c.Contains("c") {
+		t.Error("refused key c was stored")
+	}
+
+	s := NewShardedLRUCache[interface{}](4, 4)
+	for i := 0; i < 100; i++ {
+		s.Set("k"+strconv.Itoa(i), i, 0)
+	}
+	held := s.Keys()
+	for _, key := range held {
+		s.Pin(key)
+	}
+	_, errs = s.SetMany([]BulkEntry[interface{}]{{Key: "fresh"}, {Key: held[0]}})
+	if errs == nil || !errors.Is(errs[0], ErrAllPinned) || errs[1] != nil {
+		t.Errorf("sharded errs = %v, want only fresh refused with ErrAllPinned", errs)
+	}
+}
+
+// TestPinnedRejectCreates checks that the writes other than Set that create
+// keys refuse to when pinned entries fill the cache, and still work on the
+// keys it holds.
+func TestPinnedRejectCreates(t *testing.T) {
+	c := newPinnedFull(t)
+	if _, err := c.Increment("n", 1); !errors.Is(err, ErrAllPinned) {
+		t.Errorf("Increment: err = %v, want ErrAllPinned", err)
+	}
+	if _, err := c.Append("s", []byte("x")); !errors.Is(err, ErrAllPinned) {
+		t.Errorf("Append: err = %v, want ErrAllPinned", err)
+	}
+	if _, err := c.RPush("l", []interface{}{1}, KeepTTL); !errors.Is(err, ErrAllPinned) {
+		t.Errorf("RPush: err = %v, want ErrAllPinned", err)
+	}
+	if _, err := c.HSet("h", "f", 1, KeepTTL); !errors.Is(err, ErrAllPinned) {
+		t.Errorf("HSet: err = %v, want ErrAllPinned", err)
+	}
+	if _, err := c.GetOrLoad("g", func() (interface{}, time.Duration, error) {
+		return 1, 0, nil
+	}); !errors.Is(err, ErrAllPinned) {
+		t.Errorf("GetOrLoad: err = %v, want ErrAllPinned", err)
+	}
+	if err := c.Transaction([]Op[interface{}]{
+		{Kind: OpSet, Key: "a", Value: 5},
+		{Kind: OpSet, Key: "t", Value: 6},
+	}); !errors.Is(err, ErrAllPinned) {
+		t.Errorf("Transaction: err = %v, want ErrAllPinned", err)
+	}
+	if item, _ := c.PeekItem("a"); string(item.Value.(json.RawMessage)) != `1` {
+		t.Errorf("refused Transaction applied a = %v", item.Value)
+	}
+	for _, key := range []string{"n", "s", "l", "h", "g", "t"} {
+		if c.Contains(key) {
+			t.Errorf("refused key %q was stored", key)
+		}
+	}
+
+	if _, err := c.HSet("a", "f", 1, KeepTTL); !errors.Is(err, ErrNotHash) {
+		t.Errorf("HSet on a held key: err = %v, want ErrNotHash", err)
+	}
+	if err := c.Transaction([]Op[interface{}]{
+		{Kind: OpDelete, Key: "a"},
+		{Kind: OpSet, Key: "t", Value: 6},
+	}); err != nil {
+		t.Errorf("Transaction freeing room first: %v", err)
+	}
+	if !c.Contains("t") || !c.Contains("b") {
+		t.Errorf("after the Transaction, keys are %v, want t and b", c.Keys())
+	}
+}
+
+func TestBulkSetCacheFull(t *testing.T) {
+	c := newPinnedFull(t)
+	handler := cacheBulkSetHandler(c, 0, 0, KeyRules{})
+	post := func(body string) (int, map[string]bulkSetResult) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/cache/bulk", strings.NewReader(body)))
+		var results map[string]bulkSetResult
+		if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+			t.Fatalf("decoding %q: %v", rec.Body, err)
+		}
+		return rec.Code, results
+	}
+
+	code, results := post(`{"a": {"value": 3}, "c": {"value": 4}}`)
+	if code != http.StatusMultiStatus {
+		t.Errorf("status %d, want 207", code)
+	}
+	if results["a"].Status != "updated" {
+		t.Errorf("a: %+v, want updated", results["a"])
+	}
+	if r := results["c"]; r.Status != "error" || r.Code != codeCacheFull {
+		t.Errorf("c: %+v, want error with code %s", r, codeCacheFull)
+	}
+
+	if code, _ := post(`{"c": {"value": 4}}`); code != http.StatusInsufficientStorage {
+		t.Errorf("nothing stored: status %d, want 507", code)
+	}
+}
+
+func TestRestoreCacheFull(t *testing.T) {
+	c := newPinnedFull(t)
+	body := `{"key": "a", "value": 3, "ttl": 0}
+{"key": "c", "value": 4, "ttl": 0}
+`
+	rec := httptest.NewRecorder()
+	cacheRestoreHandler(c, 0, KeyRules{})(rec, httptest.NewRequest(http.MethodPost, "/cache/restore", strings.NewReader(body)))
+	var resp restoreResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	if want := (restoreResponse{Restored: 1, Skipped: 1, CacheFull: 1}); resp != want {
+		t.Errorf("response %+v, want %+v", resp, want)
+	}
+	if c.Contains("c") {
+		t.Error("refused key c was stored")
+	}
+}
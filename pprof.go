@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+)
+
+// registerPprof mounts Go's runtime profiling endpoints under /debug/pprof/
+// on r, for go tool pprof and go tool trace; /debug/pprof/ itself lists the
+// available profiles. They sit behind the router's middleware, so
+// -auth-token guards them. Still, a profile reveals a lot about the process
+// and a CPU profile or trace costs it CPU while it runs, so keep the
+// endpoints firewalled off from anyone but operators. CPU profiles and
+// traces must be shorter than -write-timeout, which pprof enforces.
+func registerPprof(r *mux.Router) {
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline).Methods("GET")
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile).Methods("GET")
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol).Methods("GET", "POST")
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace).Methods("GET")
+	// Index serves both the listing and each named profile, such as heap
+	// and goroutine.
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index).Methods("GET")
+}
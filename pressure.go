@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pressureSampleInterval is how often evictionPressure takes a sample of
+// the eviction counter, at most.
+const pressureSampleInterval = time.Second
+
+// evictionPressure derives the eviction rate, in evictions per second over
+// a sliding window, from a running eviction count. Samples are taken
+// lazily, by the calls that read the rate, at most once per
+// pressureSampleInterval, so an idle server does no work for it; after an
+// idle spell the first reading covers what samples remain in the window.
+type evictionPressure struct {
+	evictions func() uint64
+	window    time.Duration
+	threshold float64
+
+	mu      sync.Mutex
+	samples []pressureSample
+}
+
+type pressureSample struct {
+	at    time.Time
+	count uint64
+}
+
+func newEvictionPressure(evictions func() uint64, window time.Duration, threshold float64) *evictionPressure {
+	return &evictionPressure{evictions: evictions, window: window, threshold: threshold}
+}
+
+// rate returns the evictions per second over the window.
+func (p *evictionPressure) rate() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if n := len(p.samples); n == 0 || now.Sub(p.samples[n-1].at) >= pressureSampleInterval {
+		count := p.evictions()
+		if n > 0 && count < p.samples[n-1].count {
+			// The counters were reset, or a namespace went away with its
+			// counter; start over.
+			p.samples = p.samples[:0]
+		}
+		p.samples = append(p.samples, pressureSample{at: now, count: count})
+	}
+	drop := 0
+	for drop < len(p.samples)-1 && now.Sub(p.samples[drop].at) > p.window {
+		drop++
+	}
+	p.samples = append(p.samples[:0], p.samples[drop:]...)
+
+	if len(p.samples) < 2 {
+		return 0
+	}
+	first, last := p.samples[0], p.samples[len(p.samples)-1]
+	return float64(last.count-first.count) / last.at.Sub(first.at).Seconds()
+}
+
+// high reports whether the rate has reached the threshold, if one is set.
+func (p *evictionPressure) high() bool {
+	return p.threshold > 0 && p.rate() >= p.threshold
+}
+
+// pressureMetrics is the eviction pressure as reported by /metrics/json.
+type pressureMetrics struct {
+	EvictionsPerSecond float64 `json:"evictions_per_second"`
+	WindowSeconds      float64 `json:"window_seconds"`
+	High               bool    `json:"high"`
+}
+
+func (p *evictionPressure) metrics() pressureMetrics {
+	rate := p.rate()
+	return pressureMetrics{
+		EvictionsPerSecond: rate,
+		WindowSeconds:      p.window.Seconds(),
+		High:               p.threshold > 0 && rate >= p.threshold,
+	}
+}
+
+// Middleware sets X-Cache-Pressure: high on responses to writes, that is
+// anything but GET, HEAD and OPTIONS, while the eviction rate is at or over
+// the threshold, so that clients can slow down. It never rejects a request.
+func (p *evictionPressure) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if p.high() {
+				w.Header().Set("X-Cache-Pressure", "high")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
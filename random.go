@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Random returns a live entry chosen uniformly at random, reporting false if
+// the cache holds none. Like Peek, it neither refreshes the entry's recency
+// nor counts as a hit or miss. Choosing fairly means looking at every entry,
+// so it is O(n) in the size of the cache, under the read lock.
+func (c *LRUCache[V]) Random() (string, V, bool) {
+	key, value, live := c.sample()
+	slog.Debug("cache", "op", "random", "found", live > 0)
+	return key, value, live > 0
+}
+
+// sample picks a live entry uniformly by reservoir sampling over the map and
+// returns it along with how many live entries there were to pick from.
+func (c *LRUCache[V]) sample() (key string, value V, live int) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	now := time.Now()
+	for _, ent := range c.cache {
+		if c.expired(ent, now) {
+			continue
+		}
+		live++
+		if rand.Intn(live) == 0 {
+			key, value = ent.key, ent.value
+		}
+	}
+	return key, value, live
+}
+
+// Random returns a live entry chosen uniformly across all shards: each
+// shard's pick is kept with probability proportional to how many live
+// entries it was picked from.
+func (s *ShardedLRUCache[V]) Random() (string, V, bool) {
+	var key string
+	var value V
+	total := 0
+	for _, shard := range s.shards {
+		k, v, live := shard.sample()
+		if live == 0 {
+			continue
+		}
+		total += live
+		if rand.Intn(total) < live {
+			key, value = k, v
+		}
+	}
+	slog.Debug("cache", "op", "random", "found", total > 0)
+	return key, value, total > 0
+}
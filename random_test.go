@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// chiSquared returns Pearson's statistic for observed counts against an
+// even spread of total over len(counts) buckets.
+func chiSquared(counts map[string]int, buckets, total int) float64 {
+	expected := float64(total) / float64(buckets)
+	stat := 0.0
+	seen := 0
+	for _, n := range counts {
+		d := float64(n) - expected
+		stat += d * d / expected
+		seen++
+	}
+	// Buckets never hit contribute their whole expectation.
+	stat += float64(buckets-seen) * expected
+	return stat
+}
+
+// TestRandomUniform samples many random keys and checks, with a
+// chi-squared test, that every live key is equally likely to come up. A
+// statistic above the critical value would happen by chance once in a
+// thousand runs.
+func TestRandomUniform(t *testing.T) {
+	const keys, samples = 20, 40000
+	// The chi-squared critical value for 19 degrees of freedom at p = 0.001.
+	const critical = 43.82
+
+	caches := map[string]Cache[int]{
+		"lru": NewLRUCache[int](keys),
+		// Room to spare, so that no shard evicts however the keys fall.
+		"sharded": NewShardedLRUCache[int](keys*4, 4),
+	}
+	for name, c := range caches {
+		for i := 0; i < keys; i++ {
+			c.Set("key"+strconv.Itoa(i), i, 0)
+		}
+		counts := make(map[string]int, keys)
+		for i := 0; i < samples; i++ {
+			key, _, ok := c.Random()
+			if !ok {
+				t.Fatalf("%s: Random found nothing in a full cache", name)
+			}
+			counts[key]++
+		}
+		if stat := chiSquared(counts, keys, samples); stat > critical {
+			t.Errorf("%s: chi-squared %.2f over %d keys exceeds %.2f; counts %v", name, stat, keys, critical, counts)
+		}
+	}
+}
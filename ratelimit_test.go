@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := newRateLimiter(2, 3, false)
+	now := time.Now()
+	take := func(client string, want bool, wantWait time.Duration) {
+		t.Helper()
+		ok, wait := l.allow(client, now)
+		if ok != want || wait != wantWait {
+			t.Errorf("allow(%s) = %v, %v; want %v, %v", client, ok, wait, want, wantWait)
+		}
+	}
+
+	// The burst is available at once, and then nothing until a refill.
+	take("a", true, 0)
+	take("a", true, 0)
+	take("a", true, 0)
+	take("a", false, 500*time.Millisecond)
+	// Other clients have buckets of their own.
+	take("b", true, 0)
+
+	// At 2 a second, a quarter of a second refills half a token.
+	now = now.Add(250 * time.Millisecond)
+	take("a", false, 250*time.Millisecond)
+	now = now.Add(250 * time.Millisecond)
+	take("a", true, 0)
+	take("a", false, 500*time.Millisecond)
+
+	// An idle bucket fills up to the burst and no further.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		take("a", true, 0)
+	}
+	take("a", false, 500*time.Millisecond)
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	l := newRateLimiter(0.1, 1, true)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	get := func(forwarded string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/cache/a", nil)
+		req.Header.Set("X-Forwarded-For", forwarded)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("10.0.0.1"); rec.Code != http.StatusNoContent {
+		t.Fatalf("first request: status %d", rec.Code)
+	}
+	rec := get("10.0.0.1, 10.0.0.9")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", rec.Code)
+	}
+	// A token takes 10s at 0.1 a second; the wait is rounded up to whole
+	// seconds.
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Retry-After %q, want 10", got)
+	}
+	if rec := get("10.0.0.2"); rec.Code != http.StatusNoContent {
+		t.Errorf("another client: status %d", rec.Code)
+	}
+}
+
+func TestRateLimiterClientIP(t *testing.T) {
+	tests := []struct {
+		trustProxy bool
+		remote     string
+		forwarded  string
+		want       string
+	}{
+		{false, "192.0.2.1:1234", "10.0.0.1", "192.0.2.1"},
+		{true, "192.0.2.1:1234", " 10.0.0.1 , 10.0.0.9", "10.0.0.1"},
+		{true, "192.0.2.1:1234", "", "192.0.2.1"},
+		{false, "[2001:db8::1]:80", "", "2001:db8::1"},
+		{false, "pipe", "", "pipe"},
+	}
+	for _, tt := range tests {
+		l := newRateLimiter(1, 1, tt.trustProxy)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := l.clientIP(req); got != tt.want {
+			t.Errorf("trustProxy %v, %s, %q: got %s, want %s", tt.trustProxy, tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+}
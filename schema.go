@@ -0,0 +1,767 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// schemaMaxErrors is how many violations a validation reports at most.
+const schemaMaxErrors = 20
+
+// schemaMaxDepth bounds how deeply validation nests. A schema whose $refs
+// loop without descending into the value is rejected when it is loaded, so
+// only a recursive schema applied to a deeply nested value reaches it.
+const schemaMaxDepth = 256
+
+// schemaMaxSteps bounds how many subschemas one validation applies in all.
+// Depth alone doesn't bound the work: a schema whose anyOf branches each
+// recurse into the value takes exponential time on a deep enough one.
+const schemaMaxSteps = 100000
+
+// jsonSchema validates values against a JSON Schema, as loaded by
+// loadJSONSchema. It implements the validation keywords common to drafts 6
+// to 2020-12 that need no network access:
+//
+//   - type, enum and const;
+//   - minimum, maximum, exclusiveMinimum, exclusiveMaximum and multipleOf,
+//     compared exactly rather than as float64;
+//   - minLength, maxLength and pattern, which is RE2 syntax;
+//   - items (a schema, or an array of them followed by additionalItems),
+//     prefixItems, minItems, maxItems, uniqueItems and contains;
+//   - properties, patternProperties, additionalProperties, required,
+//     propertyNames, minProperties and maxProperties;
+//   - allOf, anyOf, oneOf, not and if/then/else;
+//   - $ref to a JSON pointer within the same document, such as
+//     "#/$defs/user".
+//
+// Annotations such as title, description, default and format are accepted
+// and ignored. Any other keyword is rejected when the schema is loaded, so a
+// schema never silently checks less than its author meant.
+type jsonSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+	// refs and loops are only used while the schema is checked: refs
+	// holds the $refs whose targets have been checked, loops the state of
+	// each object schema in the search for $ref cycles.
+	refs  map[string]bool
+	loops map[uintptr]int
+}
+
+// schemaAnnotations are keywords that don't constrain the value.
+var schemaAnnotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "$anchor": true,
+	"title": true, "description": true, "default": true, "examples": true,
+	"deprecated": true, "readOnly": true, "writeOnly": true, "format": true,
+	"contentMediaType": true, "contentEncoding": true,
+}
+
+// loadJSONSchema reads and checks the schema in the file at path.
+func loadJSONSchema(path string) (*jsonSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseJSONSchema(data)
+}
+
+// parseJSONSchema decodes and checks the schema in data.
+func parseJSONSchema(data []byte) (*jsonSchema, error) {
+	var root interface{}
+	if err := decodeJSON(data, &root); err != nil {
+		return nil, err
+	}
+	s := &jsonSchema{
+		root:     root,
+		patterns: make(map[string]*regexp.Regexp),
+		refs:     make(map[string]bool),
+		loops:    make(map[uintptr]int),
+	}
+	if err := s.check(root, "#"); err != nil {
+		return nil, err
+	}
+	s.refs, s.loops = nil, nil
+	return s, nil
+}
+
+// check walks a (sub)schema at pointer ptr, rejecting unknown keywords,
+// malformed values and $ref cycles, and compiling patterns.
+func (s *jsonSchema) check(schema interface{}, ptr string) error {
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+	m, ok := schema.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: schema must be an object or a boolean", ptr)
+	}
+	for kw, v := range m {
+		if kw != "$ref" {
+			continue
+		}
+		// Check the target before the search for cycles resolves it.
+		ref, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s/$ref: must be a string", ptr)
+		}
+		target, err := s.resolve(ref)
+		if err != nil {
+			return fmt.Errorf("%s/$ref: %v", ptr, err)
+		}
+		if !s.refs[ref] {
+			s.refs[ref] = true
+			if err := s.check(target, ref); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.checkLoops(m, ptr); err != nil {
+		return err
+	}
+	for kw, v := range m {
+		at := ptr + "/" + kw
+		var err error
+		switch kw {
+		case "type":
+			err = checkSchemaTypes(v)
+		case "enum":
+			if _, ok := v.([]interface{}); !ok {
+				err = errors.New("must be an array")
+			}
+		case "const":
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+			var r *big.Rat
+			if r, ok = jsonRat(v); !ok {
+				err = errors.New("must be a number")
+			} else if kw == "multipleOf" && r.Sign() <= 0 {
+				err = errors.New("must be greater than 0")
+			}
+		case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			if _, ok := jsonCount(v); !ok {
+				err = errors.New("must be a non-negative integer")
+			}
+		case "uniqueItems":
+			if _, ok := v.(bool); !ok {
+				err = errors.New("must be a boolean")
+			}
+		case "pattern":
+			err = s.compile(v)
+		case "required":
+			err = checkStrings(v)
+		case "$ref":
+			// Checked above.
+		case "items":
+			if list, ok := v.([]interface{}); ok {
+				err = s.checkAll(list, at)
+			} else {
+				err = s.check(v, at)
+			}
+			if err != nil {
+				return err
+			}
+		case "additionalItems", "contains", "additionalProperties", "propertyNames", "not", "if", "then", "else":
+			if err := s.check(v, at); err != nil {
+				return err
+			}
+		case "prefixItems", "allOf", "anyOf", "oneOf":
+			list, ok := v.([]interface{})
+			if !ok || len(list) == 0 {
+				err = errors.New("must be a non-empty array")
+			} else if err := s.checkAll(list, at); err != nil {
+				return err
+			}
+		case "properties", "$defs", "definitions":
+			if err := s.checkMap(v, at, false); err != nil {
+				return err
+			}
+		case "patternProperties":
+			if err := s.checkMap(v, at, true); err != nil {
+				return err
+			}
+		default:
+			if !schemaAnnotations[kw] {
+				err = errors.New("unsupported keyword")
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", at, err)
+		}
+	}
+	return nil
+}
+
+// checkLoops returns an error if m, through $ref and the keywords that
+// apply subschemas to the same value, such as allOf and not, can reach
+// itself again without descending into the value: validation would never
+// end. It must run after every $ref it can reach has been checked.
+func (s *jsonSchema) checkLoops(m map[string]interface{}, ptr string) error {
+	const (
+		searching = 1
+		done      = 2
+	)
+	id := reflect.ValueOf(m).Pointer()
+	switch s.loops[id] {
+	case searching:
+		return fmt.Errorf("%s: $ref cycle that never descends into the value", ptr)
+	case done:
+		return nil
+	}
+	s.loops[id] = searching
+	for _, sub := range s.inPlace(m, ptr) {
+		if sm, ok := sub.schema.(map[string]interface{}); ok {
+			if err := s.checkLoops(sm, sub.ptr); err != nil {
+				return err
+			}
+		}
+	}
+	s.loops[id] = done
+	return nil
+}
+
+// pointedSchema is a subschema along with its JSON pointer.
+type pointedSchema struct {
+	schema interface{}
+	ptr    string
+}
+
+// inPlace returns the subschemas of m, at pointer ptr, that validation
+// applies to the same value as m itself.
+func (s *jsonSchema) inPlace(m map[string]interface{}, ptr string) []pointedSchema {
+	var subs []pointedSchema
+	if ref, ok := m["$ref"].(string); ok {
+		if target, err := s.resolve(ref); err == nil {
+			subs = append(subs, pointedSchema{target, ref})
+		}
+	}
+	for _, kw := range []string{"allOf", "anyOf", "oneOf"} {
+		list, _ := m[kw].([]interface{})
+		for i, sub := range list {
+			subs = append(subs, pointedSchema{sub, ptr + "/" + kw + "/" + strconv.Itoa(i)})
+		}
+	}
+	for _, kw := range []string{"not", "if", "then", "else"} {
+		if sub, ok := m[kw]; ok {
+			subs = append(subs, pointedSchema{sub, ptr + "/" + kw})
+		}
+	}
+	return subs
+}
+
+func (s *jsonSchema) checkAll(list []interface{}, ptr string) error {
+	for i, sub := range list {
+		if err := s.check(sub, ptr+"/"+strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMap checks an object of named subschemas, whose names are patterns
+// with patterns set.
+func (s *jsonSchema) checkMap(v interface{}, ptr string, patterns bool) error {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: must be an object", ptr)
+	}
+	for name, sub := range m {
+		if patterns {
+			if err := s.compile(name); err != nil {
+				return fmt.Errorf("%s: %v", ptr, err)
+			}
+		}
+		if err := s.check(sub, ptr+"/"+escapePointer(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) compile(v interface{}) error {
+	pattern, ok := v.(string)
+	if !ok {
+		return errors.New("pattern must be a string")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	s.patterns[pattern] = re
+	return nil
+}
+
+func checkSchemaTypes(v interface{}) error {
+	names, ok := v.([]interface{})
+	if !ok {
+		names = []interface{}{v}
+	}
+	for _, name := range names {
+		switch name {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("unknown type %v", name)
+		}
+	}
+	return nil
+}
+
+func checkStrings(v interface{}) error {
+	list, ok := v.([]interface{})
+	if !ok {
+		return errors.New("must be an array of strings")
+	}
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return errors.New("must be an array of strings")
+		}
+	}
+	return nil
+}
+
+// resolve returns the subschema a local $ref points to.
+func (s *jsonSchema) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q: only refs within the schema, starting with #, are supported", ref)
+	}
+	node := s.root
+	if ref == "#" {
+		return node, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q: must be a JSON pointer", ref)
+	}
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch n := node.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if node, ok = n[token]; !ok {
+				return nil, fmt.Errorf("$ref %q not found", ref)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("$ref %q not found", ref)
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("$ref %q not found", ref)
+		}
+	}
+	return node, nil
+}
+
+// Validate checks raw, a JSON document, against the schema and returns the
+// violations found, each prefixed with the JSON pointer of the offending
+// part of the value. It returns nil if raw conforms.
+func (s *jsonSchema) Validate(raw []byte) []string {
+	var value interface{}
+	if err := decodeJSON(raw, &value); err != nil {
+		return []string{"value is not valid JSON: " + err.Error()}
+	}
+	v := schemaValidation{schema: s, steps: new(int)}
+	v.validate(s.root, value, "", 0)
+	if *v.steps > schemaMaxSteps {
+		// The outcome of whatever was cut short can't be trusted, in
+		// either direction.
+		return []string{fmt.Sprintf("/: value takes more than %d steps to validate", schemaMaxSteps)}
+	}
+	return v.errors
+}
+
+// schemaValidation collects the violations of one validation.
+type schemaValidation struct {
+	schema *jsonSchema
+	errors []string
+	// steps counts the subschemas applied so far, shared with the
+	// validations passes runs.
+	steps *int
+}
+
+func (v *schemaValidation) fail(ptr, format string, args ...interface{}) {
+	if len(v.errors) < schemaMaxErrors {
+		if ptr == "" {
+			ptr = "/"
+		}
+		v.errors = append(v.errors, ptr+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// passes reports whether value conforms to schema, without recording why
+// not.
+func (v *schemaValidation) passes(schema, value interface{}, ptr string, depth int) bool {
+	sub := schemaValidation{schema: v.schema, steps: v.steps}
+	sub.validate(schema, value, ptr, depth)
+	return len(sub.errors) == 0
+}
+
+func (v *schemaValidation) validate(schema, value interface{}, ptr string, depth int) {
+	if depth > schemaMaxDepth {
+		v.fail(ptr, "schema nests too deeply")
+		return
+	}
+	depth++
+	if *v.steps++; *v.steps > schemaMaxSteps {
+		v.fail(ptr, "validation takes too many steps")
+		return
+	}
+	if allowed, ok := schema.(bool); ok {
+		if !allowed {
+			v.fail(ptr, "no value is allowed here")
+		}
+		return
+	}
+	m, ok := schema.(map[string]interface{})
+	if !ok {
+		v.fail(ptr, "schema is not an object or a boolean")
+		return
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		if target, err := v.schema.resolve(ref); err == nil {
+			v.validate(target, value, ptr, depth)
+		}
+	}
+	if t, ok := m["type"]; ok && !matchesType(t, value) {
+		v.fail(ptr, "expected type %s, got %s", describeTypes(t), jsonTypeName(value))
+		return
+	}
+	if enum, ok := m["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if jsonEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(ptr, "value is not one of the allowed values")
+		}
+	}
+	if c, ok := m["const"]; ok && !jsonEqual(c, value) {
+		v.fail(ptr, "value must be %s", compactJSON(c))
+	}
+
+	switch val := value.(type) {
+	case json.Number:
+		v.validateNumber(m, val, ptr)
+	case string:
+		v.validateString(m, val, ptr)
+	case []interface{}:
+		v.validateArray(m, val, ptr, depth)
+	case map[string]interface{}:
+		v.validateObject(m, val, ptr, depth)
+	}
+
+	if all, ok := m["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			v.validate(sub, value, ptr, depth)
+		}
+	}
+	if any, ok := m["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range any {
+			if v.passes(sub, value, ptr, depth) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(ptr, "value matches none of anyOf")
+		}
+	}
+	if one, ok := m["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range one {
+			if v.passes(sub, value, ptr, depth) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			v.fail(ptr, "value matches %d of oneOf, not exactly 1", matched)
+		}
+	}
+	if not, ok := m["not"]; ok && v.passes(not, value, ptr, depth) {
+		v.fail(ptr, "value must not match the schema in not")
+	}
+	if cond, ok := m["if"]; ok {
+		if v.passes(cond, value, ptr, depth) {
+			if then, ok := m["then"]; ok {
+				v.validate(then, value, ptr, depth)
+			}
+		} else if els, ok := m["else"]; ok {
+			v.validate(els, value, ptr, depth)
+		}
+	}
+}
+
+func (v *schemaValidation) validateNumber(m map[string]interface{}, n json.Number, ptr string) {
+	r, ok := jsonRat(n)
+	if !ok {
+		return
+	}
+	bound := func(kw string) (*big.Rat, bool) {
+		b, ok := m[kw]
+		if !ok {
+			return nil, false
+		}
+		return jsonRat(b)
+	}
+	if b, ok := bound("minimum"); ok && r.Cmp(b) < 0 {
+		v.fail(ptr, "%s is less than the minimum %s", n, b.RatString())
+	}
+	if b, ok := bound("maximum"); ok && r.Cmp(b) > 0 {
+		v.fail(ptr, "%s is greater than the maximum %s", n, b.RatString())
+	}
+	if b, ok := bound("exclusiveMinimum"); ok && r.Cmp(b) <= 0 {
+		v.fail(ptr, "%s must be greater than %s", n, b.RatString())
+	}
+	if b, ok := bound("exclusiveMaximum"); ok && r.Cmp(b) >= 0 {
+		v.fail(ptr, "%s must be less than %s", n, b.RatString())
+	}
+	if b, ok := bound("multipleOf"); ok && !new(big.Rat).Quo(r, b).IsInt() {
+		v.fail(ptr, "%s is not a multiple of %s", n, b.RatString())
+	}
+}
+
+func (v *schemaValidation) validateString(m map[string]interface{}, s string, ptr string) {
+	length := utf8.RuneCountInString(s)
+	if n, ok := jsonCount(m["minLength"]); ok && length < n {
+		v.fail(ptr, "string is shorter than %d characters", n)
+	}
+	if n, ok := jsonCount(m["maxLength"]); ok && length > n {
+		v.fail(ptr, "string is longer than %d characters", n)
+	}
+	if pattern, ok := m["pattern"].(string); ok && !v.schema.patterns[pattern].MatchString(s) {
+		v.fail(ptr, "string does not match pattern %q", pattern)
+	}
+}
+
+func (v *schemaValidation) validateArray(m map[string]interface{}, a []interface{}, ptr string, depth int) {
+	if n, ok := jsonCount(m["minItems"]); ok && len(a) < n {
+		v.fail(ptr, "array has fewer than %d items", n)
+	}
+	if n, ok := jsonCount(m["maxItems"]); ok && len(a) > n {
+		v.fail(ptr, "array has more than %d items", n)
+	}
+	if unique, _ := m["uniqueItems"].(bool); unique {
+	outer:
+		for i := range a {
+			for j := i + 1; j < len(a); j++ {
+				if jsonEqual(a[i], a[j]) {
+					v.fail(ptr, "items %d and %d are equal", i, j)
+					break outer
+				}
+			}
+		}
+	}
+
+	// prefixItems (2020-12) and an array of items (earlier drafts) both
+	// constrain the leading items; what follows is items or
+	// additionalItems respectively.
+	prefix, _ := m["prefixItems"].([]interface{})
+	rest, hasRest := m["items"]
+	if tuple, ok := rest.([]interface{}); ok {
+		prefix = tuple
+		rest, hasRest = m["additionalItems"]
+	}
+	for i, item := range a {
+		at := ptr + "/" + strconv.Itoa(i)
+		if i < len(prefix) {
+			v.validate(prefix[i], item, at, depth)
+		} else if hasRest {
+			v.validate(rest, item, at, depth)
+		}
+	}
+
+	if contains, ok := m["contains"]; ok {
+		found := false
+		for i, item := range a {
+			if v.passes(contains, item, ptr+"/"+strconv.Itoa(i), depth) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(ptr, "array contains no item matching contains")
+		}
+	}
+}
+
+func (v *schemaValidation) validateObject(m map[string]interface{}, o map[string]interface{}, ptr string, depth int) {
+	if n, ok := jsonCount(m["minProperties"]); ok && len(o) < n {
+		v.fail(ptr, "object has fewer than %d properties", n)
+	}
+	if n, ok := jsonCount(m["maxProperties"]); ok && len(o) > n {
+		v.fail(ptr, "object has more than %d properties", n)
+	}
+	if required, ok := m["required"].([]interface{}); ok {
+		for _, name := range required {
+			if _, ok := o[name.(string)]; !ok {
+				v.fail(ptr, "missing required property %q", name)
+			}
+		}
+	}
+
+	// Go through the properties in order so that errors come out the same
+	// way every time.
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	properties, _ := m["properties"].(map[string]interface{})
+	patterns, _ := m["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := m["additionalProperties"]
+	nameSchema, hasNameSchema := m["propertyNames"]
+	for _, name := range names {
+		at := ptr + "/" + escapePointer(name)
+		if hasNameSchema && !v.passes(nameSchema, name, at, depth) {
+			v.fail(at, "property name does not match propertyNames")
+		}
+		matched := false
+		if sub, ok := properties[name]; ok {
+			v.validate(sub, o[name], at, depth)
+			matched = true
+		}
+		for pattern, sub := range patterns {
+			if v.schema.patterns[pattern].MatchString(name) {
+				v.validate(sub, o[name], at, depth)
+				matched = true
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				v.fail(at, "property is not allowed")
+			} else {
+				v.validate(additional, o[name], at, depth)
+			}
+		}
+	}
+}
+
+// matchesType reports whether value is of the type, or one of the types,
+// in t.
+func matchesType(t, value interface{}) bool {
+	names, ok := t.([]interface{})
+	if !ok {
+		names = []interface{}{t}
+	}
+	actual := jsonTypeName(value)
+	for _, name := range names {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func describeTypes(t interface{}) string {
+	names, ok := t.([]interface{})
+	if !ok {
+		return fmt.Sprint(t)
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprint(name)
+	}
+	return strings.Join(parts, " or ")
+}
+
+// jsonTypeName returns the JSON Schema type of a decoded value; a number
+// with no fractional part, such as 1.0, is an integer.
+func jsonTypeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if r, ok := jsonRat(v); ok && r.IsInt() {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonRat returns a decoded JSON number as an exact rational.
+func jsonRat(v interface{}) (*big.Rat, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, false
+	}
+	return new(big.Rat).SetString(string(n))
+}
+
+// jsonCount returns v as a non-negative integer, as used by minLength and
+// the like.
+func jsonCount(v interface{}) (int, bool) {
+	r, ok := jsonRat(v)
+	if !ok || !r.IsInt() || r.Sign() < 0 || !r.Num().IsInt64() {
+		return 0, false
+	}
+	return int(r.Num().Int64()), true
+}
+
+// jsonEqual compares decoded JSON values, numbers by value, so 1 equals
+// 1.0.
+func jsonEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		rx, okx := jsonRat(x)
+		ry, oky := jsonRat(y)
+		return okx && oky && rx.Cmp(ry) == 0
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, xv := range x {
+			yv, ok := y[k]
+			if !ok || !jsonEqual(xv, yv) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func compactJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// escapePointer escapes a property name for use in a JSON pointer.
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func mustParseSchema(t *testing.T, schema string) *jsonSchema {
+	t.Helper()
+	s, err := parseJSONSchema([]byte(schema))
+	if err != nil {
+		t.Fatalf("parsing %s: %v", schema, err)
+	}
+	return s
+}
+
+func TestSchemaKeywords(t *testing.T) {
+	tests := []struct {
+		schema string
+		value  string
+		ok     bool
+	}{
+		{`true`, `1`, true},
+		{`false`, `1`, false},
+		{`{"type": "string"}`, `"a"`, true},
+		{`{"type": "string"}`, `1`, false},
+		{`{"type": "integer"}`, `1.0`, true},
+		{`{"type": "integer"}`, `1.5`, false},
+		{`{"type": "number"}`, `1`, true},
+		{`{"type": ["null", "boolean"]}`, `null`, true},
+		{`{"type": ["null", "boolean"]}`, `{}`, false},
+		{`{"enum": [1, "a"]}`, `1.0`, true},
+		{`{"enum": [1, "a"]}`, `"b"`, false},
+		{`{"const": {"a": [1]}}`, `{"a": [1]}`, true},
+		{`{"const": {"a": [1]}}`, `{"a": [2]}`, false},
+		{`{"minimum": 1}`, `1`, true},
+		{`{"minimum": 1}`, `0.999`, false},
+		{`{"maximum": 1}`, `1.001`, false},
+		{`{"exclusiveMinimum": 1}`, `1`, false},
+		{`{"exclusiveMaximum": 1}`, `0.5`, true},
+		{`{"multipleOf": 0.1}`, `0.3`, true},
+		{`{"multipleOf": 0.1}`, `0.35`, false},
+		{`{"minimum": 9007199254740993}`, `9007199254740992`, false},
+		{`{"minLength": 2}`, `"é"`, false},
+		{`{"maxLength": 1}`, `"é"`, true},
+		{`{"pattern": "^a+$"}`, `"aaa"`, true},
+		{`{"pattern": "^a+$"}`, `"ab"`, false},
+		{`{"pattern": "^a+$"}`, `1`, true},
+		{`{"items": {"type": "integer"}}`, `[1, 2]`, true},
+		{`{"items": {"type": "integer"}}`, `[1, "2"]`, false},
+		{`{"items": [{"type": "integer"}], "additionalItems": false}`, `[1]`, true},
+		{`{"items": [{"type": "integer"}], "additionalItems": false}`, `[1, 2]`, false},
+		{`{"prefixItems": [{"type": "string"}], "items": {"type": "integer"}}`, `["a", 1]`, true},
+		{`{"prefixItems": [{"type": "string"}], "items": {"type": "integer"}}`, `[1, 1]`, false},
+		{`{"minItems": 1}`, `[]`, false},
+		{`{"maxItems": 1}`, `[1, 2]`, false},
+		{`{"uniqueItems": true}`, `[1, 1.0]`, false},
+		{`{"uniqueItems": true}`, `[1, "1"]`, true},
+		{`{"contains": {"const": 2}}`, `[1, 2]`, true},
+		{`{"contains": {"const": 2}}`, `[1, 3]`, false},
+		{`{"properties": {"a": {"type": "string"}}}`, `{"a": "x", "b": 1}`, true},
+		{`{"properties": {"a": {"type": "string"}}}`, `{"a": 1}`, false},
+		{`{"patternProperties": {"^n_": {"type": "number"}}}`, `{"n_a": "x"}`, false},
+		{`{"properties": {"a": true}, "additionalProperties": false}`, `{"a": 1}`, true},
+		{`{"properties": {"a": true}, "additionalProperties": false}`, `{"b": 1}`, false},
+		{`{"additionalProperties": {"type": "integer"}}`, `{"b": "x"}`, false},
+		{`{"required": ["a"]}`, `{"a": null}`, true},
+		{`{"required": ["a"]}`, `{}`, false},
+		{`{"propertyNames": {"maxLength": 2}}`, `{"abc": 1}`, false},
+		{`{"minProperties": 1}`, `{}`, false},
+		{`{"maxProperties": 1}`, `{"a": 1, "b": 2}`, false},
+		{`{"allOf": [{"minimum": 1}, {"maximum": 2}]}`, `3`, false},
+		{`{"anyOf": [{"type": "string"}, {"minimum": 1}]}`, `2`, true},
+		{`{"anyOf": [{"type": "string"}, {"minimum": 1}]}`, `0`, false},
+		{`{"oneOf": [{"minimum": 1}, {"maximum": 2}]}`, `0`, true},
+		{`{"oneOf": [{"minimum": 1}, {"maximum": 2}]}`, `1.5`, false},
+		{`{"not": {"type": "string"}}`, `"a"`, false},
+		{`{"if": {"type": "string"}, "then": {"minLength": 2}, "else": {"minimum": 0}}`, `"a"`, false},
+		{`{"if": {"type": "string"}, "then": {"minLength": 2}, "else": {"minimum": 0}}`, `-1`, false},
+		{`{"if": {"type": "string"}, "then": {"minLength": 2}, "else": {"minimum": 0}}`, `1`, true},
+		{`{"$defs": {"pos": {"minimum": 0}}, "$ref": "#/$defs/pos"}`, `-1`, false},
+		{`{"definitions": {"a/b": {"type": "string"}}, "items": {"$ref": "#/definitions/a~1b"}}`, `["x", 1]`, false},
+		{`{"anyOf": [{"type": "integer"}, {"type": "array", "items": {"$ref": "#"}}]}`, `[1, [2, [3]]]`, true},
+		{`{"anyOf": [{"type": "integer"}, {"type": "array", "items": {"$ref": "#"}}]}`, `[1, [2, ["x"]]]`, false},
+		{`{"title": "t", "description": "d", "format": "email", "default": 1}`, `"x"`, true},
+	}
+	for _, tt := range tests {
+		violations := mustParseSchema(t, tt.schema).Validate([]byte(tt.value))
+		if ok := len(violations) == 0; ok != tt.ok {
+			t.Errorf("schema %s, value %s: violations %q, want ok = %v", tt.schema, tt.value, violations, tt.ok)
+		}
+	}
+}
+
+func TestSchemaRejected(t *testing.T) {
+	tests := []struct {
+		schema string
+		want   string
+	}{
+		{`[]`, "#: schema must be an object or a boolean"},
+		{`{"type": "date"}`, "#/type: unknown type date"},
+		{`{"enum": 1}`, "#/enum: must be an array"},
+		{`{"minimum": "1"}`, "#/minimum: must be a number"},
+		{`{"multipleOf": 0}`, "#/multipleOf: must be greater than 0"},
+		{`{"minLength": -1}`, "#/minLength: must be a non-negative integer"},
+		{`{"maxItems": 1.5}`, "#/maxItems: must be a non-negative integer"},
+		{`{"uniqueItems": 1}`, "#/uniqueItems: must be a boolean"},
+		{`{"pattern": "("}`, "#/pattern: error parsing regexp"},
+		{`{"patternProperties": {"(": true}}`, "#/patternProperties: error parsing regexp"},
+		{`{"required": [1]}`, "#/required: must be an array of strings"},
+		{`{"anyOf": []}`, "#/anyOf: must be a non-empty array"},
+		{`{"properties": {"a": 1}}`, "#/properties/a: schema must be an object or a boolean"},
+		{`{"items": {"format": "x", "minimum": "a"}}`, "#/items/minimum: must be a number"},
+		{`{"dependentRequired": {}}`, "#/dependentRequired: unsupported keyword"},
+		{`{"$ref": 1}`, "#/$ref: must be a string"},
+		{`{"$ref": "other.json#/a"}`, `unsupported $ref "other.json#/a"`},
+		{`{"$ref": "#a"}`, "must be a JSON pointer"},
+		{`{"$ref": "#/$defs/missing"}`, `$ref "#/$defs/missing" not found`},
+		{`{"required": ["a"], "$ref": "#/required"}`, "#/required: schema must be an object or a boolean"},
+		{`{"$defs": {"a": {"$ref": "#/$defs/a/not/0"}}, "items": {"$ref": "#/$defs/a"}}`, "not found"},
+		{`{"$ref": "#"}`, "$ref cycle"},
+		{`{"anyOf": [{"$ref": "#"}, {"$ref": "#"}]}`, "$ref cycle"},
+		{`{"$defs": {"a": {"allOf": [{"$ref": "#/$defs/b"}]}, "b": {"not": {"$ref": "#/$defs/a"}}}, "$ref": "#/$defs/a"}`, "$ref cycle"},
+		{`{"$defs": {"a": {"if": {"$ref": "#/$defs/a"}}}, "properties": {"x": {"$ref": "#/$defs/a"}}}`, "$ref cycle"},
+	}
+	for _, tt := range tests {
+		_, err := parseJSONSchema([]byte(tt.schema))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("schema %s: err = %v, want one containing %q", tt.schema, err, tt.want)
+		}
+	}
+}
+
+// TestSchemaStepLimit validates a value against a schema whose two anyOf
+// branches both recurse into it and both fail, which without a limit would
+// take 2^depth steps.
+func TestSchemaStepLimit(t *testing.T) {
+	s := mustParseSchema(t, `{"anyOf": [
+		{"minItems": 2, "items": {"$ref": "#"}},
+		{"maxItems": 0, "items": {"$ref": "#"}}
+	]}`)
+	value := strings.Repeat("[", 40) + strings.Repeat("]", 40)
+
+	done := make(chan []string, 1)
+	go func() { done <- s.Validate([]byte(value)) }()
+	select {
+	case violations := <-done:
+		if len(violations) != 1 || !strings.Contains(violations[0], "steps to validate") {
+			t.Errorf("violations %q, want only the step limit", violations)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("validation did not finish")
+	}
+}
+
+func TestCacheSetSchemaViolation(t *testing.T) {
+	schema := mustParseSchema(t, `{
+		"type": "object",
+		"required": ["name"],
+		"properties": {"age": {"type": "integer", "minimum": 0}}
+	}`)
+	cache := NewLRUCache[interface{}](10)
+	handler := cacheSetHandler(cache, 0, 0, schema, nil)
+	put := func(body, contentType string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/cache/k", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		handler(rec, mux.SetURLVars(r, map[string]string{"key": "k"}))
+		return rec
+	}
+
+	rec := put(`{"age": -1}`, "application/json")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422", rec.Code)
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := errorResponse{
+		Error: "value does not match the schema",
+		Code:  codeSchemaViolation,
+		Details: []string{
+			`/: missing required property "name"`,
+			"/age: -1 is less than the minimum 0",
+		},
+	}
+	if strings.Join(resp.Details, "\n") != strings.Join(want.Details, "\n") || resp.Error != want.Error || resp.Code != want.Code {
+		t.Errorf("response %+v, want %+v", resp, want)
+	}
+	if cache.Contains("k") {
+		t.Error("value violating the schema was stored")
+	}
+
+	if rec := put("plain", "text/plain"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("non-JSON body: status %d, want 422", rec.Code)
+	}
+	if rec := put(`{"name": "a", "age": 3}`, "application/json"); rec.Code >= 300 {
+		t.Errorf("conforming body: status %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Cache is the set of operations the HTTP handlers need. Both LRUCache and
+// ShardedLRUCache satisfy it for any value type V; the handlers use
+// Cache[interface{}] so they can store arbitrary decoded JSON.
+type Cache[V any] interface {
+	Get(key string) (V, bool)
+	GetItem(key string) (Item[V], bool)
+	GetCtx(ctx context.Context, key string) (V, bool, error)
+	GetItemCtx(ctx context.Context, key string) (Item[V], bool, error)
+	GetMany(keys []string) map[string]V
+	Peek(key string) (V, bool)
+	PeekItem(key string) (Item[V], bool)
+	Contains(key string) bool
+	Random() (string, V, bool)
+	GetOrLoad(key string, loader func() (V, time.Duration, error)) (V, error)
+	Set(key string, value V, expiration time.Duration)
+	SetCtx(ctx context.Context, key string, value V, expiration time.Duration) error
+	CompareAndSwap(key string, expectedVersion uint64, newValue V, ttl time.Duration) bool
+	Revert(key string, written uint64, prev Item[V]) bool
+	SetNX(key string, value V, expiration time.Duration) bool
+	SetMany(entries []BulkEntry[V]) ([]bool, []error)
+	Transaction(ops []Op[V]) error
+	Increment(key string, delta int64) (int64, error)
+	Append(key string, data []byte) (int, error)
+	Touch(key string, ttl time.Duration) bool
+	Pin(key string) bool
+	Unpin(key string) bool
+	SetNegative(key string, ttl time.Duration)
+	MergePatch(key string, patch []byte, ttl time.Duration) (V, bool, error)
+	LPush(key string, values []V, ttl time.Duration) (int, error)
+	RPush(key string, values []V, ttl time.Duration) (int, error)
+	LRange(key string, start, stop int) ([]V, error)
+	LLen(key string) (int, error)
+	HSet(key, field string, value V, ttl time.Duration) (bool, error)
+	HGet(key, field string) (V, bool, error)
+	HDel(key, field string) (bool, error)
+	Delete(key string)
+	CompareAndDelete(key string, expectedVersion uint64) (deleted, found bool)
+	DeleteMany(keys []string) int
+	DeletePrefix(prefix string) int
+	Undelete(key string) bool
+	Clear() int
+	Keys() []string
+	Len() int
+	Capacity() int
+	Resize(newCapacity int) int
+	WouldEvict(n int) []string
+	Bytes() int64
+	ContentTypes() map[string]int
+	Pinned() int
+	Metrics() Metrics
+	ResetStats()
+	Ages() Ages
+	Subscribe(buffer int) (<-chan Event, func())
+}
+
+// ShardedLRUCache spreads keys over a fixed number of independent LRUCache
+// shards so that operations on different keys rarely contend on the same
+// lock. LRU ordering and eviction are per shard: the globally least recently
+// used key is not necessarily the next one evicted.
+type ShardedLRUCache[V any] struct {
+	shards []*LRUCache[V]
+	hash   func(key string) uint64
+}
+
+// ShardOption configures a ShardedLRUCache; see NewShardedLRUCache.
+type ShardOption func(*shardConfig)
+
+type shardConfig struct {
+	hash func(key string) uint64
+}
+
+// WithHasher makes the cache pick a key's shard with hash instead of
+// 64-bit FNV-1a, for instance xxhash.Sum64String, which is faster on long
+// keys. The hash should spread the keys in use evenly over its low bits,
+// since the shard is the hash modulo the shard count; a skewed one makes
+// some shards hotter and fuller than others.
+func WithHasher(hash func(key string) uint64) ShardOption {
+	return func(cfg *shardConfig) { cfg.hash = hash }
+}
+
+// fnv64a is the default shard hash.
+func fnv64a(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// NewShardedLRUCache returns a cache holding roughly capacity entries split
+// across the given number of shards. Capacity is divided evenly, with any
+// remainder going to the first shards. A capacity below 1 is raised to 1,
+// as Resize does, and the shard count is clamped to [1, capacity] so that
+// no shard ends up with zero capacity. Keys are
+// assigned to shards by 64-bit FNV-1a unless WithHasher says otherwise.
+func NewShardedLRUCache[V any](capacity, shards int, opts ...ShardOption) *ShardedLRUCache[V] {
+	cfg := shardConfig{hash: fnv64a}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	if shards < 1 {
+		shards = 1
+	}
+	if shards > capacity {
+		shards = capacity
+	}
+
+	s := &ShardedLRUCache[V]{shards: make([]*LRUCache[V], shards), hash: cfg.hash}
+	for i := range s.shards {
+		shardCapacity := capacity / shards
+		if i < capacity%shards {
+			shardCapacity++
+		}
+		s.shards[i] = NewLRUCache[V](shardCapacity)
+	}
+	return s
+}
+
+func (s *ShardedLRUCache[V]) shard(key string) *LRUCache[V] {
+	return s.shards[s.shardIndex(key)]
+}
+
+func (s *ShardedLRUCache[V]) shardIndex(key string) int {
+	return int(s.hash(key) % uint64(len(s.shards)))
+}
+
+func (s *ShardedLRUCache[V]) Get(key string) (V, bool) {
+	return s.shard(key).Get(key)
+}
+
+func (s *ShardedLRUCache[V]) GetItem(key string) (Item[V], bool) {
+	return s.shard(key).GetItem(key)
+}
+
+func (s *ShardedLRUCache[V]) GetCtx(ctx context.Context, key string) (V, bool, error) {
+	return s.shard(key).GetCtx(ctx, key)
+}
+
+func (s *ShardedLRUCache[V]) GetItemCtx(ctx context.Context, key string) (Item[V], bool, error) {
+	return s.shard(key).GetItemCtx(ctx, key)
+}
+
+func (s *ShardedLRUCache[V]) Peek(key string) (V, bool) {
+	return s.shard(key).Peek(key)
+}
+
+func (s *ShardedLRUCache[V]) PeekItem(key string) (Item[V], bool) {
+	return s.shard(key).PeekItem(key)
+}
+
+func (s *ShardedLRUCache[V]) Contains(key string) bool {
+	return s.shard(key).Contains(key)
+}
+
+func (s *ShardedLRUCache[V]) SetNegative(key string, ttl time.Duration) {
+	s.shard(key).SetNegative(key, ttl)
+}
+
+func (s *ShardedLRUCache[V]) MergePatch(key string, patch []byte, ttl time.Duration) (V, bool, error) {
+	return s.shard(key).MergePatch(key, patch, ttl)
+}
+
+func (s *ShardedLRUCache[V]) LPush(key string, values []V, ttl time.Duration) (int, error) {
+	return s.shard(key).LPush(key, values, ttl)
+}
+
+func (s *ShardedLRUCache[V]) RPush(key string, values []V, ttl time.Duration) (int, error) {
+	return s.shard(key).RPush(key, values, ttl)
+}
+
+func (s *ShardedLRUCache[V]) LRange(key string, start, stop int) ([]V, error) {
+	return s.shard(key).LRange(key, start, stop)
+}
+
+func (s *ShardedLRUCache[V]) LLen(key string) (int, error) {
+	return s.shard(key).LLen(key)
+}
+
+func (s *ShardedLRUCache[V]) HSet(key, field string, value V, ttl time.Duration) (bool, error) {
+	return s.shard(key).HSet(key, field, value, ttl)
+}
+
+func (s *ShardedLRUCache[V]) HGet(key, field string) (V, bool, error) {
+	return s.shard(key).HGet(key, field)
+}
+
+func (s *ShardedLRUCache[V]) HDel(key, field string) (bool, error) {
+	return s.shard(key).HDel(key, field)
+}
+
+func (s *ShardedLRUCache[V]) GetOrLoad(key string, loader func() (V, time.Duration, error)) (V, error) {
+	return s.shard(key).GetOrLoad(key, loader)
+}
+
+// GetMany groups the keys by shard and looks each group up under that
+// shard's lock.
+func (s *ShardedLRUCache[V]) GetMany(keys []string) map[string]V {
+	groups := make(map[*LRUCache[V]][]string)
+	for _, key := range keys {
+		shard := s.shard(key)
+		groups[shard] = append(groups[shard], key)
+	}
+
+	found := make(map[string]V, len(keys))
+	for shard, group := range groups {
+		for key, value := range shard.GetMany(group) {
+			found[key] = value
+		}
+	}
+	return found
+}
+
+func (s *ShardedLRUCache[V]) Set(key string, value V, expiration time.Duration) {
+	s.shard(key).Set(key, value, expiration)
+}
+
+func (s *ShardedLRUCache[V]) SetCtx(ctx context.Context, key string, value V, expiration time.Duration) error {
+	return s.shard(key).SetCtx(ctx, key, value, expiration)
+}
+
+func (s *ShardedLRUCache[V]) CompareAndSwap(key string, expectedVersion uint64, newValue V, ttl time.Duration) bool {
+	return s.shard(key).CompareAndSwap(key, expectedVersion, newValue, ttl)
+}
+
+func (s *ShardedLRUCache[V]) Revert(key string, written uint64, prev Item[V]) bool {
+	return s.shard(key).Revert(key, written, prev)
+}
+
+func (s *ShardedLRUCache[V]) SetNX(key string, value V, expiration time.Duration) bool {
+	return s.shard(key).SetNX(key, value, expiration)
+}
+
+// SetMany groups the entries by shard and applies each group under that
+// shard's lock. The batch as a whole is not atomic across shards.
+func (s *ShardedLRUCache[V]) SetMany(entries []BulkEntry[V]) (inserted []bool, errs []error) {
+	groups := make(map[*LRUCache[V]][]int)
+	for i, e := range entries {
+		shard := s.shard(e.Key)
+		groups[shard] = append(groups[shard], i)
+	}
+
+	inserted = make([]bool, len(entries))
+	for shard, idx := range groups {
+		batch := make([]BulkEntry[V], len(idx))
+		for j, i := range idx {
+			batch[j] = entries[i]
+		}
+		ok, failed := shard.SetMany(batch)
+		for j := range idx {
+			inserted[idx[j]] = ok[j]
+		}
+		if failed == nil {
+			continue
+		}
+		if errs == nil {
+			errs = make([]error, len(entries))
+		}
+		for j, err := range failed {
+			errs[idx[j]] = err
+		}
+	}
+	return inserted, errs
+}
+
+func (s *ShardedLRUCache[V]) Increment(key string, delta int64) (int64, error) {
+	return s.shard(key).Increment(key, delta)
+}
+
+func (s *ShardedLRUCache[V]) Append(key string, data []byte) (int, error) {
+	return s.shard(key).Append(key, data)
+}
+
+func (s *ShardedLRUCache[V]) Touch(key string, ttl time.Duration) bool {
+	return s.shard(key).Touch(key, ttl)
+}
+
+// Pin pins key in its shard. Eviction is per shard, so a shard full of
+// pinned entries refuses or grows on its own, whatever room the others have.
+func (s *ShardedLRUCache[V]) Pin(key string) bool {
+	return s.shard(key).Pin(key)
+}
+
+func (s *ShardedLRUCache[V]) Unpin(key string) bool {
+	return s.shard(key).Unpin(key)
+}
+
+func (s *ShardedLRUCache[V]) CompareAndDelete(key string, expectedVersion uint64) (deleted, found bool) {
+	return s.shard(key).CompareAndDelete(key, expectedVersion)
+}
+
+func (s *ShardedLRUCache[V]) Delete(key string) {
+	s.shard(key).Delete(key)
+}
+
+func (s *ShardedLRUCache[V]) Undelete(key string) bool {
+	return s.shard(key).Undelete(key)
+}
+
+// DeleteMany groups the keys by shard and deletes each group under that
+// shard's lock, returning the total number of keys that were present.
+func (s *ShardedLRUCache[V]) DeleteMany(keys []string) int {
+	groups := make(map[*LRUCache[V]][]string)
+	for _, key := range keys {
+		shard := s.shard(key)
+		groups[shard] = append(groups[shard], key)
+	}
+
+	deleted := 0
+	for shard, group := range groups {
+		deleted += shard.DeleteMany(group)
+	}
+	return deleted
+}
+
+// DeletePrefix removes the matching keys from every shard in turn and
+// returns the total removed.
+func (s *ShardedLRUCache[V]) DeletePrefix(prefix string) int {
+	removed := 0
+	for _, shard := range s.shards {
+		removed += shard.DeletePrefix(prefix)
+	}
+	return removed
+}
+
+// Clear empties every shard and returns the total number of entries removed.
+// Shards are cleared one after another, so writes racing with Clear may land
+// in an already-cleared shard and survive.
+func (s *ShardedLRUCache[V]) Clear() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Clear()
+	}
+	return n
+}
+
+// Keys returns the live keys of every shard. Each shard's keys are in LRU
+// order, but there is no global recency order across shards.
+func (s *ShardedLRUCache[V]) Keys() []string {
+	keys := make([]string, 0, s.Len())
+	for _, shard := range s.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+// Len returns the total number of entries across all shards. Shards are read
+// one at a time, so the sum is not a consistent snapshot under concurrent
+// writes.
+func (s *ShardedLRUCache[V]) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Bytes returns the combined value size of all shards.
+func (s *ShardedLRUCache[V]) Bytes() int64 {
+	var n int64
+	for _, shard := range s.shards {
+		n += shard.Bytes()
+	}
+	return n
+}
+
+// ContentTypes returns how many entries hold each media type of value, over
+// all shards.
+func (s *ShardedLRUCache[V]) ContentTypes() map[string]int {
+	counts := make(map[string]int)
+	for _, shard := range s.shards {
+		for contentType, n := range shard.ContentTypes() {
+			counts[contentType] += n
+		}
+	}
+	return counts
+}
+
+// Pinned returns the combined number of pinned entries of all shards.
+func (s *ShardedLRUCache[V]) Pinned() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Pinned()
+	}
+	return n
+}
+
+// Capacity returns the combined capacity of all shards.
+func (s *ShardedLRUCache[V]) Capacity() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Capacity()
+	}
+	return n
+}
+
+// Resize splits newCapacity over the shards the way NewShardedLRUCache does
+// and resizes each in turn. Every shard keeps room for at least one entry,
+// so the total capacity can end up above a newCapacity smaller than the
+// shard count.
+func (s *ShardedLRUCache[V]) Resize(newCapacity int) int {
+	evicted := 0
+	for i, shard := range s.shards {
+		shardCapacity := newCapacity / len(s.shards)
+		if i < newCapacity%len(s.shards) {
+			shardCapacity++
+		}
+		evicted += shard.Resize(max(shardCapacity, 1))
+	}
+	return evicted
+}
+
+// WouldEvict takes the next victims of every shard in turn, one at a time.
+// Which shard actually evicts next depends on where the next writes land,
+// so across shards the order is only an estimate.
+func (s *ShardedLRUCache[V]) WouldEvict(n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	perShard := make([][]string, len(s.shards))
+	for i, shard := range s.shards {
+		perShard[i] = shard.WouldEvict(n)
+	}
+	keys := make([]string, 0, n)
+	for i := 0; len(keys) < n; i++ {
+		added := false
+		for _, victims := range perShard {
+			if i < len(victims) && len(keys) < n {
+				keys = append(keys, victims[i])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	return keys
+}
+
+// Subscribe merges the event streams of all shards into one channel. Events
+// from different shards are not ordered with respect to each other.
+func (s *ShardedLRUCache[V]) Subscribe(buffer int) (<-chan Event, func()) {
+	out := make(chan Event, buffer)
+	cancels := make([]func(), len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		ch, cancel := shard.Subscribe(buffer)
+		cancels[i] = cancel
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ev := range ch {
+				select {
+				case out <- ev:
+				default:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out, func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
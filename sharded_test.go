@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewShardedLRUCacheSmallCapacity(t *testing.T) {
+	for _, capacity := range []int{-1, 0, 1} {
+		s := NewShardedLRUCache[int](capacity, 16)
+		if n := len(s.shards); n != 1 {
+			t.Errorf("capacity %d: got %d shards, want 1", capacity, n)
+		}
+		s.Set("a", 1, 0)
+		if v, ok := s.Get("a"); !ok || v != 1 {
+			t.Errorf("capacity %d: Get(a) = %v, %v; want 1, true", capacity, v, ok)
+		}
+	}
+}
+
+func TestShardedWouldEvict(t *testing.T) {
+	s := NewShardedLRUCache[int](8, 4)
+	for i := 0; i < 8; i++ {
+		s.Set("key"+strconv.Itoa(i), i, 0)
+	}
+	for _, n := range []int{-1, 0} {
+		if keys := s.WouldEvict(n); keys != nil {
+			t.Errorf("WouldEvict(%d) = %v, want nil", n, keys)
+		}
+	}
+	if keys := s.WouldEvict(3); len(keys) != 3 {
+		t.Errorf("WouldEvict(3) = %v, want 3 keys", keys)
+	}
+}
+
+// TestShardDistribution checks, with a chi-squared test, that realistic
+// keys spread evenly over the shards with the default hash and with one
+// given through WithHasher.
+func TestShardDistribution(t *testing.T) {
+	const shards, keys = 16, 100000
+	// The chi-squared critical value for 15 degrees of freedom at p = 0.001.
+	const critical = 37.70
+
+	hashers := map[string][]ShardOption{
+		"fnv64a":   nil,
+		"ringHash": {WithHasher(ringHash)},
+	}
+	for name, opts := range hashers {
+		s := NewShardedLRUCache[int](keys, shards, opts...)
+		counts := make(map[string]int, shards)
+		for i := 0; i < keys; i++ {
+			key := "tenant:" + strconv.Itoa(i%97) + ":user:" + strconv.Itoa(i) + ":profile"
+			counts[strconv.Itoa(s.shardIndex(key))]++
+		}
+		if stat := chiSquared(counts, shards, keys); stat > critical {
+			t.Errorf("%s: chi-squared %.2f over %d shards exceeds %.2f; counts %v", name, stat, shards, critical, counts)
+		}
+	}
+}
+
+// BenchmarkShardedParallel runs a mix of Gets and Sets from every CPU
+// against caches of the same total capacity split over more and more
+// shards. One shard behaves like a single LRUCache behind one lock.
+func BenchmarkShardedParallel(b *testing.B) {
+	const keys = 1 << 14
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "key" + strconv.Itoa(i)
+	}
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			s := NewShardedLRUCache[int](keys, shards)
+			for i, name := range names {
+				s.Set(name, i, 0)
+			}
+			var seed atomic.Uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Each goroutine walks the keys from its own offset, so that
+				// they don't contend on a shared counter.
+				i := seed.Add(1) * 7919
+				for pb.Next() {
+					i++
+					if i%4 == 0 {
+						s.Set(names[i%keys], int(i), 0)
+					} else {
+						s.Get(names[i%keys])
+					}
+				}
+			})
+		})
+	}
+}
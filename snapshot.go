@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrCorruptSnapshot is returned when a snapshot file is truncated or its
+// checksum doesn't match its contents.
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+// snapshotChecksumPrefix starts the trailer line that follows the JSON body
+// of a snapshot file.
+const snapshotChecksumPrefix = "sha256:"
+
+// snapshotFile is the on-disk format written by SaveSnapshot.
+type snapshotFile[V any] struct {
+	SavedAt  time.Time          `json:"saved_at"`
+	Capacity int                `json:"capacity"`
+	Policy   Policy             `json:"policy"`
+	Entries  []snapshotEntry[V] `json:"entries"`
+}
+
+// snapshotEntry is one live entry. TTL is the time it had left when the
+// snapshot was taken, or zero if it never expires. ContentType is set for blobs; see persistValue.
+type snapshotEntry[V any] struct {
+	Key         string        `json:"key"`
+	Value       jsonValue[V]  `json:"value"`
+	ContentType string        `json:"content_type,omitempty"`
+	TTL         time.Duration `json:"ttl"`
+}
+
+// jsonValue is a V as written to a snapshot or the WAL. It encodes as the
+// value itself. When V is interface{} it decodes to the json.RawMessage of
+// the value instead of generic maps and float64s, so a value stored as raw
+// JSON keeps its key order and number formatting across a restart.
+type jsonValue[V any] struct {
+	V V
+}
+
+func (v jsonValue[V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.V)
+}
+
+func (v *jsonValue[V]) UnmarshalJSON(data []byte) error {
+	if p, ok := any(&v.V).(*interface{}); ok {
+		*p = json.RawMessage(bytes.Clone(data))
+		return nil
+	}
+	return json.Unmarshal(data, &v.V)
+}
+
+// SaveSnapshot writes the live entries, with their remaining TTLs, to path.
+// The file is one line of JSON followed by a line holding its SHA-256, which
+// lets a later load detect partial or damaged files. Entries are listed from
+// least to most recently used so a restore reproduces the LRU order.
+//
+// Only copying the entries happens under the (read) lock; encoding and I/O
+// happen after it is released. The file is written to a temporary name next
+// to path and renamed into place, so a crash mid-write never replaces a good
+// snapshot with a truncated one.
+func (c *LRUCache[V]) SaveSnapshot(path string) error {
+	return writeSnapshot(path, c.snapshot())
+}
+
+// writeSnapshot encodes snap and atomically replaces the file at path.
+func writeSnapshot[V any](path string, snap snapshotFile[V]) error {
+	start := time.Now()
+	body, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	w.Write(body)
+	w.WriteString("\n" + snapshotChecksumPrefix + hex.EncodeToString(sum[:]) + "\n")
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	slog.Info("cache", "op", "snapshot", "entries", len(snap.Entries), "path", path, "duration", time.Since(start))
+	return nil
+}
+
+func (c *LRUCache[V]) snapshot() snapshotFile[V] {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.snapshotLocked()
+}
+
+// snapshotLocked copies the live entries. The caller must hold c.mutex.
+func (c *LRUCache[V]) snapshotLocked() snapshotFile[V] {
+	now := time.Now()
+	snap := snapshotFile[V]{
+		SavedAt:  now,
+		Capacity: c.capacity,
+		Policy:   c.policy,
+		Entries:  make([]snapshotEntry[V], 0, c.size),
+	}
+	for ent := c.tail; ent != nil; ent = ent.prev {
+		if c.expired(ent, now) {
+			continue
+		}
+		var ttl time.Duration
+		if !ent.expiration.IsZero() {
+			ttl = ent.expiration.Sub(now)
+		}
+		value, contentType := persistValue(ent.value)
+		snap.Entries = append(snap.Entries, snapshotEntry[V]{Key: ent.key, Value: value, ContentType: contentType, TTL: ttl})
+	}
+	return snap
+}
+
+// LoadSnapshot creates a cache with the capacity and policy recorded in the
+// snapshot at path and fills it via RestoreSnapshot.
+func LoadSnapshot[V any](path string) (*LRUCache[V], error) {
+	snap, err := readSnapshot[V](path)
+	if err != nil {
+		return nil, err
+	}
+	c := newCache[V](snap.Capacity, snap.Policy)
+	c.restore(snap)
+	return c, nil
+}
+
+// RestoreSnapshot stores the entries of the snapshot at path into c and
+// returns how many were restored. Each entry's TTL restarts from now, so time
+// spent between saving and loading doesn't count against it; entries that
+// had no time left are skipped. The cache's own capacity applies, so
+// restoring into a smaller cache evicts the least recently used entries.
+func (c *LRUCache[V]) RestoreSnapshot(path string) (int, error) {
+	snap, err := readSnapshot[V](path)
+	if err != nil {
+		return 0, err
+	}
+	return c.restore(snap), nil
+}
+
+// StartSnapshots saves a snapshot to path every interval in the background
+// until Stop. Failures are logged and retried on the next tick. With a WAL
+// attached, each save is a Checkpoint and also truncates the log.
+func (c *LRUCache[V]) StartSnapshots(path string, interval time.Duration) {
+	c.every("snapshots", interval, func() {
+		if err := c.Checkpoint(path); err != nil {
+			slog.Error("cache", "op", "snapshot", "path", path, "err", err)
+		}
+	})
+}
+
+// readSnapshot reads and verifies a snapshot file. A missing file yields an
+// error matching fs.ErrNotExist; a damaged one yields ErrCorruptSnapshot.
+func readSnapshot[V any](path string) (snapshotFile[V], error) {
+	var snap snapshotFile[V]
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+
+	body, trailer, ok := bytes.Cut(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	if !ok || !bytes.HasPrefix(trailer, []byte(snapshotChecksumPrefix)) {
+		return snap, fmt.Errorf("snapshot %s: missing checksum: %w", path, ErrCorruptSnapshot)
+	}
+	sum := sha256.Sum256(body)
+	if string(trailer[len(snapshotChecksumPrefix):]) != hex.EncodeToString(sum[:]) {
+		return snap, fmt.Errorf("snapshot %s: checksum mismatch: %w", path, ErrCorruptSnapshot)
+	}
+
+	if err := json.Unmarshal(body, &snap); err != nil {
+		return snap, fmt.Errorf("snapshot %s: %v: %w", path, err, ErrCorruptSnapshot)
+	}
+	if snap.Capacity <= 0 {
+		return snap, fmt.Errorf("snapshot %s: invalid capacity %d: %w", path, snap.Capacity, ErrCorruptSnapshot)
+	}
+	return snap, nil
+}
+
+func (c *LRUCache[V]) restore(snap snapshotFile[V]) int {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	restored := 0
+	for _, e := range snap.Entries {
+		if e.TTL < 0 || c.checkRoom(e.Key) != nil {
+			continue
+		}
+		c.setLocked(e.Key, restoreValue(e.Value, e.ContentType), e.TTL)
+		restored++
+	}
+	return restored
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// sweepBatchSize is the number of entries the sweeper removes, or
+// DeletePrefix examines, per lock acquisition. Between batches the lock is
+// released so that a pass over a large cache doesn't stall request handling.
+const sweepBatchSize = 256
+
+// StartSweeper starts a background goroutine that removes expired entries
+// every interval. Without it, an entry that is never read again stays in the
+// cache, and counts against capacity, until it is evicted. Idle entries,
+// with IdleTimeout set, are removed the same way. Calling
+// StartSweeper while a sweeper is already running has no effect.
+func (c *LRUCache[V]) StartSweeper(interval time.Duration) {
+	c.every("sweeper", interval, func() { c.sweep() })
+}
+
+// every starts a background goroutine, identified by name, that calls fn
+// every interval until Stop. Starting a task that is already running has no
+// effect.
+func (c *LRUCache[V]) every(name string, interval time.Duration, fn func()) {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if c.running[name] {
+		return
+	}
+	if c.running == nil {
+		c.running = make(map[string]bool)
+	}
+	c.running[name] = true
+	if c.stop == nil {
+		c.stop = make(chan struct{})
+	}
+	stop := c.stop
+
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+// Stop terminates the background tasks, if any are running, and waits for
+// them to exit. It is safe to call more than once.
+func (c *LRUCache[V]) Stop() {
+	c.mutex.Lock()
+	stop := c.stop
+	c.stop = nil
+	c.running = nil
+	c.unlock()
+
+	if stop != nil {
+		close(stop)
+		c.background.Wait()
+	}
+}
+
+// sweep drops the tombstones whose undo window has closed and the negative
+// records that have run out, then removes the expired entries whose stale
+// window, if any, has ended, and the idle ones, and returns how many it
+// removed. Expired entries are taken from the expiry heap soonest first and
+// idle ones from the tail of the recency list, which is ordered by last
+// access; each stops at the first entry not yet due, so the cost depends on
+// how many entries go rather than on the size of the cache. The lock is
+// released every sweepBatchSize removals.
+func (c *LRUCache[V]) sweep() int {
+	start := time.Now()
+	removed := 0
+
+	c.mutex.Lock()
+	purged := c.purgeTombstonesLocked(start)
+	negatives := c.purgeNegativesLocked(start)
+	for {
+		now := time.Now()
+		batch := 0
+		for batch < sweepBatchSize {
+			if len(c.expiries) > 0 && c.expiries[0].dead(now) {
+				c.expireLocked(c.expiries[0])
+			} else if c.tail != nil && c.idle(c.tail, now) {
+				c.expireLocked(c.tail)
+			} else {
+				break
+			}
+			batch++
+		}
+		removed += batch
+		if batch < sweepBatchSize {
+			break
+		}
+
+		c.unlock()
+		runtime.Gosched()
+		c.mutex.Lock()
+	}
+	c.unlock()
+
+	if removed > 0 || purged > 0 || negatives > 0 {
+		slog.Info("cache", "op", "sweep", "removed", removed, "tombstones", purged, "negatives", negatives, "duration", time.Since(start))
+	}
+	return removed
+}
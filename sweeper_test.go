@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// sweepByScan is how the sweeper found expired entries before the expiry
+// heap: by looking at every entry in the cache.
+func (c *LRUCache[V]) sweepByScan() int {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	now := time.Now()
+	removed := 0
+	for _, ent := range c.cache {
+		if c.dead(ent, now) {
+			c.expireLocked(ent)
+			removed++
+		}
+	}
+	return removed
+}
+
+// BenchmarkSweep sweeps a large cache in which a small fraction of the
+// entries has expired, with the expiry heap and with a scan of every entry.
+func BenchmarkSweep(b *testing.B) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	const size, expiring = 100000, 1000
+	live := make([]string, size-expiring)
+	for i := range live {
+		live[i] = "live" + strconv.Itoa(i)
+	}
+	expired := make([]string, expiring)
+	for i := range expired {
+		expired[i] = "expired" + strconv.Itoa(i)
+	}
+	sweeps := []struct {
+		name  string
+		sweep func(*LRUCache[int]) int
+	}{
+		{"heap", (*LRUCache[int]).sweep},
+		{"scan", (*LRUCache[int]).sweepByScan},
+	}
+	for _, sw := range sweeps {
+		b.Run(sw.name, func(b *testing.B) {
+			c := NewLRUCache[int](size)
+			for i, key := range live {
+				c.Set(key, i, time.Hour)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j, key := range expired {
+					c.Set(key, j, time.Nanosecond)
+				}
+				time.Sleep(time.Microsecond)
+				b.StartTimer()
+				if n := sw.sweep(c); n != expiring {
+					b.Fatalf("swept %d entries, want %d", n, expiring)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecondaryStore is a larger, slower tier behind the in-memory cache, such
+// as Redis. Values are opaque bytes; Get reports false for a missing key,
+// and Set stores value for ttl, or without expiring for a ttl of zero.
+type SecondaryStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// prefixDeleter is implemented by secondary stores that can delete keys by
+// prefix, which tieredCache needs for DeletePrefix and Clear to reach the
+// second tier. Without it those only affect the first.
+type prefixDeleter interface {
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// storedDecoder is implemented by the Cache wrappers that transform values on
+// their way into the cache, such as compressedCache, to turn a value as the
+// underlying cache holds it back into the original. tieredCache uses it on
+// values read from the second tier, which were spilled by the cache itself
+// and so never passed back through the wrappers.
+type storedDecoder interface {
+	decodeStored(key string, value interface{}) (interface{}, error)
+}
+
+// tierRecord is an entry as kept in the second tier, encoded as JSON.
+type tierRecord struct {
+	Value jsonValue[interface{}] `json:"value"`
+	// ContentType is set for blobs; see persistValue.
+	ContentType string    `json:"content_type,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// tieredCache puts a SecondaryStore behind a Cache. Entries the cache evicts
+// for capacity are spilled to the store (see spill, which must be installed
+// as the underlying LRUCache's Spill), and a read that misses the cache
+// checks the store and moves a hit back into the cache. The tiers are kept
+// exclusive: a key is deleted from the store when it is promoted, written or
+// deleted, so the store never serves a value older than the cache's. Peeks
+// read the store without promoting. Writes that depend on the current value,
+// such as SetNX, CompareAndSwap, CompareAndDelete, Increment, Append,
+// Touch, MergePatch and the list and hash operations, promote the key first
+// so they see it, and so does Pin.
+//
+// Every store call is a synchronous round trip limited to timeout. A store
+// that fails or times out is logged and treated as a miss, so the cache keeps
+// working on its own.
+//
+// A spill runs after the cache lock is released, so a write or delete of the
+// key can delete it from the store before the spill stores the old value.
+// The deletes are recorded for a while, and a spill that finds one made
+// after its eviction deletes what it stored again; see spill.
+type tieredCache struct {
+	Cache[interface{}]
+	store   SecondaryStore
+	timeout time.Duration
+
+	// forgotten and forgottenPrefixes hold when each key and prefix was
+	// last deleted from the store, for spillHorizon plus timeout; pruned is
+	// when the old ones were last dropped. mu guards all three.
+	mu                sync.Mutex
+	forgotten         map[string]time.Time
+	forgottenPrefixes map[string]time.Time
+	pruned            time.Time
+}
+
+// spillHorizon is how long after an eviction its spill may still store the
+// entry. Spills delivered later, behind a batch of slow ones, are dropped,
+// since the deletes they would need to check may have been pruned.
+const spillHorizon = time.Minute
+
+func newTieredCache(cache Cache[interface{}], store SecondaryStore, timeout time.Duration) *tieredCache {
+	return &tieredCache{
+		Cache:             cache,
+		store:             store,
+		timeout:           timeout,
+		forgotten:         make(map[string]time.Time),
+		forgottenPrefixes: make(map[string]time.Time),
+	}
+}
+
+// spill writes an entry evicted at evicted to the store. If the key was
+// deleted from the store since, by a write or delete that may have run
+// before the store's Set, it is deleted again, so that the store never keeps
+// a value older than what replaced it; losing the spill only costs a miss.
+func (t *tieredCache) spill(key string, value interface{}, expiration, evicted time.Time) {
+	if time.Since(evicted) > spillHorizon {
+		slog.Warn("cache", "op", "spill", "key", key, "dropped", true, "since_eviction", time.Since(evicted))
+		return
+	}
+	var ttl time.Duration
+	if !expiration.IsZero() {
+		if ttl = time.Until(expiration); ttl <= 0 {
+			return
+		}
+	}
+	v, contentType := persistValue(value)
+	data, err := json.Marshal(tierRecord{Value: v, ContentType: contentType, ExpiresAt: expiration})
+	if err != nil {
+		slog.Warn("cache", "op", "spill", "key", key, "err", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	if err := t.store.Set(ctx, key, data, ttl); err != nil {
+		slog.Warn("cache", "op", "spill", "key", key, "err", err)
+		return
+	}
+	if t.forgottenSince(key, evicted) {
+		slog.Debug("cache", "op", "spill", "key", key, "undone", true)
+		t.deleteStored(key)
+		return
+	}
+	slog.Debug("cache", "op", "spill", "key", key)
+}
+
+// forgottenSince reports whether key, or a prefix of it, was deleted from
+// the store at or after since.
+func (t *tieredCache) forgottenSince(key string, since time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if at, ok := t.forgotten[key]; ok && !at.Before(since) {
+		return true
+	}
+	for prefix, at := range t.forgottenPrefixes {
+		if strings.HasPrefix(key, prefix) && !at.Before(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// record notes that key, or the keys under prefix if isPrefix is set, are
+// about to be deleted from the store, for forgottenSince, and prunes the
+// records that no spill can still need.
+func (t *tieredCache) record(key string, isPrefix bool) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.pruned) > spillHorizon {
+		cutoff := now.Add(-spillHorizon - t.timeout)
+		for k, at := range t.forgotten {
+			if at.Before(cutoff) {
+				delete(t.forgotten, k)
+			}
+		}
+		for p, at := range t.forgottenPrefixes {
+			if at.Before(cutoff) {
+				delete(t.forgottenPrefixes, p)
+			}
+		}
+		t.pruned = now
+	}
+	if isPrefix {
+		t.forgottenPrefixes[key] = now
+	} else {
+		t.forgotten[key] = now
+	}
+}
+
+// fetch reads key from the store, returning its value, decoded as the
+// cache's wrappers would, and expiration.
+func (t *tieredCache) fetch(key string) (Item[interface{}], bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	data, ok, err := t.store.Get(ctx, key)
+	if err != nil {
+		slog.Warn("cache", "op", "l2_get", "key", key, "err", err)
+		return Item[interface{}]{}, false
+	}
+	if !ok {
+		return Item[interface{}]{}, false
+	}
+	var rec tierRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		slog.Warn("cache", "op", "l2_get", "key", key, "err", err)
+		return Item[interface{}]{}, false
+	}
+	if !rec.ExpiresAt.IsZero() && !time.Now().Before(rec.ExpiresAt) {
+		return Item[interface{}]{}, false
+	}
+	value := restoreValue(rec.Value, rec.ContentType)
+	if d, ok := t.Cache.(storedDecoder); ok {
+		if value, err = d.decodeStored(key, value); err != nil {
+			slog.Warn("cache", "op", "l2_get", "key", key, "err", err)
+			return Item[interface{}]{}, false
+		}
+	}
+	return Item[interface{}]{Value: value, Expiration: rec.ExpiresAt}, true
+}
+
+// promote moves key from the store into the cache, unless a write has put
+// a newer value in the cache meanwhile, and reports whether the cache now
+// holds the store's value.
+func (t *tieredCache) promote(key string) bool {
+	item, ok := t.fetch(key)
+	if !ok {
+		return false
+	}
+	var ttl time.Duration
+	if !item.Expiration.IsZero() {
+		if ttl = time.Until(item.Expiration); ttl <= 0 {
+			return false
+		}
+	}
+	promoted := t.Cache.SetNX(key, item.Value, ttl)
+	t.forget(key)
+	slog.Debug("cache", "op", "promote", "key", key, "promoted", promoted)
+	return promoted
+}
+
+// promoteMissing promotes key if the cache doesn't hold it.
+func (t *tieredCache) promoteMissing(key string) {
+	if !t.Cache.Contains(key) {
+		t.promote(key)
+	}
+}
+
+// forget deletes key from the store.
+func (t *tieredCache) forget(key string) {
+	t.record(key, false)
+	t.deleteStored(key)
+}
+
+// deleteStored deletes key from the store without recording it.
+func (t *tieredCache) deleteStored(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	if err := t.store.Delete(ctx, key); err != nil {
+		slog.Warn("cache", "op", "l2_delete", "key", key, "err", err)
+	}
+}
+
+// forgetPrefix deletes the keys starting with prefix from the store, if it
+// supports that.
+func (t *tieredCache) forgetPrefix(prefix string) {
+	pd, ok := t.store.(prefixDeleter)
+	if !ok {
+		return
+	}
+	t.record(prefix, true)
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	if err := pd.DeletePrefix(ctx, prefix); err != nil {
+		slog.Warn("cache", "op", "l2_delete_prefix", "prefix", prefix, "err", err)
+	}
+}
+
+func (t *tieredCache) Get(key string) (interface{}, bool) {
+	item, ok := t.GetItem(key)
+	return item.Value, ok
+}
+
+// A negatively cached key is a miss without a look at the store: it was
+// recorded after the store had been checked.
+func (t *tieredCache) GetItem(key string) (Item[interface{}], bool) {
+	if item, ok := t.Cache.GetItem(key); ok || item.Negative || !t.promote(key) {
+		return item, ok
+	}
+	return t.Cache.GetItem(key)
+}
+
+func (t *tieredCache) GetCtx(ctx context.Context, key string) (interface{}, bool, error) {
+	item, ok, err := t.GetItemCtx(ctx, key)
+	return item.Value, ok, err
+}
+
+func (t *tieredCache) GetItemCtx(ctx context.Context, key string) (Item[interface{}], bool, error) {
+	item, ok, err := t.Cache.GetItemCtx(ctx, key)
+	if err != nil || ok || item.Negative || !t.promote(key) {
+		return item, ok, err
+	}
+	return t.Cache.GetItemCtx(ctx, key)
+}
+
+func (t *tieredCache) GetMany(keys []string) map[string]interface{} {
+	found := t.Cache.GetMany(keys)
+	var promoted []string
+	for _, key := range keys {
+		if _, ok := found[key]; !ok && t.promote(key) {
+			promoted = append(promoted, key)
+		}
+	}
+	if len(promoted) > 0 {
+		for key, value := range t.Cache.GetMany(promoted) {
+			found[key] = value
+		}
+	}
+	return found
+}
+
+func (t *tieredCache) Peek(key string) (interface{}, bool) {
+	item, ok := t.PeekItem(key)
+	return item.Value, ok
+}
+
+func (t *tieredCache) PeekItem(key string) (Item[interface{}], bool) {
+	if item, ok := t.Cache.PeekItem(key); ok || item.Negative {
+		return item, ok
+	}
+	return t.fetch(key)
+}
+
+func (t *tieredCache) Contains(key string) bool {
+	if t.Cache.Contains(key) {
+		return true
+	}
+	_, ok := t.fetch(key)
+	return ok
+}
+
+func (t *tieredCache) GetOrLoad(key string, loader func() (interface{}, time.Duration, error)) (interface{}, error) {
+	t.promoteMissing(key)
+	return t.Cache.GetOrLoad(key, loader)
+}
+
+func (t *tieredCache) Set(key string, value interface{}, expiration time.Duration) {
+	t.Cache.Set(key, value, expiration)
+	t.forget(key)
+}
+
+func (t *tieredCache) SetCtx(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	if err := t.Cache.SetCtx(ctx, key, value, expiration); err != nil {
+		return err
+	}
+	t.forget(key)
+	return nil
+}
+
+func (t *tieredCache) CompareAndSwap(key string, expectedVersion uint64, newValue interface{}, ttl time.Duration) bool {
+	t.promoteMissing(key)
+	return t.Cache.CompareAndSwap(key, expectedVersion, newValue, ttl)
+}
+
+func (t *tieredCache) SetNX(key string, value interface{}, expiration time.Duration) bool {
+	t.promoteMissing(key)
+	return t.Cache.SetNX(key, value, expiration)
+}
+
+// SetMany deletes the keys it stored from the store afterwards; a key the
+// cache refused keeps whatever value the store has for it.
+func (t *tieredCache) SetMany(entries []BulkEntry[interface{}]) ([]bool, []error) {
+	inserted, errs := t.Cache.SetMany(entries)
+	for i, e := range entries {
+		if errs == nil || errs[i] == nil {
+			t.forget(e.Key)
+		}
+	}
+	return inserted, errs
+}
+
+// Transaction deletes the keys of a successful batch from the store
+// afterwards, one at a time, so the store may still serve an old value for
+// a key of the batch in the meantime.
+func (t *tieredCache) Transaction(ops []Op[interface{}]) error {
+	if err := t.Cache.Transaction(ops); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		t.forget(op.Key)
+	}
+	return nil
+}
+
+func (t *tieredCache) Increment(key string, delta int64) (int64, error) {
+	t.promoteMissing(key)
+	return t.Cache.Increment(key, delta)
+}
+
+func (t *tieredCache) Append(key string, data []byte) (int, error) {
+	t.promoteMissing(key)
+	return t.Cache.Append(key, data)
+}
+
+func (t *tieredCache) Touch(key string, ttl time.Duration) bool {
+	t.promoteMissing(key)
+	return t.Cache.Touch(key, ttl)
+}
+
+// Pin promotes the key first, so that a key spilled to the store can be
+// pinned back in the cache.
+func (t *tieredCache) Pin(key string) bool {
+	t.promoteMissing(key)
+	return t.Cache.Pin(key)
+}
+
+func (t *tieredCache) SetNegative(key string, ttl time.Duration) {
+	t.Cache.SetNegative(key, ttl)
+	t.forget(key)
+}
+
+func (t *tieredCache) MergePatch(key string, patch []byte, ttl time.Duration) (interface{}, bool, error) {
+	t.promoteMissing(key)
+	return t.Cache.MergePatch(key, patch, ttl)
+}
+
+func (t *tieredCache) LPush(key string, values []interface{}, ttl time.Duration) (int, error) {
+	t.promoteMissing(key)
+	return t.Cache.LPush(key, values, ttl)
+}
+
+func (t *tieredCache) RPush(key string, values []interface{}, ttl time.Duration) (int, error) {
+	t.promoteMissing(key)
+	return t.Cache.RPush(key, values, ttl)
+}
+
+func (t *tieredCache) LRange(key string, start, stop int) ([]interface{}, error) {
+	t.promoteMissing(key)
+	return t.Cache.LRange(key, start, stop)
+}
+
+func (t *tieredCache) LLen(key string) (int, error) {
+	t.promoteMissing(key)
+	return t.Cache.LLen(key)
+}
+
+func (t *tieredCache) HSet(key, field string, value interface{}, ttl time.Duration) (bool, error) {
+	t.promoteMissing(key)
+	return t.Cache.HSet(key, field, value, ttl)
+}
+
+func (t *tieredCache) HGet(key, field string) (interface{}, bool, error) {
+	t.promoteMissing(key)
+	return t.Cache.HGet(key, field)
+}
+
+func (t *tieredCache) HDel(key, field string) (bool, error) {
+	t.promoteMissing(key)
+	return t.Cache.HDel(key, field)
+}
+
+func (t *tieredCache) Delete(key string) {
+	t.Cache.Delete(key)
+	t.forget(key)
+}
+
+func (t *tieredCache) CompareAndDelete(key string, expectedVersion uint64) (deleted, found bool) {
+	t.promoteMissing(key)
+	deleted, found = t.Cache.CompareAndDelete(key, expectedVersion)
+	if deleted {
+		t.forget(key)
+	}
+	return deleted, found
+}
+
+// DeleteMany reports only the keys deleted from the cache itself.
+func (t *tieredCache) DeleteMany(keys []string) int {
+	n := t.Cache.DeleteMany(keys)
+	for _, key := range keys {
+		t.forget(key)
+	}
+	return n
+}
+
+// DeletePrefix reports only the keys deleted from the cache itself.
+func (t *tieredCache) DeletePrefix(prefix string) int {
+	n := t.Cache.DeletePrefix(prefix)
+	t.forgetPrefix(prefix)
+	return n
+}
+
+func (t *tieredCache) Clear() int {
+	n := t.Cache.Clear()
+	t.forgetPrefix("")
+	return n
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is a SecondaryStore, and prefixDeleter, held in memory.
+type memStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string][]byte)}
+}
+
+func (s *memStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.data[key]
+	return data, ok, nil
+}
+
+func (s *memStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s *memStore) DeletePrefix(ctx context.Context, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.data {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.data, key)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) has(key string) bool {
+	_, ok, _ := s.Get(context.Background(), key)
+	return ok
+}
+
+// TestSpillAfterDelete delivers spills of entries evicted before a delete
+// of their key, as happens when the delete lands between the eviction and
+// its spill, and checks that they don't bring the key back.
+func TestSpillAfterDelete(t *testing.T) {
+	old := json.RawMessage(`"old"`)
+	tests := []struct {
+		name   string
+		delete func(tc *tieredCache)
+	}{
+		{"Delete", func(tc *tieredCache) { tc.Delete("user:1") }},
+		{"Set", func(tc *tieredCache) { tc.Set("user:1", json.RawMessage(`"new"`), 0) }},
+		{"DeletePrefix", func(tc *tieredCache) { tc.DeletePrefix("user:") }},
+		{"Clear", func(tc *tieredCache) { tc.Clear() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			tc := newTieredCache(NewLRUCache[interface{}](10), store, time.Second)
+
+			evicted := time.Now()
+			tt.delete(tc)
+			tc.spill("user:1", old, time.Time{}, evicted)
+			if store.has("user:1") {
+				t.Error("a spill from before the write left the old value in the store")
+			}
+			if tt.name == "Set" {
+				if v, _ := tc.Get("user:1"); string(v.(json.RawMessage)) != `"new"` {
+					t.Errorf("Get = %s, want the new value", v)
+				}
+			} else if tc.Contains("user:1") {
+				t.Error("deleted key came back")
+			}
+
+			tc.spill("user:1", old, time.Time{}, time.Now())
+			if !store.has("user:1") {
+				t.Error("a spill from after the write was not stored")
+			}
+		})
+	}
+}
+
+func TestSpillEndToEnd(t *testing.T) {
+	store := newMemStore()
+	cache := NewLRUCache[interface{}](1)
+	tc := newTieredCache(cache, store, time.Second)
+	cache.Spill = tc.spill
+
+	tc.Set("a", json.RawMessage(`1`), 0)
+	tc.Set("b", json.RawMessage(`2`), 0)
+	if !store.has("a") {
+		t.Fatal("evicted key a was not spilled")
+	}
+	if v, ok := tc.Get("a"); !ok || string(v.(json.RawMessage)) != `1` {
+		t.Errorf("Get(a) = %v, %v; want it promoted from the store", v, ok)
+	}
+	if store.has("a") {
+		t.Error("promoted key a was left in the store")
+	}
+
+	tc.Delete("b")
+	if tc.Contains("b") {
+		t.Error("deleted key b is still found")
+	}
+}
+
+func TestSpillTooLate(t *testing.T) {
+	store := newMemStore()
+	tc := newTieredCache(NewLRUCache[interface{}](10), store, time.Second)
+	tc.spill("a", json.RawMessage(`1`), time.Time{}, time.Now().Add(-2*spillHorizon))
+	if store.has("a") {
+		t.Error("a spill delivered past spillHorizon was stored")
+	}
+}
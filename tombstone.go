@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// tombstone is what Undelete needs of a deleted entry, kept until its
+// window closes. It is copied out of the entry rather than holding on to
+// it, so that the entry, and the list neighbours it still links to, can be
+// freed.
+type tombstone[V any] struct {
+	value      V
+	expiration time.Time
+	// accessed is the entry's last read or write in Unix nanoseconds, for
+	// IdleTimeout.
+	accessed int64
+	until    time.Time
+}
+
+// expired reports whether the deleted entry would have expired, or gone
+// idle, by now.
+func (t tombstone[V]) expired(c *LRUCache[V], now time.Time) bool {
+	if !t.expiration.IsZero() && !t.expiration.After(now) {
+		return true
+	}
+	return c.IdleTimeout > 0 && now.Sub(time.Unix(0, t.accessed)) > c.IdleTimeout
+}
+
+// deleteLocked removes ent as an explicit delete. With an UndoWindow the
+// entry is kept as a tombstone, invisible to every other operation, that
+// Undelete can bring back until the window closes. The caller must hold
+// c.mutex for writing.
+func (c *LRUCache[V]) deleteLocked(ent *entry[V]) {
+	c.counters.deletes.Add(1)
+	c.removeEntry(ent)
+	c.journalDelete(ent.key)
+	c.publish(EventDelete, ent.key)
+
+	if c.UndoWindow <= 0 {
+		return
+	}
+	now := time.Now()
+	if len(c.tombstones) >= c.capacity {
+		c.purgeTombstonesLocked(now)
+	}
+	if len(c.tombstones) >= c.capacity {
+		slog.Debug("cache", "op", "delete", "key", ent.key, "tombstone", false)
+		return
+	}
+	if c.tombstones == nil {
+		c.tombstones = make(map[string]tombstone[V])
+	}
+	c.tombstones[ent.key] = tombstone[V]{
+		value:      ent.value,
+		expiration: ent.expiration,
+		accessed:   ent.accessed.Load(),
+		until:      now.Add(c.UndoWindow),
+	}
+}
+
+// purgeTombstonesLocked forgets the tombstones whose undo window has closed
+// and returns how many it dropped. The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) purgeTombstonesLocked(now time.Time) int {
+	purged := 0
+	for key, t := range c.tombstones {
+		if !t.until.After(now) {
+			delete(c.tombstones, key)
+			purged++
+		}
+	}
+	return purged
+}
+
+// Undelete restores key as it was before its last delete, with the
+// expiration it had then, and reports whether it could. It can't once the
+// UndoWindow has closed, if the entry has since expired, or if the key has
+// been written again in the meantime. The restored entry counts as a new
+// write and gets a new version.
+func (c *LRUCache[V]) Undelete(key string) bool {
+	c.mutex.Lock()
+	defer c.unlock()
+
+	t, ok := c.tombstones[key]
+	if !ok {
+		slog.Debug("cache", "op", "undelete", "key", key, "restored", false)
+		return false
+	}
+	delete(c.tombstones, key)
+
+	now := time.Now()
+	if existing, ok := c.cache[key]; ok && !c.expired(existing, now) {
+		slog.Debug("cache", "op", "undelete", "key", key, "restored", false, "exists", true)
+		return false
+	}
+	if !t.until.After(now) || t.expired(c, now) {
+		slog.Debug("cache", "op", "undelete", "key", key, "restored", false, "expired", true)
+		return false
+	}
+
+	var ttl time.Duration
+	if !t.expiration.IsZero() {
+		ttl = t.expiration.Sub(now)
+	}
+	slog.Debug("cache", "op", "undelete", "key", key, "restored", true)
+	c.setLocked(key, t.value, ttl)
+	return true
+}
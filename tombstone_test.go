@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUndelete(t *testing.T) {
+	const window = 50 * time.Millisecond
+	newCache := func() *LRUCache[string] {
+		c := NewLRUCache[string](4)
+		c.UndoWindow = window
+		return c
+	}
+
+	t.Run("within window", func(t *testing.T) {
+		c := newCache()
+		c.Set("a", "1", time.Hour)
+		before, _ := c.PeekItem("a")
+		c.Delete("a")
+		if c.Contains("a") {
+			t.Fatal("deleted key is still visible")
+		}
+		if !c.Undelete("a") {
+			t.Fatal("Undelete within the window failed")
+		}
+		after, ok := c.PeekItem("a")
+		// The TTL left is recomputed on restore, so allow for the time
+		// that took.
+		if drift := after.Expiration.Sub(before.Expiration); !ok || after.Value != "1" || drift < 0 || drift > time.Second {
+			t.Errorf("restored %q expiring %v, want %q expiring %v", after.Value, after.Expiration, before.Value, before.Expiration)
+		}
+		if after.Version == before.Version {
+			t.Error("restored entry kept its old version")
+		}
+		if c.Undelete("a") {
+			t.Error("second Undelete succeeded")
+		}
+	})
+
+	t.Run("window closed", func(t *testing.T) {
+		c := newCache()
+		c.Set("a", "1", 0)
+		c.Delete("a")
+		time.Sleep(2 * window)
+		if c.Undelete("a") || c.Contains("a") {
+			t.Error("Undelete succeeded after the window closed")
+		}
+	})
+
+	t.Run("written again", func(t *testing.T) {
+		c := newCache()
+		c.Set("a", "1", 0)
+		c.Delete("a")
+		c.Set("a", "2", 0)
+		if c.Undelete("a") {
+			t.Error("Undelete overwrote a newer write")
+		}
+		if v, _ := c.Get("a"); v != "2" {
+			t.Errorf("Get(a) = %q, want 2", v)
+		}
+	})
+
+	t.Run("expired meanwhile", func(t *testing.T) {
+		c := newCache()
+		c.Set("a", "1", window/5)
+		c.Delete("a")
+		time.Sleep(window / 2)
+		if c.Undelete("a") {
+			t.Error("Undelete restored an entry that has since expired")
+		}
+	})
+
+	t.Run("no window", func(t *testing.T) {
+		c := NewLRUCache[string](4)
+		c.Set("a", "1", 0)
+		c.Delete("a")
+		if c.Undelete("a") {
+			t.Error("Undelete succeeded without an UndoWindow")
+		}
+	})
+
+	t.Run("at most capacity tombstones", func(t *testing.T) {
+		c := newCache()
+		keys := []string{"a", "b", "c", "d", "e"}
+		for _, key := range keys {
+			c.Set(key, key, 0)
+			c.Delete(key)
+		}
+		if len(c.tombstones) != 4 {
+			t.Errorf("kept %d tombstones, want the capacity, 4", len(c.tombstones))
+		}
+		if c.Undelete("e") {
+			t.Error("a delete past capacity was soft")
+		}
+		if !c.Undelete("a") {
+			t.Error("an early delete was not kept")
+		}
+	})
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// traceQueueSize is how many finished spans may wait for export. Spans
+	// recorded while the queue is full are dropped rather than slowing down
+	// requests.
+	traceQueueSize = 2048
+	// traceBatchSize is the most spans sent in one export request.
+	traceBatchSize = 256
+	// traceFlushInterval is how long a span may wait for a batch to fill.
+	traceFlushInterval = 5 * time.Second
+	// traceServiceName is reported as the service.name resource attribute.
+	traceServiceName = "lru-cache-api"
+)
+
+// tracer records an OpenTelemetry server span for every API request and
+// exports them in batches to an OTLP/HTTP collector, using the protocol's
+// JSON encoding so that tracing needs no extra dependencies. Incoming W3C
+// traceparent headers are honoured: the span joins the caller's trace, and
+// isn't recorded at all if the caller didn't sample it. With hashKeys set,
+// spans carry a SHA-256 of the key instead of the key itself; that hides
+// keys from the tracing backend but not from anyone able to guess them.
+type tracer struct {
+	endpoint string
+	hashKeys bool
+	client   *http.Client
+
+	spans   chan otlpSpan
+	dropped atomic.Uint64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newTracer starts a tracer that exports to the collector at endpoint, such
+// as http://localhost:4318. Call Close to flush the remaining spans.
+func newTracer(endpoint string, hashKeys bool) *tracer {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	t := &tracer{
+		endpoint: endpoint,
+		hashKeys: hashKeys,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		spans:    make(chan otlpSpan, traceQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Close exports the spans still queued and stops the exporter. Spans
+// recorded afterwards are dropped.
+func (t *tracer) Close() {
+	close(t.stop)
+	<-t.done
+}
+
+// Middleware records a span for each request it passes to next. It must be
+// installed on the router, so that the matched route is known.
+func (t *tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if ok && !sampled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ok {
+			traceID = randomHex(16)
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		end := time.Now()
+
+		span := t.span(r, rec)
+		span.TraceID, span.SpanID, span.ParentSpanID = traceID, randomHex(8), parentID
+		span.StartTimeUnixNano = strconv.FormatInt(start.UnixNano(), 10)
+		span.EndTimeUnixNano = strconv.FormatInt(end.UnixNano(), 10)
+		select {
+		case t.spans <- span:
+		default:
+			t.dropped.Add(1)
+		}
+	})
+}
+
+// span describes the served request r. The caller fills in the IDs and
+// times.
+func (t *tracer) span(r *http.Request, rec *statusRecorder) otlpSpan {
+	var template string
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+	attrs := []otlpAttribute{
+		stringAttr("http.request.method", r.Method),
+		stringAttr("http.route", template),
+		intAttr("http.response.status_code", int64(rec.status)),
+		stringAttr("cache.operation", routeOperation(r.Method, template)),
+	}
+
+	vars := mux.Vars(r)
+	if namespace, ok := vars["namespace"]; ok {
+		attrs = append(attrs, stringAttr("cache.namespace", namespace))
+	}
+	if key, ok := vars["key"]; ok {
+		if t.hashKeys {
+			sum := sha256.Sum256([]byte(key))
+			attrs = append(attrs, stringAttr("cache.key_hash", hex.EncodeToString(sum[:])))
+		} else {
+			attrs = append(attrs, stringAttr("cache.key", key))
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			// X-Cache-Source is only sent when the body could have come
+			// from elsewhere; without it, a success is the cache's own.
+			source := rec.Header().Get("X-Cache-Source")
+			hit := rec.status < 400 && (source == "" || source == "cache")
+			attrs = append(attrs, boolAttr("cache.hit", hit))
+		}
+	}
+
+	span := otlpSpan{
+		Name:       strings.TrimSpace(r.Method + " " + template),
+		Kind:       otlpSpanKindServer,
+		Attributes: attrs,
+	}
+	if rec.status >= 400 {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: http.StatusText(rec.status)}
+	}
+	return span
+}
+
+// keyOperations names the operation of each method on a key route.
+var keyOperations = map[string]string{
+	http.MethodGet:    "get",
+	http.MethodHead:   "head",
+	http.MethodPut:    "set",
+	http.MethodDelete: "delete",
+}
+
+// routeOperation names the cache operation served by method on the route
+// with the given path template: get, set and so on for a key itself, and
+// the last path segment, such as incr or stats, otherwise.
+func routeOperation(method, template string) string {
+	last := template[strings.LastIndex(template, "/")+1:]
+	switch {
+	case last == "{key}":
+		return keyOperations[method]
+	case last == "cache" || last == "{namespace}":
+		return "clear"
+	}
+	return last
+}
+
+// parseTraceparent parses a W3C traceparent header into its trace ID,
+// parent span ID and sampled flag. ok is false if the header is missing or
+// malformed, in which case the request starts a new trace.
+func parseTraceparent(header string) (traceID, spanID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return "", "", false, false
+	}
+	traceID, spanID = parts[1], parts[2]
+	if !validTraceID(traceID, 32) || !validTraceID(spanID, 16) || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return "", "", false, false
+	}
+	return traceID, spanID, flags[0]&1 == 1, true
+}
+
+// validTraceID reports whether id is n lowercase hex digits, not all zero.
+func validTraceID(id string, n int) bool {
+	if len(id) != n || id == strings.Repeat("0", n) {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// run batches queued spans and exports them until Close is called.
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-t.spans:
+			if batch = append(batch, span); len(batch) >= traceBatchSize {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = nil
+		case <-t.stop:
+		drain:
+			for {
+				select {
+				case span := <-t.spans:
+					batch = append(batch, span)
+				default:
+					break drain
+				}
+			}
+			for len(batch) > 0 {
+				n := min(len(batch), traceBatchSize)
+				t.export(batch[:n])
+				batch = batch[n:]
+			}
+			return
+		}
+	}
+}
+
+// export sends spans to the collector. Failures are logged and the spans
+// dropped; tracing never holds up the cache.
+func (t *tracer) export(spans []otlpSpan) {
+	if dropped := t.dropped.Swap(0); dropped > 0 {
+		slog.Warn("tracing", "op", "drop", "spans", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{stringAttr("service.name", traceServiceName)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: traceServiceName}, Spans: spans}},
+	}}})
+	if err == nil {
+		err = t.post(body)
+	}
+	if err != nil {
+		slog.Warn("tracing", "op", "export", "spans", len(spans), "err", err)
+	}
+}
+
+func (t *tracer) post(body []byte) error {
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below are the parts of the OTLP/JSON trace export request that
+// the tracer uses.
+
+const (
+	otlpSpanKindServer = 2
+	otlpStatusError    = 2
+)
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue holds exactly one of its fields. 64-bit integers are strings in
+// OTLP/JSON.
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    string  `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func stringAttr(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int64) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: strconv.FormatInt(value, 10)}}
+}
+
+func boolAttr(key string, value bool) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{BoolValue: &value}}
+}
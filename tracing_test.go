@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSpanCacheHit(t *testing.T) {
+	tests := []struct {
+		status int
+		source string
+		hit    bool
+	}{
+		{http.StatusOK, "", true},
+		{http.StatusOK, "cache", true},
+		{http.StatusOK, "origin", false},
+		{http.StatusOK, "default", false},
+		{http.StatusNotFound, "", false},
+	}
+	for _, tt := range tests {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/cache/k", nil), map[string]string{"key": "k"})
+		rec := &statusRecorder{ResponseWriter: httptest.NewRecorder(), status: tt.status}
+		if tt.source != "" {
+			rec.Header().Set("X-Cache-Source", tt.source)
+		}
+
+		span := (&tracer{}).span(r, rec)
+		var hit *bool
+		for _, attr := range span.Attributes {
+			if attr.Key == "cache.hit" {
+				hit = attr.Value.BoolValue
+			}
+		}
+		if hit == nil {
+			t.Errorf("status %d, source %q: no cache.hit attribute", tt.status, tt.source)
+		} else if *hit != tt.hit {
+			t.Errorf("status %d, source %q: cache.hit = %v, want %v", tt.status, tt.source, *hit, tt.hit)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// ErrInvalidOp is returned by Transaction for an Op of an unknown kind.
+var ErrInvalidOp = errors.New("invalid op")
+
+// OpKind says what an Op in a Transaction does.
+type OpKind string
+
+const (
+	OpSet    OpKind = "set"
+	OpDelete OpKind = "delete"
+)
+
+// Op is a single write in a Transaction: a Set of Value under Key for
+// Expiration, or a Delete of Key.
+type Op[V any] struct {
+	Kind       OpKind
+	Key        string
+	Value      V
+	Expiration time.Duration
+}
+
+// Transaction applies ops in order under a single lock acquisition, so
+// that no reader sees some of them applied and others not. It is
+// all-or-nothing as to validation: every op is checked first, as Set and
+// Delete would check it, and if any fails, for an unknown kind, a key that
+// KeyRules reject or a value over MaxValueBytes, none is applied and the
+// error, naming the op, is returned. Once checked, a set or delete can't
+// fail, so the rest always apply, though what they leave behind is still
+// subject to capacity eviction afterwards like any other write: a batch
+// that outgrows the cache evicts some of it, and a set never revives a key
+// that isn't there. Deleting an absent key is not an error. Under
+// PinnedReject, a batch that would need to evict a pinned entry to make room
+// for a new key fails with ErrAllPinned, naming that op, and none is applied.
+//
+// The WAL, if attached, journals the ops one by one, so a crash part way
+// through writing them can replay only some.
+func (c *LRUCache[V]) Transaction(ops []Op[V]) error {
+	for i, op := range ops {
+		if err := c.checkOp(op); err != nil {
+			return fmt.Errorf("op %d: %w", i, err)
+		}
+	}
+	c.mutex.Lock()
+	defer c.unlock()
+
+	if err := c.checkRoomOps(ops, nil); err != nil {
+		return err
+	}
+	for _, op := range ops {
+		c.applyOpLocked(op)
+	}
+	slog.Debug("cache", "op", "txn", "ops", len(ops))
+	return nil
+}
+
+// checkOp returns the error applyOpLocked would have for op, if any.
+func (c *LRUCache[V]) checkOp(op Op[V]) error {
+	switch op.Kind {
+	case OpSet:
+		return c.checkWrite(op.Key, op.Value)
+	case OpDelete:
+		return nil
+	}
+	return fmt.Errorf("%w %q: must be set or delete", ErrInvalidOp, op.Kind)
+}
+
+// applyOpLocked applies an op that checkOp has accepted. The caller must hold
+// c.mutex for writing.
+func (c *LRUCache[V]) applyOpLocked(op Op[V]) {
+	switch op.Kind {
+	case OpSet:
+		c.setLocked(op.Key, op.Value, c.jitter(op.Expiration))
+	case OpDelete:
+		if ent, ok := c.cache[op.Key]; ok {
+			c.deleteLocked(ent)
+		}
+	}
+}
+
+// Transaction is LRUCache.Transaction across shards: it locks every shard
+// the ops touch, in shard order so that concurrent transactions can't
+// deadlock, and applies the ops before releasing any of them. Evictions are
+// reported once all are released.
+func (s *ShardedLRUCache[V]) Transaction(ops []Op[V]) error {
+	locked := make(map[int]bool)
+	for i, op := range ops {
+		if err := s.shard(op.Key).checkOp(op); err != nil {
+			return fmt.Errorf("op %d: %w", i, err)
+		}
+		locked[s.shardIndex(op.Key)] = true
+	}
+	order := make([]int, 0, len(locked))
+	for i := range locked {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+
+	for _, i := range order {
+		s.shards[i].mutex.Lock()
+	}
+	for _, i := range order {
+		mine := func(key string) bool { return s.shardIndex(key) == i }
+		if err := s.shards[i].checkRoomOps(ops, mine); err != nil {
+			for _, j := range order {
+				s.shards[j].unlock()
+			}
+			return err
+		}
+	}
+	for _, op := range ops {
+		s.shard(op.Key).applyOpLocked(op)
+	}
+	evicted := make([][]evictedEntry[V], len(order))
+	for j, i := range order {
+		evicted[j] = s.shards[i].release()
+	}
+	for j, i := range order {
+		s.shards[i].deliver(evicted[j])
+	}
+	slog.Debug("cache", "op", "txn", "ops", len(ops), "shards", len(order))
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// limitShard sets the write limits the rollback tests break on c.
+func limitShard(c *LRUCache[string]) {
+	c.MaxValueBytes = 10
+	c.KeyRules.Pattern, _ = compileKeyPattern(`[a-z0-9:]+`)
+}
+
+// TestTransactionRollback runs transactions with one bad op after good
+// ones and checks that none of the ops is applied, on a single cache and
+// across shards.
+func TestTransactionRollback(t *testing.T) {
+	caches := map[string]func() Cache[string]{
+		"lru": func() Cache[string] {
+			c := NewLRUCache[string](100)
+			limitShard(c)
+			return c
+		},
+		"sharded": func() Cache[string] {
+			s := NewShardedLRUCache[string](100, 8)
+			for _, shard := range s.shards {
+				limitShard(shard)
+			}
+			return s
+		},
+	}
+	tests := []struct {
+		name string
+		bad  Op[string]
+		want error
+	}{
+		{"unknown kind", Op[string]{Kind: "rename", Key: "x"}, ErrInvalidOp},
+		{"invalid key", Op[string]{Kind: OpSet, Key: "bad key", Value: "v"}, ErrInvalidKey},
+		{"value too large", Op[string]{Kind: OpSet, Key: "x", Value: strings.Repeat("v", 100)}, ErrValueTooLarge},
+	}
+	for name, newCache := range caches {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				c := newCache()
+				c.Set("a", "a0", 0)
+				c.Set("b", "b0", 0)
+
+				err := c.Transaction([]Op[string]{
+					{Kind: OpSet, Key: "a", Value: "a1"},
+					{Kind: OpDelete, Key: "b"},
+					{Kind: OpSet, Key: "c", Value: "c1"},
+					tt.bad,
+				})
+				if !errors.Is(err, tt.want) || !strings.HasPrefix(err.Error(), "op 3: ") {
+					t.Fatalf("err = %v, want op 3 failing with %v", err, tt.want)
+				}
+				checkUntouched(t, c)
+			})
+		}
+	}
+}
+
+// TestTransactionRollbackPinned fills a cache with pinned entries and
+// checks that a batch needing room for a new key applies none of its ops.
+func TestTransactionRollbackPinned(t *testing.T) {
+	c := NewLRUCache[string](2)
+	c.Set("a", "a0", 0)
+	c.Set("b", "b0", 0)
+	c.Pin("a")
+	c.Pin("b")
+
+	err := c.Transaction([]Op[string]{
+		{Kind: OpSet, Key: "a", Value: "a1"},
+		{Kind: OpSet, Key: "b", Value: "b1"},
+		{Kind: OpDelete, Key: "x"},
+		{Kind: OpSet, Key: "c", Value: "c1"},
+	})
+	if !errors.Is(err, ErrAllPinned) || !strings.HasPrefix(err.Error(), "op 3: ") {
+		t.Fatalf("err = %v, want op 3 failing with ErrAllPinned", err)
+	}
+	checkUntouched(t, c)
+}
+
+// checkUntouched fails t unless c still holds a and b as set before the
+// rollback tests' batches, and not c.
+func checkUntouched(t *testing.T, c Cache[string]) {
+	t.Helper()
+	if v, _ := c.Get("a"); v != "a0" {
+		t.Errorf("a = %q, want a0 untouched", v)
+	}
+	if v, ok := c.Get("b"); !ok || v != "b0" {
+		t.Errorf("b = %q, %v; want b0 untouched", v, ok)
+	}
+	if c.Contains("c") {
+		t.Error("c was set")
+	}
+}
+
+// TestTransactionConflictingOps checks that ops on the same key apply in
+// order, so the last one wins.
+func TestTransactionConflictingOps(t *testing.T) {
+	c := NewShardedLRUCache[string](100, 8)
+	c.Set("a", "a0", 0)
+	err := c.Transaction([]Op[string]{
+		{Kind: OpDelete, Key: "a"},
+		{Kind: OpSet, Key: "a", Value: "a1"},
+		{Kind: OpSet, Key: "b", Value: "b1"},
+		{Kind: OpDelete, Key: "b"},
+		{Kind: OpSet, Key: "c", Value: "c1"},
+		{Kind: OpSet, Key: "c", Value: "c2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Get("a"); v != "a1" {
+		t.Errorf("a = %q, want a1", v)
+	}
+	if c.Contains("b") {
+		t.Error("b survived its delete")
+	}
+	if v, _ := c.Get("c"); v != "c2" {
+		t.Errorf("c = %q, want c2", v)
+	}
+}
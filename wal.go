@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WAL operations.
+const (
+	walOpSet    = "set"
+	walOpDelete = "delete"
+	walOpClear  = "clear"
+)
+
+// walCheckpointInterval is how often an attached WAL is checked against its
+// size limit.
+const walCheckpointInterval = time.Second
+
+// walRecord is one line of the write-ahead log. ExpiresAt is absolute, so a
+// replay honours the TTL the write was made with rather than restarting it;
+// it is the zero time for an entry that never expires.
+type walRecord[V any] struct {
+	Op    string       `json:"op"`
+	Key   string       `json:"key,omitempty"`
+	Value jsonValue[V] `json:"value"`
+	// ContentType is set for blobs; see persistValue.
+	ContentType string    `json:"content_type,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// WAL is an append-only log of cache writes, one JSON record per line.
+//
+// The active log lives at path. When a checkpoint runs, the active log is
+// renamed to a segment named path.<unix-nanos> and a fresh one is started;
+// once the snapshot covering those segments has been written they are
+// deleted. Until then ReplayWAL reads the segments, oldest first, followed by
+// the active log, so a crash at any point loses nothing that was appended.
+//
+// Records are encoded and written once the cache lock is released, so file
+// I/O never holds up the cache, but in the order the cache numbered them
+// under the lock: a record that arrives ahead of its turn waits for the
+// ones before it, and is written by whichever writer completes the run.
+// Each record is written to the file as soon as its turn comes, which makes
+// it survive a crash of the process, but the file is not fsynced per record,
+// so an operating system crash may lose the most recent writes.
+type WAL[V any] struct {
+	// checkpoint serialises Checkpoint calls, so that an older snapshot can
+	// never overwrite a newer one.
+	checkpoint sync.Mutex
+
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	w        *bufio.Writer
+	size     int64
+	// next is the sequence number of the next record to write, and pending
+	// holds the encoded records that arrived before their turn; a nil line
+	// is a record that failed to encode and is skipped. While cutting, the
+	// records after cut are held back until rotate has started a new file.
+	next    uint64
+	pending map[uint64][]byte
+	cutting bool
+	cut     uint64
+	// turn is signalled whenever next advances.
+	turn *sync.Cond
+}
+
+// walEntry is a journaled record along with its sequence number.
+type walEntry[V any] struct {
+	seq uint64
+	rec walRecord[V]
+}
+
+// OpenWAL opens, creating if needed, the log at path for appending. When
+// maxBytes is positive, an attached cache checkpoints the log once it grows
+// past that size.
+func OpenWAL[V any](path string, maxBytes int64) (*WAL[V], error) {
+	l := &WAL[V]{path: path, maxBytes: maxBytes, next: 1, pending: make(map[uint64][]byte)}
+	l.turn = sync.NewCond(&l.mu)
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *WAL[V]) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.w = bufio.NewWriter(f)
+	l.size = info.Size()
+	return nil
+}
+
+// write encodes entries and writes them to the log, along with any records
+// that were waiting for them, once the records numbered before them have
+// been written. It returns the first error; a record that fails to encode
+// or to be written is lost, but doesn't stop the ones after it.
+func (l *WAL[V]) write(entries []walEntry[V]) error {
+	var firstErr error
+	lines := make([][]byte, len(entries))
+	for i, e := range entries {
+		line, err := json.Marshal(e.rec)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("record %s %q: %w", e.rec.Op, e.rec.Key, err)
+		}
+		lines[i] = line
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, e := range entries {
+		l.pending[e.seq] = lines[i]
+	}
+	if err := l.drainLocked(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// drainLocked writes the pending records whose turn has come and flushes
+// them. The caller must hold l.mu.
+func (l *WAL[V]) drainLocked() error {
+	var err error
+	wrote := false
+	for {
+		if l.cutting && l.next > l.cut {
+			break
+		}
+		line, ok := l.pending[l.next]
+		if !ok {
+			break
+		}
+		delete(l.pending, l.next)
+		l.next++
+		wrote = true
+		if line == nil {
+			continue
+		}
+		l.w.Write(line)
+		l.w.WriteByte('\n')
+		l.size += int64(len(line)) + 1
+	}
+	if wrote {
+		err = l.w.Flush()
+		l.turn.Broadcast()
+	}
+	return err
+}
+
+// full reports whether the log has outgrown its size limit.
+func (l *WAL[V]) full() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.maxBytes > 0 && l.size > l.maxBytes
+}
+
+// rotate waits until every record up to and including cut has been
+// written, then moves the active log aside as a segment and starts a new
+// one for the records after cut, returning the segment's name.
+func (l *WAL[V]) rotate(cut uint64) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cutting, l.cut = true, cut
+	defer func() {
+		l.cutting = false
+		if err := l.drainLocked(); err != nil {
+			slog.Error("cache", "op", "wal_append", "err", err)
+		}
+	}()
+	for l.next <= cut {
+		l.turn.Wait()
+	}
+
+	if err := l.w.Flush(); err != nil {
+		return "", err
+	}
+	if err := l.file.Close(); err != nil {
+		return "", err
+	}
+	segment := fmt.Sprintf("%s.%020d", l.path, time.Now().UnixNano())
+	if err := os.Rename(l.path, segment); err != nil {
+		return "", err
+	}
+	return segment, l.open()
+}
+
+// Close flushes and closes the log.
+func (l *WAL[V]) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.w.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// walSegments returns the rotated segments of the log at path, oldest first.
+func walSegments(path string) ([]string, error) {
+	segments, err := filepath.Glob(path + ".[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// ReplayWAL applies the records of the log at path, including any segments
+// left by an unfinished checkpoint, in the order they were written, and
+// returns how many were applied. A set whose recorded expiration has passed
+// is applied as a delete, since the key it wrote would have expired by now.
+// A torn last line, as left by a crash mid-append, ends the replay of that
+// file. ReplayWAL must run before the log is attached, or the replayed
+// writes would be journaled again.
+func (c *LRUCache[V]) ReplayWAL(path string) (int, error) {
+	files, err := walSegments(path)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+
+	applied := 0
+	for _, file := range files {
+		n, err := c.replayFile(file)
+		applied += n
+		if err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
+
+func (c *LRUCache[V]) replayFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	c.mutex.Lock()
+	defer c.unlock()
+
+	applied := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec walRecord[V]
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			slog.Warn("cache", "op", "wal_replay", "path", path, "err", err, "stopped", true)
+			break
+		}
+		c.applyLocked(rec)
+		applied++
+	}
+	return applied, scanner.Err()
+}
+
+func (c *LRUCache[V]) applyLocked(rec walRecord[V]) {
+	switch rec.Op {
+	case walOpSet:
+		// A zero ExpiresAt, and so a zero ttl, means the entry never expires.
+		var ttl time.Duration
+		if !rec.ExpiresAt.IsZero() {
+			ttl = time.Until(rec.ExpiresAt)
+			if ttl <= 0 {
+				if ent, ok := c.cache[rec.Key]; ok {
+					c.removeEntry(ent)
+				}
+				return
+			}
+		}
+		c.setLocked(rec.Key, restoreValue(rec.Value, rec.ContentType), ttl)
+	case walOpDelete:
+		if ent, ok := c.cache[rec.Key]; ok {
+			c.removeEntry(ent)
+		}
+	case walOpClear:
+		c.cache = make(map[string]*entry[V])
+		c.head, c.tail, c.lfu = nil, nil, nil
+		c.size, c.bytes = 0, 0
+		c.contentTypes = nil
+		c.pinned = 0
+		c.expirySum, c.expiring = 0, 0
+		c.expiries = nil
+		c.tombstones = nil
+		c.negatives = nil
+	}
+}
+
+// AttachWAL starts journaling every write to l and compacts it into the
+// snapshot at snapshotPath: once immediately, so that a log just replayed
+// doesn't have to be replayed again, and thereafter whenever it outgrows its
+// size limit.
+func (c *LRUCache[V]) AttachWAL(l *WAL[V], snapshotPath string) error {
+	c.mutex.Lock()
+	c.wal = l
+	l.mu.Lock()
+	l.next = c.walSeq + 1
+	l.mu.Unlock()
+	c.unlock()
+
+	if err := c.Checkpoint(snapshotPath); err != nil {
+		return err
+	}
+	c.every("wal-checkpoint", walCheckpointInterval, func() {
+		if !l.full() {
+			return
+		}
+		if err := c.Checkpoint(snapshotPath); err != nil {
+			slog.Error("cache", "op", "wal_checkpoint", "err", err)
+		}
+	})
+	return nil
+}
+
+// Checkpoint writes a snapshot to snapshotPath and discards the log records
+// it covers. The snapshot is copied under the read lock, along with the
+// number of the last record journaled; the log is then rotated at that
+// record, so every write lands either in the snapshot or in the new log.
+// Rotating, encoding and writing the snapshot happen after the lock is
+// released. Without an attached WAL this is just SaveSnapshot.
+func (c *LRUCache[V]) Checkpoint(snapshotPath string) error {
+	start := time.Now()
+	c.mutex.RLock()
+	wal := c.wal
+	c.mutex.RUnlock()
+	if wal != nil {
+		wal.checkpoint.Lock()
+		defer wal.checkpoint.Unlock()
+	}
+
+	c.mutex.RLock()
+	snap := c.snapshotLocked()
+	cut := c.walSeq
+	c.mutex.RUnlock()
+	var segment string
+	var err error
+	if wal != nil {
+		segment, err = wal.rotate(cut)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeSnapshot(snapshotPath, snap); err != nil {
+		return err
+	}
+	if wal == nil {
+		return nil
+	}
+
+	segments, err := walSegments(wal.path)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s > segment {
+			break
+		}
+		if err := os.Remove(s); err != nil {
+			return err
+		}
+	}
+	slog.Info("cache", "op", "wal_checkpoint", "path", snapshotPath, "duration", time.Since(start))
+	return nil
+}
+
+// journalSet records the current state of ent in the WAL, if one is
+// attached. The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) journalSet(ent *entry[V]) {
+	value, contentType := persistValue(ent.value)
+	c.journal(walRecord[V]{Op: walOpSet, Key: ent.key, Value: value, ContentType: contentType, ExpiresAt: ent.expiration})
+}
+
+// journalDelete records the deletion of key in the WAL, if one is attached.
+// The caller must hold c.mutex for writing.
+func (c *LRUCache[V]) journalDelete(key string) {
+	c.journal(walRecord[V]{Op: walOpDelete, Key: key})
+}
+
+// journal queues rec for the attached WAL, numbered in the order the writes
+// were applied; release writes it once the lock is released. A failed
+// append is logged but doesn't fail the write. The caller must hold c.mutex
+// for writing.
+func (c *LRUCache[V]) journal(rec walRecord[V]) {
+	if c.wal == nil {
+		return
+	}
+	c.walSeq++
+	c.journaled = append(c.journaled, walEntry[V]{seq: c.walSeq, rec: rec})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeWALFile writes recs to path as a log, one JSON line each, followed
+// by tail verbatim.
+func writeWALFile(t *testing.T, path string, tail string, recs ...walRecord[string]) {
+	t.Helper()
+	var b strings.Builder
+	for _, rec := range recs {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	b.WriteString(tail)
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func walSet(key, value string, expiresAt time.Time) walRecord[string] {
+	return walRecord[string]{Op: walOpSet, Key: key, Value: jsonValue[string]{V: value}, ExpiresAt: expiresAt}
+}
+
+// checkState fails t unless c holds exactly want.
+func checkState(t *testing.T, c *LRUCache[string], want map[string]string) {
+	t.Helper()
+	if got := c.Len(); got != len(want) {
+		t.Errorf("cache holds %d keys %v, want %d", got, c.Keys(), len(want))
+	}
+	for key, value := range want {
+		if got, ok := c.Peek(key); !ok || got != value {
+			t.Errorf("%s = %q, %v; want %q", key, got, ok, value)
+		}
+	}
+}
+
+func TestReplayWALOrder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wal")
+	soon := time.Now().Add(time.Hour)
+	// A segment left by an unfinished checkpoint comes before the active
+	// log, whatever the order of the records' keys.
+	writeWALFile(t, path+".00000000000000000001", "",
+		walSet("a", "1", time.Time{}),
+		walSet("b", "1", soon),
+		walSet("c", "1", time.Time{}),
+	)
+	writeWALFile(t, path+".00000000000000000002", "",
+		walSet("a", "2", time.Time{}),
+		walRecord[string]{Op: walOpDelete, Key: "c"},
+	)
+	writeWALFile(t, path, "",
+		walSet("b", "2", soon),
+		walRecord[string]{Op: walOpClear},
+		walSet("d", "1", time.Time{}),
+		walSet("a", "3", time.Time{}),
+	)
+
+	c := NewLRUCache[string](10)
+	n, err := c.ReplayWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9 {
+		t.Errorf("applied %d records, want 9", n)
+	}
+	checkState(t, c, map[string]string{"a": "3", "d": "1"})
+	if keys := strings.Join(c.Keys(), ","); keys != "a,d" {
+		t.Errorf("recency order %s, want a,d", keys)
+	}
+}
+
+func TestReplayWALExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	past := time.Now().Add(-time.Minute)
+	writeWALFile(t, path, "",
+		walSet("a", "1", time.Time{}),
+		walSet("b", "1", time.Time{}),
+		// An expired set drops the key it would have overwritten.
+		walSet("a", "2", past),
+		walSet("c", "1", past),
+		walSet("d", "1", time.Now().Add(time.Hour)),
+	)
+
+	c := NewLRUCache[string](10)
+	if _, err := c.ReplayWAL(path); err != nil {
+		t.Fatal(err)
+	}
+	checkState(t, c, map[string]string{"b": "1", "d": "1"})
+	if item, _ := c.PeekItem("d"); time.Until(item.Expiration) > time.Hour {
+		t.Errorf("d expires at %v, later than recorded", item.Expiration)
+	}
+}
+
+func TestReplayWALTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+	writeWALFile(t, path, `{"op":"set","key":"c","val`,
+		walSet("a", "1", time.Time{}),
+		walSet("b", "1", time.Time{}),
+	)
+
+	c := NewLRUCache[string](10)
+	n, err := c.ReplayWAL(path)
+	if err != nil {
+		t.Fatalf("torn last line failed the replay: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("applied %d records, want 2", n)
+	}
+	checkState(t, c, map[string]string{"a": "1", "b": "1"})
+}
+
+// recoverState loads a fresh cache from snapshot and the log at walPath, as
+// loadState does at startup.
+func recoverState(t *testing.T, snapshot, walPath string) *LRUCache[string] {
+	t.Helper()
+	c := NewLRUCache[string](1000)
+	if _, err := c.RestoreSnapshot(snapshot); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if _, err := c.ReplayWAL(walPath); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestWALRotation(t *testing.T) {
+	dir := t.TempDir()
+	walPath, snapshot := filepath.Join(dir, "wal"), filepath.Join(dir, "snapshot")
+	l, err := OpenWAL[string](walPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewLRUCache[string](1000)
+	if err := c.AttachWAL(l, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	c.Set("a", "1", 0)
+	c.Set("b", "1", 0)
+	if err := c.Checkpoint(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	c.Set("b", "2", 0)
+	c.Delete("a")
+
+	if segments, _ := walSegments(walPath); len(segments) != 0 {
+		t.Errorf("segments %v left after the checkpoint", segments)
+	}
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("active log holds %d records, want the 2 after the checkpoint:\n%s", lines, data)
+	}
+	checkState(t, recoverState(t, snapshot, walPath), map[string]string{"b": "2"})
+}
+
+// TestWALCheckpointUnderWrites checkpoints while other goroutines write and
+// checks that the snapshot and log recovered afterwards hold every write.
+func TestWALCheckpointUnderWrites(t *testing.T) {
+	dir := t.TempDir()
+	walPath, snapshot := filepath.Join(dir, "wal"), filepath.Join(dir, "snapshot")
+	l, err := OpenWAL[string](walPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewLRUCache[string](1000)
+	if err := c.AttachWAL(l, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	const writers, writes = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				key := "k" + strconv.Itoa((w*writes+i)%100)
+				if i%5 == 4 {
+					c.Delete(key)
+				} else {
+					c.Set(key, strconv.Itoa(w)+"-"+strconv.Itoa(i), 0)
+				}
+			}
+		}(w)
+	}
+	for i := 0; i < 5; i++ {
+		if err := c.Checkpoint(snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := make(map[string]string)
+	for _, key := range c.Keys() {
+		want[key], _ = c.Peek(key)
+	}
+	checkState(t, recoverState(t, snapshot, walPath), want)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// warmer fills the cache from origin URLs for POST /cache/warm. At most
+// concurrency fetches run at once per request, each limited to timeout.
+// The server fetches whatever URLs it is given, so the endpoint should only
+// be reachable by trusted clients; see -auth-token.
+type warmer struct {
+	client        *http.Client
+	concurrency   int
+	timeout       time.Duration
+	defaultTTL    time.Duration
+	maxValueBytes int64
+}
+
+func newWarmer(concurrency int, timeout, defaultTTL time.Duration, maxValueBytes int64) *warmer {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &warmer{
+		client:        &http.Client{},
+		concurrency:   concurrency,
+		timeout:       timeout,
+		defaultTTL:    defaultTTL,
+		maxValueBytes: maxValueBytes,
+	}
+}
+
+type warmItem struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+	TTL string `json:"ttl"`
+}
+
+type warmResult struct {
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// fetch downloads u as a value to store, giving up after the timeout.
+func (wm *warmer) fetch(ctx context.Context, u string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, wm.timeout)
+	defer cancel()
+	return fetchValue(ctx, wm.client, u, wm.maxValueBytes)
+}
+
+// handler warms the cache from a JSON array of {key, url, ttl} entries,
+// fetching the URLs concurrently and storing each body under its key. The
+// response lists every entry as "ok" or "error" in request order and uses
+// 207 Multi-Status if any failed. Entries whose TTL doesn't parse fail
+// without being fetched; an omitted TTL means the default.
+func (wm *warmer) handler(cache Cache[interface{}]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var items []warmItem
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidPayload, "invalid request payload: "+err.Error())
+			return
+		}
+
+		slog.Debug("request", "op", "warm", "keys", len(items))
+
+		results := make([]warmResult, len(items))
+		jobs := make(chan int)
+		var wg sync.WaitGroup
+		for i := 0; i < wm.concurrency && i < len(items); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range jobs {
+					results[j] = wm.warm(r.Context(), cache, items[j])
+				}
+			}()
+		}
+		for i := range items {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+
+		status := http.StatusOK
+		for _, res := range results {
+			if res.Status != "ok" {
+				status = http.StatusMultiStatus
+				break
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// warm fetches and stores a single entry.
+func (wm *warmer) warm(ctx context.Context, cache Cache[interface{}], item warmItem) warmResult {
+	res := warmResult{Key: item.Key, URL: item.URL, Status: "ok"}
+	ttl, err := parseTTLValue(item.TTL, wm.defaultTTL)
+	if err == nil && item.Key == "" {
+		err = errors.New("missing key")
+	}
+	if err == nil {
+		var value interface{}
+		if value, err = wm.fetch(ctx, item.URL); err == nil {
+			err = cache.SetCtx(ctx, item.Key, value, ttl)
+		}
+	}
+	if err != nil {
+		slog.Warn("cache", "op", "warm", "key", item.Key, "url", item.URL, "err", err)
+		res.Status, res.Error = "error", err.Error()
+	}
+	return res
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// webhookQueueSize is how many notifications may wait for delivery.
+	// Ones raised while the queue is full are dropped rather than slowing
+	// down the cache.
+	webhookQueueSize = 1024
+	// webhookAttempts is how many times a notification is posted before it
+	// is given up on; attempts after the first wait webhookRetryDelay,
+	// doubling each time.
+	webhookAttempts   = 3
+	webhookRetryDelay = 500 * time.Millisecond
+	// webhookDrainTimeout bounds how long Close keeps delivering the
+	// notifications still queued.
+	webhookDrainTimeout = 5 * time.Second
+)
+
+// expiryNotification is the JSON body posted by expiryNotifier.
+type expiryNotification struct {
+	Key       string    `json:"key"`
+	Namespace string    `json:"namespace,omitempty"`
+	Reason    string    `json:"reason"`
+	Time      time.Time `json:"time"`
+}
+
+// expiryNotifier posts a notification to a webhook for every entry that
+// expires, goes idle or is evicted to make room, as reported to
+// LRUCache.OnEvict; explicit deletes aren't notified. With prefixes set,
+// only keys starting with one of them are. Delivery is best-effort and
+// asynchronous: notifications wait in a bounded queue, each is tried
+// webhookAttempts times, and any that overflow the queue or keep failing
+// are dropped and counted in the logs. The order of delivery is the order
+// of the evictions, except that a retried notification holds up the ones
+// behind it.
+type expiryNotifier struct {
+	url      string
+	prefixes []string
+	client   *http.Client
+
+	queue   chan expiryNotification
+	dropped atomic.Uint64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newExpiryNotifier starts a notifier that posts to url. Call Close to
+// deliver the notifications still queued.
+func newExpiryNotifier(url string, prefixes []string) *expiryNotifier {
+	n := &expiryNotifier{
+		url:      url,
+		prefixes: prefixes,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		queue:    make(chan expiryNotification, webhookQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// onEvict returns an OnEvict callback that notifies for the cache of the
+// named namespace, or for the default cache if namespace is empty.
+func (n *expiryNotifier) onEvict(namespace string) func(key string, value interface{}, reason string) {
+	return func(key string, _ interface{}, reason string) {
+		n.notify(namespace, key, reason)
+	}
+}
+
+// notify queues a notification for key, if it matches the prefixes. It
+// never blocks.
+func (n *expiryNotifier) notify(namespace, key, reason string) {
+	if len(n.prefixes) > 0 && !hasAnyPrefix(key, n.prefixes) {
+		return
+	}
+	select {
+	case n.queue <- expiryNotification{Key: key, Namespace: namespace, Reason: reason, Time: time.Now().UTC()}:
+	default:
+		n.dropped.Add(1)
+	}
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close delivers the notifications still queued, for up to
+// webhookDrainTimeout and without retries, and stops the notifier.
+// Notifications raised afterwards are dropped.
+func (n *expiryNotifier) Close() {
+	close(n.stop)
+	<-n.done
+}
+
+// run delivers queued notifications until Close is called.
+func (n *expiryNotifier) run() {
+	defer close(n.done)
+	for {
+		select {
+		case note := <-n.queue:
+			n.deliver(note, webhookAttempts)
+		case <-n.stop:
+			deadline := time.Now().Add(webhookDrainTimeout)
+		drain:
+			for time.Now().Before(deadline) {
+				select {
+				case note := <-n.queue:
+					n.deliver(note, 1)
+				default:
+					break drain
+				}
+			}
+			if left := len(n.queue); left > 0 {
+				n.dropped.Add(uint64(left))
+			}
+			n.logDropped()
+			return
+		}
+	}
+}
+
+// deliver posts note up to attempts times, stopping early on success or
+// when the notifier is closed.
+func (n *expiryNotifier) deliver(note expiryNotification, attempts int) {
+	n.logDropped()
+	body, err := json.Marshal(note)
+	if err != nil {
+		return
+	}
+	delay := webhookRetryDelay
+	attempt := 1
+retry:
+	for ; ; attempt++ {
+		if err = n.post(body); err == nil {
+			return
+		}
+		if attempt == attempts {
+			break
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-n.stop:
+			break retry
+		}
+	}
+	slog.Warn("webhook", "op", "notify", "key", note.Key, "namespace", note.Namespace, "attempts", attempt, "err", err)
+}
+
+// logDropped logs how many notifications were dropped since it last did.
+func (n *expiryNotifier) logDropped() {
+	if dropped := n.dropped.Swap(0); dropped > 0 {
+		slog.Warn("webhook", "op", "drop", "notifications", dropped)
+	}
+}
+
+func (n *expiryNotifier) post(body []byte) error {
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}